@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/smartnotes/user-service/internal/blob"
 	"github.com/smartnotes/user-service/internal/handlers"
+	"github.com/smartnotes/user-service/internal/jobs"
+	"github.com/smartnotes/user-service/internal/metadata"
 	"github.com/smartnotes/user-service/internal/middleware"
 	"github.com/smartnotes/user-service/internal/repositories"
 )
@@ -16,15 +24,45 @@ func main() {
 	// Load environment variables
 	_ = godotenv.Load() // Просто попытка загрузить .env, но не критично если его нет
 
-	// Initialize MongoDB connection
-	repo, err := repositories.NewMongoDBRepository()
+	// Initialize storage
+	repo, err := openRepository()
 	if err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+		log.Fatal("Failed to initialize storage:", err)
+	}
+
+	provider, err := openMetadataProvider(repo)
+	if err != nil {
+		log.Fatal("Failed to initialize ISBN metadata:", err)
+	}
+
+	blobs, err := openBlobStore(repo)
+	if err != nil {
+		log.Fatal("Failed to initialize file storage:", err)
 	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(repo)
-	bookHandler := handlers.NewBookHandler(repo)
+	bookHandler := handlers.NewBookHandler(repo, repo, repo, provider)
+	noteHandler := handlers.NewNoteHandler(repo, repo, repo)
+	trashHandler := handlers.NewTrashHandler(repo, repo, blobs)
+	revisionHandler := handlers.NewRevisionHandler(repo, repo)
+	importHandler := handlers.NewImportHandler(repo, repo, repo, repo, repo, jobs.NewImports())
+	exportHandler := handlers.NewExportHandler(repo, repo, repo, repo, repo)
+	metadataHandler := handlers.NewMetadataHandler(provider)
+	coverHandler := handlers.NewCoverHandler(repo, blobs)
+	attachmentHandler := handlers.NewAttachmentHandler(repo, repo, repo, blobs)
+	readingHandler := handlers.NewReadingHandler(repo, repo, repo)
+	statsHandler := handlers.NewStatsHandler(repo, repo)
+	shelfHandler := handlers.NewShelfHandler(repo, repo, repo)
+	tagHandler := handlers.NewTagHandler(repo, repo, repo)
+
+	// Empty the trash of books deleted longer ago than TRASH_RETENTION
+	retention := 30 * 24 * time.Hour
+	if d, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil && d > 0 {
+		retention = d
+	}
+	go jobs.PurgeTrash(context.Background(), repo, blobs, retention, min(retention, time.Hour))
+	go jobs.PurgeUploads(context.Background(), repo, blobs, time.Hour)
 
 	// Create router
 	router := gin.Default()
@@ -37,32 +75,137 @@ func main() {
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "If-Match", "If-None-Match", "Upload-Offset"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "Location", "Upload-Offset", "Content-Disposition"},
 		AllowCredentials: true,
 	}))
 
 	// Public routes
 	router.POST("/api/auth/register", authHandler.Register)
 	router.POST("/api/auth/login", authHandler.Login)
+	router.POST("/api/auth/refresh", authHandler.Refresh)
 
 	// Protected routes
 	auth := router.Group("/api/auth")
-	auth.Use(middleware.AuthMiddleware())
+	auth.Use(middleware.AuthMiddleware(repo))
 	{
 		auth.GET("/me", authHandler.GetCurrentUser)
+		auth.POST("/logout", authHandler.Logout)
+		auth.POST("/logout-all", authHandler.LogoutAll)
 	}
 
 	// Book routes
 	books := router.Group("/api/books")
-	books.Use(middleware.AuthMiddleware())
+	books.Use(middleware.AuthMiddleware(repo))
 	{
 		books.POST("", bookHandler.CreateBook)
 		books.GET("", bookHandler.ListBooks)
+		books.POST("/batch", bookHandler.Batch)
+		books.GET("/cite", bookHandler.CiteBooks)
 		books.GET("/:id", bookHandler.GetBook)
 		books.PUT("/:id", bookHandler.UpdateBook)
+		books.PATCH("/:id", bookHandler.PatchBook)
 		books.DELETE("/:id", bookHandler.DeleteBook)
+		books.GET("/:id/cite", bookHandler.CiteBook)
+		books.PUT("/:id/cover", coverHandler.UploadCover)
+		books.GET("/:id/cover", coverHandler.GetCover)
+		books.GET("/:id/cover/:size", coverHandler.GetCover)
+		books.DELETE("/:id/cover", coverHandler.DeleteCover)
+		books.GET("/:id/attachments", attachmentHandler.ListAttachments)
+		books.POST("/:id/attachments", attachmentHandler.UploadAttachment)
+		books.POST("/:id/attachments/uploads", attachmentHandler.CreateUpload)
+		books.GET("/:id/attachments/uploads/:uploadId", attachmentHandler.GetUpload)
+		books.PATCH("/:id/attachments/uploads/:uploadId", attachmentHandler.PatchUpload)
+		books.DELETE("/:id/attachments/uploads/:uploadId", attachmentHandler.CancelUpload)
+		books.GET("/:id/attachments/:attachmentId", attachmentHandler.DownloadAttachment)
+		books.DELETE("/:id/attachments/:attachmentId", attachmentHandler.DeleteAttachment)
+		books.GET("/:id/sessions", readingHandler.ListSessions)
+		books.POST("/:id/sessions", readingHandler.CreateSession)
+		books.DELETE("/:id/sessions/:sessionId", readingHandler.DeleteSession)
+		books.POST("/:id/restore", trashHandler.RestoreBook)
+		books.GET("/:id/revisions", revisionHandler.ListRevisions)
+		books.GET("/:id/revisions/:rev", revisionHandler.GetRevision)
+		books.POST("/:id/revisions/:rev/revert", revisionHandler.RevertBook)
+
+		books.POST("/:id/notes", noteHandler.CreateNote)
+		books.GET("/:id/notes", noteHandler.ListNotes)
+		books.GET("/:id/notes/:noteId", noteHandler.GetNote)
+		books.PUT("/:id/notes/:noteId", noteHandler.UpdateNote)
+		books.DELETE("/:id/notes/:noteId", noteHandler.DeleteNote)
+	}
+
+	// Trash routes
+	trash := router.Group("/api/trash")
+	trash.Use(middleware.AuthMiddleware(repo))
+	{
+		trash.GET("", trashHandler.ListTrash)
+		trash.DELETE("", trashHandler.EmptyTrash)
+		trash.DELETE("/:id", trashHandler.PurgeBook)
+	}
+
+	// Import routes
+	imports := router.Group("/api/import")
+	imports.Use(middleware.AuthMiddleware(repo))
+	{
+		imports.POST("/goodreads", importHandler.ImportGoodreads)
+		imports.POST("/kindle", importHandler.ImportKindle)
+		imports.POST("/json", importHandler.ImportJSON)
+		imports.GET("/jobs/:id", importHandler.GetJob)
+	}
+
+	// Export routes
+	export := router.Group("/api/export")
+	export.Use(middleware.AuthMiddleware(repo))
+	{
+		export.GET("", exportHandler.Export)
+	}
+
+	// Storage quota routes
+	storage := router.Group("/api/storage")
+	storage.Use(middleware.AuthMiddleware(repo))
+	{
+		storage.GET("", attachmentHandler.StorageUsage)
+	}
+
+	// ISBN lookup routes
+	isbn := router.Group("/api/isbn")
+	isbn.Use(middleware.AuthMiddleware(repo))
+	{
+		isbn.GET("/:isbn", metadataHandler.LookupISBN)
+	}
+
+	// Shelf routes
+	shelves := router.Group("/api/shelves")
+	shelves.Use(middleware.AuthMiddleware(repo))
+	{
+		shelves.GET("", shelfHandler.ListShelves)
+		shelves.POST("", shelfHandler.CreateShelf)
+		shelves.GET("/:id", shelfHandler.GetShelf)
+		shelves.PUT("/:id", shelfHandler.UpdateShelf)
+		shelves.DELETE("/:id", shelfHandler.DeleteShelf)
+		shelves.GET("/:id/books", shelfHandler.ListShelfBooks)
+		shelves.POST("/:id/books", shelfHandler.PlaceBook)
+		shelves.PUT("/:id/books", shelfHandler.ReorderBooks)
+		shelves.DELETE("/:id/books/:bookId", shelfHandler.RemoveBook)
+	}
+
+	// Tag routes
+	tags := router.Group("/api/tags")
+	tags.Use(middleware.AuthMiddleware(repo))
+	{
+		tags.GET("", tagHandler.ListTags)
+		tags.POST("/rename", tagHandler.RenameTag)
+		tags.POST("/merge", tagHandler.MergeTags)
+		tags.DELETE("/*tag", tagHandler.DeleteTag)
+	}
+
+	// Statistics routes
+	stats := router.Group("/api/stats")
+	stats.Use(middleware.AuthMiddleware(repo))
+	{
+		stats.GET("", statsHandler.ReadingStats)
+		stats.GET("/ratings", statsHandler.RatingStats)
 	}
 
 	// Start server
@@ -76,3 +219,101 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// openRepository picks the storage backend from the DATABASE_URL scheme:
+// postgres:// or postgresql:// for PostgreSQL, sqlite: for SQLite,
+// mongodb:// or mongodb+srv:// for MongoDB and memory: for in-process
+// storage. Without DATABASE_URL the STORAGE and MONGODB_URI variables are
+// used as before.
+func openRepository() (repositories.Repository, error) {
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		if os.Getenv("STORAGE") == "memory" {
+			url = "memory:"
+		} else if url = os.Getenv("MONGODB_URI"); url == "" {
+			url = "mongodb://localhost:27017"
+		}
+	}
+
+	scheme, rest, _ := strings.Cut(url, ":")
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		log.Println("Using PostgreSQL storage")
+		return repositories.NewPostgresRepository(url)
+	case "sqlite", "sqlite3":
+		// sqlite:///abs/path.db, sqlite://rel/path.db, sqlite:path.db or sqlite::memory:
+		path := strings.TrimPrefix(rest, "//")
+		log.Printf("Using SQLite storage at %s", path)
+		return repositories.NewSQLiteRepository(path)
+	case "mongodb", "mongodb+srv":
+		log.Println("Using MongoDB storage")
+		return repositories.NewMongoDBRepository(url)
+	case "memory":
+		log.Println("Using in-memory storage, data will be lost on restart")
+		return repositories.NewMemoryRepository(), nil
+	}
+	return nil, fmt.Errorf("unsupported DATABASE_URL scheme %q", scheme)
+}
+
+// openBlobStore stores files in GridFS when BLOB_STORE is "gridfs", which
+// needs MongoDB storage, in the S3-compatible bucket S3_BUCKET at
+// S3_ENDPOINT when it is "s3", and otherwise in the directory BLOB_DIR
+// (data/blobs by default). With MongoDB storage GridFS is the default.
+func openBlobStore(repo repositories.Repository) (blob.BlobStore, error) {
+	mongoRepo, isMongo := repo.(*repositories.MongoDBRepository)
+	kind := os.Getenv("BLOB_STORE")
+	if kind == "" && isMongo {
+		kind = "gridfs"
+	}
+
+	switch kind {
+	case "gridfs":
+		if !isMongo {
+			return nil, errors.New("BLOB_STORE=gridfs needs MongoDB storage")
+		}
+		log.Println("Storing files in GridFS")
+		return blob.NewGridFSStore(mongoRepo.Database(), "files")
+	case "s3":
+		endpoint, bucket := os.Getenv("S3_ENDPOINT"), os.Getenv("S3_BUCKET")
+		log.Printf("Storing files in the S3 bucket %s at %s", bucket, endpoint)
+		return blob.NewS3Store(endpoint, bucket, os.Getenv("S3_REGION"),
+			os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+	case "", "fs":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		log.Printf("Storing files in %s", dir)
+		return blob.NewFileStore(dir)
+	}
+	return nil, fmt.Errorf("unsupported BLOB_STORE %q", kind)
+}
+
+// openMetadataProvider chains the local dataset named by METADATA_DATASET,
+// if any, with the Open Library API at METADATA_URL (https://openlibrary.org
+// unless set, "off" to stay offline). API answers are cached in the
+// repository for METADATA_CACHE_TTL, 30 days by default.
+func openMetadataProvider(repo repositories.Repository) (metadata.MetadataProvider, error) {
+	var chain metadata.Chain
+	if path := os.Getenv("METADATA_DATASET"); path != "" {
+		dataset, err := metadata.LoadDataset(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d books from the ISBN dataset %s", dataset.Len(), path)
+		chain = append(chain, dataset)
+	}
+
+	url := os.Getenv("METADATA_URL")
+	if url == "" {
+		url = "https://openlibrary.org"
+	}
+	if url != "off" {
+		ttl := 30 * 24 * time.Hour
+		if d, err := time.ParseDuration(os.Getenv("METADATA_CACHE_TTL")); err == nil && d > 0 {
+			ttl = d
+		}
+		chain = append(chain, metadata.NewCached(metadata.NewOpenLibrary(url), repo, ttl))
+	}
+	return chain, nil
+}
@@ -0,0 +1,48 @@
+// Package blob stores binary files such as cover images and attachments
+// outside the database proper.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned when no blob is stored under a key.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps blobs under slash-separated keys such as
+// "covers/<book>/<hash>/small".
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing any blob there.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the blob under key, or ErrNotFound. Seeking lets
+	// http.ServeContent answer range requests.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the blob under key; a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every blob whose key starts with prefix, which
+	// must end in a slash, such as "covers/<book>/".
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// validKey rejects keys that could escape the store, such as "../x".
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, `\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// validPrefix accepts the keys of directories, such as "covers/<book>/".
+func validPrefix(prefix string) bool {
+	return strings.HasSuffix(prefix, "/") && validKey(strings.TrimSuffix(prefix, "/"))
+}
+
+var errInvalidKey = errors.New("invalid blob key")
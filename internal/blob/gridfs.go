@@ -0,0 +1,158 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSStore keeps blobs in a GridFS bucket, using the key as file name.
+type GridFSStore struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFSStore(db *mongo.Database, bucket string) (*GridFSStore, error) {
+	b, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucket))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSStore{bucket: b}, nil
+}
+
+func (s *GridFSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+	old, err := s.files(ctx, bson.M{"filename": key})
+	if err != nil {
+		return err
+	}
+	// Readers pick the newest revision of a name, so the old ones can go
+	// once the new one is complete
+	if _, err := s.bucket.UploadFromStream(key, r); err != nil {
+		return err
+	}
+	return s.deleteFiles(ctx, old)
+}
+
+func (s *GridFSStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	stream, err := s.bucket.OpenDownloadStreamByName(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gridFSReader{bucket: s.bucket, stream: stream, size: stream.GetFile().Length}, nil
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	files, err := s.files(ctx, bson.M{"filename": key})
+	if err != nil {
+		return err
+	}
+	return s.deleteFiles(ctx, files)
+}
+
+func (s *GridFSStore) DeletePrefix(ctx context.Context, prefix string) error {
+	if !validPrefix(prefix) {
+		return errInvalidKey
+	}
+	files, err := s.files(ctx, bson.M{"filename": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}})
+	if err != nil {
+		return err
+	}
+	return s.deleteFiles(ctx, files)
+}
+
+// files returns the IDs of every file matching filter, all revisions
+// included.
+func (s *GridFSStore) files(ctx context.Context, filter bson.M) ([]any, error) {
+	cursor, err := s.bucket.FindContext(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID any `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]any, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids, nil
+}
+
+func (s *GridFSStore) deleteFiles(ctx context.Context, ids []any) error {
+	for _, id := range ids {
+		if err := s.bucket.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// gridFSReader makes a download stream seekable. GridFS streams only read
+// forward, so seeking backwards reopens the file and skips ahead.
+type gridFSReader struct {
+	bucket *gridfs.Bucket
+	stream *gridfs.DownloadStream
+	size   int64
+	// pos is where the caller has seeked to, read where the stream is
+	pos, read int64
+}
+
+func (g *gridFSReader) Read(p []byte) (int, error) {
+	if g.pos >= g.size {
+		return 0, io.EOF
+	}
+	if g.pos != g.read {
+		if g.pos < g.read {
+			stream, err := g.bucket.OpenDownloadStream(g.stream.GetFile().ID)
+			if err != nil {
+				return 0, err
+			}
+			g.stream.Close()
+			g.stream, g.read = stream, 0
+		}
+		skipped, err := g.stream.Skip(g.pos - g.read)
+		g.read += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := g.stream.Read(p)
+	g.pos += int64(n)
+	g.read += int64(n)
+	return n, err
+}
+
+func (g *gridFSReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += g.pos
+	case io.SeekEnd:
+		offset += g.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	g.pos = offset
+	return offset, nil
+}
+
+func (g *gridFSReader) Close() error {
+	return g.stream.Close()
+}
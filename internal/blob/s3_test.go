@@ -0,0 +1,286 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stubBucket    = "books"
+	stubAccessKey = "AKIDEXAMPLE"
+	stubSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// s3Stub is a minimal S3 service holding one bucket in memory. It checks
+// every request's signature and lists at most pageSize keys at a time.
+type s3Stub struct {
+	t        *testing.T
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	lists   int
+}
+
+var authorization = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		s.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Path-style: the bucket is the first path segment, the key the rest
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+stubBucket)
+	if !ok || (key != "" && !strings.HasPrefix(key, "/")) {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		if r.Header.Get("X-Amz-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+			http.Error(w, "signed payload", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(body)) != r.ContentLength {
+			http.Error(w, "bad length", http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		body, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(body))
+	case r.Method == http.MethodDelete:
+		if _, ok := s.objects[key]; !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}
+}
+
+// list answers ListObjectsV2, continuing after the key in the token.
+func (s *s3Stub) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		http.Error(w, "not ListObjectsV2", http.StatusBadRequest)
+		return
+	}
+	s.lists++
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct{ Key string }
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []object
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	if len(keys) > s.pageSize {
+		keys = keys[:s.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, object{key})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify recomputes the request's Signature Version 4 from scratch.
+func (s *s3Stub) verify(r *http.Request) error {
+	m := authorization.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return errors.New("malformed Authorization header")
+	}
+	accessKey, date, region, signedHeaders, signature := m[1], m[2], m[3], m[4], m[5]
+	amzDate := r.Header.Get("X-Amz-Date")
+	if accessKey != stubAccessKey || region != "eu-west-1" || !strings.HasPrefix(amzDate, date+"T") {
+		return errors.New("wrong credential scope")
+	}
+	if signedHeaders != "host;x-amz-content-sha256;x-amz-date" {
+		return errors.New("wrong signed headers " + signedHeaders)
+	}
+
+	// The canonical query is sorted by key with spaces as %20
+	query := r.URL.Query()
+	var params []string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	sort.Strings(params)
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	canonical := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		strings.ReplaceAll(strings.Join(params, "&"), "+", "%20") + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n\n" +
+		signedHeaders + "\n" +
+		payloadHash
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + date + "/" + region + "/s3/aws4_request\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + stubSecretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	if hex.EncodeToString(hmacSHA256(key, stringToSign)) != signature {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func newS3Stub(t *testing.T) (*s3Stub, *S3Store) {
+	stub := &s3Stub{t: t, pageSize: 2, objects: map[string][]byte{}}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	store, err := NewS3Store(srv.URL, stubBucket, "eu-west-1", stubAccessKey, stubSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stub, store
+}
+
+func TestS3StorePutOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	stub, store := newS3Stub(t)
+
+	// Keys needing escapes exercise the canonical path
+	const key = "attachments/book 1/notes+draft (ü).txt"
+	if err := store.Put(ctx, key, strings.NewReader("spice must flow")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(stub.objects[key]); got != "spice must flow" {
+		t.Fatalf("stored %q", got)
+	}
+	// Bodies of unknown length are spooled first
+	if err := store.Put(ctx, "attachments/empty", io.MultiReader()); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || string(got) != "spice must flow" {
+		t.Errorf("read %q, %v", got, err)
+	}
+	// Seeking starts a ranged GET
+	if _, err := r.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "must flow" {
+		t.Errorf("read %q, %v after seeking", got, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("opening a deleted blob: got %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing blob: %v", err)
+	}
+}
+
+func TestS3StoreDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	stub, store := newS3Stub(t)
+	keys := []string{"covers/a b/1", "covers/a b/2", "covers/a b/3/small", "covers/a b/3/large", "covers/a b/4", "covers/a bc/1", "covers/b/1"}
+	for _, key := range keys {
+		if err := store.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.DeletePrefix(ctx, "covers/a b/"); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for key := range stub.objects {
+		left = append(left, key)
+	}
+	sort.Strings(left)
+	if want := []string{"covers/a bc/1", "covers/b/1"}; !slices.Equal(left, want) {
+		t.Errorf("left %q, want %q", left, want)
+	}
+	// Five keys at two a page
+	if stub.lists != 3 {
+		t.Errorf("listed %d pages, want 3", stub.lists)
+	}
+}
+
+func TestS3StoreErrors(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	store, err := NewS3Store(srv.URL, stubBucket, "", stubAccessKey, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(ctx, "covers/a/1", strings.NewReader("x"))
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Put: got %v, want the 403 with its message", err)
+	}
+	if _, err := store.Open(ctx, "covers/a/1"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Open: got %v, want a non-ErrNotFound error", err)
+	}
+	if err := store.Delete(ctx, "covers/a/1"); err == nil {
+		t.Error("Delete: got no error")
+	}
+	if err := store.DeletePrefix(ctx, "covers/a/"); err == nil {
+		t.Error("DeletePrefix: got no error")
+	}
+	if err := store.Put(ctx, "../escape", strings.NewReader("x")); !errors.Is(err, errInvalidKey) {
+		t.Errorf("Put outside the store: got %v", err)
+	}
+
+	for _, endpoint := range []string{"", "s3.example.com", "ftp://s3.example.com"} {
+		if _, err := NewS3Store(endpoint, stubBucket, "", "", ""); err == nil {
+			t.Errorf("NewS3Store(%q): got no error", endpoint)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Package citation formats books as references for reference managers in
+// the BibTeX, RIS and CSL-JSON formats.
+package citation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Citation styles
+const (
+	BibTeX  = "bibtex"
+	RIS     = "ris"
+	CSLJSON = "csl-json"
+)
+
+var contentTypes = map[string]string{
+	BibTeX:  "application/x-bibtex; charset=utf-8",
+	RIS:     "application/x-research-info-systems; charset=utf-8",
+	CSLJSON: "application/vnd.citationstyles.csl+json",
+}
+
+// ContentType returns the media type of a style, and false for unknown
+// styles.
+func ContentType(style string) (string, bool) {
+	contentType, ok := contentTypes[style]
+	return contentType, ok
+}
+
+// Entry is a book together with the key it is cited by.
+type Entry struct {
+	Key  string
+	Book *domain.Book
+}
+
+// Entries pairs books with citation keys. Books that would share a key get
+// the suffixes a, b, c and so on, in the order of their IDs, so a book
+// keeps its key for as long as the same books are cited together.
+func Entries(books []*domain.Book) []Entry {
+	byKey := make(map[string][]*domain.Book)
+	for _, book := range books {
+		key := Key(book)
+		byKey[key] = append(byKey[key], book)
+	}
+
+	keys := make(map[*domain.Book]string, len(books))
+	for key, group := range byKey {
+		if len(group) == 1 {
+			keys[group[0]] = key
+			continue
+		}
+		sorted := slices.Clone(group)
+		slices.SortFunc(sorted, func(a, b *domain.Book) int { return strings.Compare(a.ID, b.ID) })
+		for i, book := range sorted {
+			keys[book] = key + suffix(i)
+		}
+	}
+
+	entries := make([]Entry, len(books))
+	for i, book := range books {
+		entries[i] = Entry{Key: keys[book], Book: book}
+	}
+	return entries
+}
+
+// suffix returns a, b, ..., z, aa, ab, ... for 0, 1, 2...
+func suffix(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('a'+(i-1)%26)) + s
+	}
+	return s
+}
+
+// Key derives a citation key from the family name of the first author and
+// the year, such as "herbert1965", or "herbertnd" without a year. Books
+// without an author use the first significant word of their title.
+func Key(book *domain.Book) string {
+	base := ""
+	if names := Authors(book.Author); len(names) > 0 {
+		base = asciiWord(names[0].Family)
+	}
+	if base == "" {
+		for _, word := range strings.Fields(book.Title) {
+			word = asciiWord(word)
+			if word != "" && word != "the" && word != "a" && word != "an" {
+				base = word
+				break
+			}
+		}
+	}
+	if base == "" {
+		base = "book"
+	}
+
+	if book.Year == nil {
+		return base + "nd"
+	}
+	return base + strconv.Itoa(*book.Year)
+}
+
+// asciiWord lowercases s, strips its accents and drops everything that is
+// not an ASCII letter or digit.
+func asciiWord(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Name is an author's name. Organizations and single names only have a
+// Family name.
+type Name struct {
+	Family string
+	Given  string
+}
+
+// Authors splits an author field into names. Authors are separated by
+// semicolons, "and" or "&", and written "Given Family" or "Family, Given".
+func Authors(s string) []Name {
+	s = strings.NewReplacer(" & ", ";", " and ", ";").Replace(s)
+
+	var names []Name
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if family, given, ok := strings.Cut(part, ","); ok {
+			names = append(names, Name{Family: strings.TrimSpace(family), Given: strings.TrimSpace(given)})
+			continue
+		}
+		words := strings.Fields(part)
+		names = append(names, Name{Family: words[len(words)-1], Given: strings.Join(words[:len(words)-1], " ")})
+	}
+	return names
+}
+
+// Write writes the entries in the given style.
+func Write(w io.Writer, style string, entries []Entry) error {
+	switch style {
+	case BibTeX:
+		return writeBibTeX(w, entries)
+	case RIS:
+		return writeRIS(w, entries)
+	case CSLJSON:
+		return writeCSLJSON(w, entries)
+	}
+	return fmt.Errorf("unknown citation style %q", style)
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+func writeBibTeX(w io.Writer, entries []Entry) error {
+	var b strings.Builder
+	for i, entry := range entries {
+		book := entry.Book
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("@book{" + entry.Key + ",\n")
+
+		field := func(name, value string) {
+			if value != "" {
+				b.WriteString("  " + name + " = {" + value + "},\n")
+			}
+		}
+		var authors []string
+		for _, name := range Authors(book.Author) {
+			switch {
+			case name.Given == "":
+				// Braces keep BibTeX from splitting organizations into names
+				authors = append(authors, "{"+bibtexEscaper.Replace(name.Family)+"}")
+			default:
+				authors = append(authors, bibtexEscaper.Replace(name.Family+", "+name.Given))
+			}
+		}
+		field("author", strings.Join(authors, " and "))
+		field("title", bibtexEscaper.Replace(book.Title))
+		field("publisher", bibtexEscaper.Replace(book.Publisher))
+		field("year", optionalInt(book.Year))
+		field("edition", bibtexEscaper.Replace(book.Edition))
+		field("isbn", book.ISBN)
+		field("pagetotal", optionalInt(book.Pages))
+		field("keywords", bibtexEscaper.Replace(strings.Join(book.Tags, ", ")))
+		b.WriteString("}\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeRIS(w io.Writer, entries []Entry) error {
+	var b strings.Builder
+	for _, entry := range entries {
+		book := entry.Book
+		tag := func(name, value string) {
+			if value != "" {
+				b.WriteString(name + "  - " + strings.ReplaceAll(value, "\n", " ") + "\r\n")
+			}
+		}
+		tag("TY", "BOOK")
+		tag("ID", entry.Key)
+		for _, name := range Authors(book.Author) {
+			if name.Given == "" {
+				tag("AU", name.Family)
+			} else {
+				tag("AU", name.Family+", "+name.Given)
+			}
+		}
+		tag("TI", book.Title)
+		tag("PB", book.Publisher)
+		tag("PY", optionalInt(book.Year))
+		tag("ET", book.Edition)
+		tag("SN", book.ISBN)
+		// Reference managers read SP as the number of pages of a book
+		tag("SP", optionalInt(book.Pages))
+		for _, keyword := range book.Tags {
+			tag("KW", keyword)
+		}
+		b.WriteString("ER  - \r\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type cslItem struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	Title         string    `json:"title"`
+	Author        []cslName `json:"author,omitempty"`
+	Issued        *cslDate  `json:"issued,omitempty"`
+	Publisher     string    `json:"publisher,omitempty"`
+	Edition       string    `json:"edition,omitempty"`
+	ISBN          string    `json:"ISBN,omitempty"`
+	NumberOfPages *int      `json:"number-of-pages,omitempty"`
+	Keyword       string    `json:"keyword,omitempty"`
+}
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+func writeCSLJSON(w io.Writer, entries []Entry) error {
+	items := make([]cslItem, len(entries))
+	for i, entry := range entries {
+		book := entry.Book
+		item := cslItem{
+			ID:            entry.Key,
+			Type:          "book",
+			Title:         book.Title,
+			Publisher:     book.Publisher,
+			Edition:       book.Edition,
+			ISBN:          book.ISBN,
+			NumberOfPages: book.Pages,
+			Keyword:       strings.Join(book.Tags, ", "),
+		}
+		for _, name := range Authors(book.Author) {
+			if name.Given == "" {
+				item.Author = append(item.Author, cslName{Literal: name.Family})
+			} else {
+				item.Author = append(item.Author, cslName{Family: name.Family, Given: name.Given})
+			}
+		}
+		if book.Year != nil {
+			item.Issued = &cslDate{DateParts: [][]int{{*book.Year}}}
+		}
+		items[i] = item
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
+
+func optionalInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
@@ -0,0 +1,82 @@
+// Package covers checks uploaded cover images and renders their
+// thumbnails.
+package covers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrUnsupported is returned for uploads that are not JPEG, PNG or WebP
+// images, whatever their Content-Type claims.
+var ErrUnsupported = errors.New("cover must be a JPEG, PNG or WebP image")
+
+// MaxPixels bounds the decoded size of a cover, so a small file cannot
+// claim dimensions that would exhaust memory.
+const MaxPixels = 40_000_000
+
+// ContentTypes are the sniffed types accepted for covers.
+var ContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// Decode sniffs and decodes an uploaded image. It returns the image and its
+// content type.
+func Decode(data []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	if !ContentTypes[contentType] {
+		return nil, "", ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot read image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, "", fmt.Errorf("image of %dx%d pixels is too large, at most %d pixels are allowed",
+			config.Width, config.Height, MaxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot read image: %w", err)
+	}
+	return img, contentType, nil
+}
+
+// Thumbnail scales img down to fit in a square of size pixels, keeping its
+// aspect ratio; smaller images keep their size. Transparent areas are
+// painted white, as thumbnails are JPEGs.
+func Thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, h*size/w
+		} else {
+			w, h = w*size/h, size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// EncodeJPEG writes a thumbnail.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
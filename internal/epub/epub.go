@@ -0,0 +1,161 @@
+// Package epub reads the metadata an EPUB file carries about its book.
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// ErrInvalid is returned for files that are not readable EPUBs.
+var ErrInvalid = errors.New("not a valid EPUB")
+
+// maxXMLSize bounds the container and package documents read from an
+// EPUB, as a zip entry may inflate to far more than the upload.
+const maxXMLSize = 4 << 20
+
+var yearPattern = regexp.MustCompile(`^\d{4}`)
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// dcElement is a Dublin Core element of the package metadata.
+type dcElement struct {
+	Value string     `xml:",chardata"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type packageDocument struct {
+	Metadata struct {
+		Titles      []dcElement `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Creators    []dcElement `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Publishers  []dcElement `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+		Dates       []dcElement `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Identifiers []dcElement `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+		Metas       []struct {
+			Property string `xml:"property,attr"`
+			Refines  string `xml:"refines,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+// Parse reads the title, authors, publisher, year and ISBN from the
+// package document of an EPUB. Authors are the creators with the "aut"
+// role, or every creator when none has a role. Fields the EPUB leaves out
+// stay empty; Source is "epub".
+func Parse(r io.ReaderAt, size int64) (*domain.BookMetadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	var c container
+	if err := decodeEntry(zr, "META-INF/container.xml", &c); err != nil {
+		return nil, err
+	}
+	opf := ""
+	for _, rf := range c.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			opf = rf.FullPath
+			break
+		}
+	}
+	if opf == "" {
+		return nil, fmt.Errorf("%w: no package document", ErrInvalid)
+	}
+
+	var pkg packageDocument
+	if err := decodeEntry(zr, path.Clean(opf), &pkg); err != nil {
+		return nil, err
+	}
+	m := pkg.Metadata
+
+	meta := &domain.BookMetadata{Source: "epub", Authors: []string{}}
+	if len(m.Titles) > 0 {
+		meta.Title = clean(m.Titles[0].Value)
+	}
+	if len(m.Publishers) > 0 {
+		meta.Publisher = clean(m.Publishers[0].Value)
+	}
+	for _, d := range m.Dates {
+		if year := yearPattern.FindString(strings.TrimSpace(d.Value)); year != "" {
+			y, _ := strconv.Atoi(year)
+			meta.Year = &y
+			break
+		}
+	}
+	for _, id := range m.Identifiers {
+		value := strings.TrimSpace(id.Value)
+		value = strings.TrimPrefix(strings.TrimPrefix(value, "urn:isbn:"), "isbn:")
+		if isbn, err := domain.ParseISBN(value); err == nil && isbn != "" {
+			meta.ISBN = isbn
+			break
+		}
+	}
+
+	// EPUB 2 puts the role on the creator, EPUB 3 in a meta refining it
+	roles := map[string]string{}
+	for _, mt := range m.Metas {
+		if mt.Property == "role" && strings.HasPrefix(mt.Refines, "#") {
+			roles[mt.Refines[1:]] = strings.TrimSpace(mt.Value)
+		}
+	}
+	var authors, creators []string
+	for _, creator := range m.Creators {
+		name := clean(creator.Value)
+		if name == "" {
+			continue
+		}
+		creators = append(creators, name)
+		role := ""
+		for _, attr := range creator.Attrs {
+			switch attr.Name.Local {
+			case "role":
+				role = attr.Value
+			case "id":
+				if r, ok := roles[attr.Value]; ok && role == "" {
+					role = r
+				}
+			}
+		}
+		if role == "aut" {
+			authors = append(authors, name)
+		}
+	}
+	if len(authors) > 0 {
+		meta.Authors = authors
+	} else if creators != nil {
+		meta.Authors = creators
+	}
+	return meta, nil
+}
+
+func decodeEntry(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	defer f.Close()
+	if err := xml.NewDecoder(io.LimitReader(f, maxXMLSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: cannot read %s: %v", ErrInvalid, name, err)
+	}
+	return nil
+}
+
+// clean collapses the whitespace of an element spread over several lines.
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,329 @@
+// Package exporter writes a user's library out in the formats users take
+// it elsewhere in. Writers stream: each book is written as it is handed
+// over.
+package exporter
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// Writer writes the books of one export, then the shelves. Formats without
+// a place for shelves ignore them. Close finishes the export but leaves
+// the underlying writer open.
+type Writer interface {
+	WriteBook(book *domain.ExportedBook) error
+	WriteShelves(shelves []*domain.Shelf) error
+	Close() error
+}
+
+// jsonWriter writes the versioned dump: a header object whose "books"
+// array holds every book with its notes, reading sessions and attachments,
+// with all fields kept, followed by the "shelves" array.
+type jsonWriter struct {
+	w       io.Writer
+	books   int
+	shelves []*domain.Shelf
+}
+
+func NewJSON(w io.Writer, exportedAt time.Time) (Writer, error) {
+	header, err := json.Marshal(domain.ExportHeader{Format: domain.ExportFormat, Version: domain.ExportVersion, ExportedAt: exportedAt})
+	if err != nil {
+		return nil, err
+	}
+	// Splice the books array into the header object
+	if _, err := io.WriteString(w, string(header[:len(header)-1])+`,"books":[`); err != nil {
+		return nil, err
+	}
+	return &jsonWriter{w: w}, nil
+}
+
+func (j *jsonWriter) WriteBook(book *domain.ExportedBook) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	if j.books > 0 {
+		data = append([]byte{','}, data...)
+	}
+	j.books++
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) WriteShelves(shelves []*domain.Shelf) error {
+	j.shelves = append(j.shelves, shelves...)
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	shelves := j.shelves
+	if shelves == nil {
+		shelves = []*domain.Shelf{}
+	}
+	data, err := json.Marshal(shelves)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.w, `],"shelves":`+string(data)+"}\n")
+	return err
+}
+
+var csvHeader = []string{"ID", "Title", "Author", "ISBN", "Publisher", "Year", "Edition", "Pages", "Cover URL", "Description", "Tags",
+	"Status", "Current Page", "Progress", "Started At", "Finished At", "Rating", "Review", "Created At", "Updated At", "Notes"}
+
+// csvWriter writes one row per book. The notes of a book share a cell,
+// separated by blank lines.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func NewCSV(w io.Writer) (Writer, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	return &csvWriter{w: cw}, nil
+}
+
+func (c *csvWriter) WriteBook(exported *domain.ExportedBook) error {
+	book := &exported.Book
+	bodies := make([]string, len(exported.Notes))
+	for i, note := range exported.Notes {
+		bodies[i] = note.Body
+	}
+	err := c.w.Write([]string{
+		book.ID,
+		book.Title,
+		book.Author,
+		book.ISBN,
+		book.Publisher,
+		optionalInt(book.Year),
+		book.Edition,
+		optionalInt(book.Pages),
+		book.CoverURL,
+		book.Description,
+		strings.Join(book.Tags, ", "),
+		book.Status,
+		optionalInt(book.CurrentPage),
+		optionalFloat(book.Progress),
+		optionalTime(book.StartedAt),
+		optionalTime(book.FinishedAt),
+		optionalFloat(book.Rating),
+		book.Review,
+		book.CreatedAt.Format(time.RFC3339),
+		book.UpdatedAt.Format(time.RFC3339),
+		strings.Join(bodies, "\n\n"),
+	})
+	if err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) WriteShelves([]*domain.Shelf) error {
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// markdownWriter writes a zip of one Markdown file per book, with the
+// book's fields as YAML front matter, as note-taking apps such as
+// Obsidian expect.
+type markdownWriter struct {
+	zip   *zip.Writer
+	names map[string]bool
+}
+
+func NewMarkdownZip(w io.Writer) (Writer, error) {
+	return &markdownWriter{zip: zip.NewWriter(w), names: make(map[string]bool)}, nil
+}
+
+func (m *markdownWriter) WriteBook(exported *domain.ExportedBook) error {
+	book := &exported.Book
+	f, err := m.zip.CreateHeader(&zip.FileHeader{
+		Name:     m.fileName(book),
+		Method:   zip.Deflate,
+		Modified: book.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, markdown(book, exported.Notes))
+	return err
+}
+
+func (m *markdownWriter) WriteShelves([]*domain.Shelf) error {
+	return nil
+}
+
+func (m *markdownWriter) Close() error {
+	return m.zip.Close()
+}
+
+// fileName names a book's file after its title and author, numbering
+// files that would otherwise collide.
+func (m *markdownWriter) fileName(book *domain.Book) string {
+	base := book.Title
+	if book.Author != "" {
+		base += " - " + book.Author
+	}
+	base = sanitizeFileName(base)
+	if base == "" {
+		base = book.ID
+	}
+
+	name := base + ".md"
+	for n := 2; m.names[strings.ToLower(name)]; n++ {
+		name = base + " (" + strconv.Itoa(n) + ").md"
+	}
+	m.names[strings.ToLower(name)] = true
+	return name
+}
+
+// sanitizeFileName drops the characters file systems and vaults reject.
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < ' ', strings.ContainsRune(`/\:*?"<>|#^[]`, r):
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.Trim(strings.Join(strings.Fields(s), " "), ". ")
+	if len([]rune(s)) > 120 {
+		s = strings.TrimSpace(string([]rune(s)[:120]))
+	}
+	return s
+}
+
+// markdown renders a book and its notes as a Markdown document with YAML
+// front matter. Highlights are quoted; the user's own notes are not.
+func markdown(book *domain.Book, notes []*domain.Note) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	frontMatter(&b, "id", book.ID)
+	frontMatter(&b, "title", book.Title)
+	if book.Author != "" {
+		frontMatter(&b, "author", book.Author)
+	}
+	if book.ISBN != "" {
+		frontMatter(&b, "isbn", book.ISBN)
+	}
+	if book.Publisher != "" {
+		frontMatter(&b, "publisher", book.Publisher)
+	}
+	if book.Year != nil {
+		frontMatter(&b, "year", *book.Year)
+	}
+	if book.Edition != "" {
+		frontMatter(&b, "edition", book.Edition)
+	}
+	if book.Pages != nil {
+		frontMatter(&b, "pages", *book.Pages)
+	}
+	if book.CoverURL != "" {
+		frontMatter(&b, "cover_url", book.CoverURL)
+	}
+	tags := book.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	frontMatter(&b, "tags", tags)
+	if book.Status != "" {
+		frontMatter(&b, "status", book.Status)
+	}
+	if book.CurrentPage != nil {
+		frontMatter(&b, "current_page", *book.CurrentPage)
+	}
+	if book.Progress != nil {
+		frontMatter(&b, "progress", *book.Progress)
+	}
+	if book.StartedAt != nil {
+		frontMatter(&b, "started", book.StartedAt.Format(time.RFC3339))
+	}
+	if book.FinishedAt != nil {
+		frontMatter(&b, "finished", book.FinishedAt.Format(time.RFC3339))
+	}
+	if book.Rating != nil {
+		frontMatter(&b, "rating", *book.Rating)
+	}
+	frontMatter(&b, "created", book.CreatedAt.Format(time.RFC3339))
+	frontMatter(&b, "updated", book.UpdatedAt.Format(time.RFC3339))
+	b.WriteString("---\n\n# " + book.Title + "\n")
+
+	if book.Description != "" {
+		b.WriteString("\n" + book.Description + "\n")
+	}
+
+	if book.Review != "" {
+		b.WriteString("\n## Review\n\n" + book.Review + "\n")
+	}
+
+	if len(notes) > 0 {
+		b.WriteString("\n## Notes\n")
+	}
+	for _, note := range notes {
+		b.WriteString("\n")
+		if note.Kind == domain.NoteKindHighlight {
+			b.WriteString("> " + strings.ReplaceAll(note.Body, "\n", "\n> ") + "\n")
+		} else {
+			b.WriteString(note.Body + "\n")
+		}
+		if ref := noteReference(note); ref != "" {
+			b.WriteString("\n— " + ref + "\n")
+		}
+	}
+	return b.String()
+}
+
+// frontMatter writes a YAML key. Values are written as JSON, which YAML
+// reads as is, so no value needs escaping rules of its own.
+func frontMatter(b *strings.Builder, key string, value any) {
+	data, _ := json.Marshal(value)
+	fmt.Fprintf(b, "%s: %s\n", key, data)
+}
+
+func optionalInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func optionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func optionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func noteReference(note *domain.Note) string {
+	var parts []string
+	if note.Page != nil {
+		parts = append(parts, "page "+strconv.Itoa(*note.Page))
+	}
+	if note.Location != "" {
+		parts = append(parts, "location "+note.Location)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,709 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/blob"
+	"github.com/smartnotes/user-service/internal/epub"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// uploadTTL is how long an unfinished upload can be resumed.
+const uploadTTL = 24 * time.Hour
+
+var (
+	errEmptyAttachment       = errors.New("attachment is empty")
+	errUnsupportedAttachment = errors.New("attachment must be a PDF or EPUB file")
+)
+
+type AttachmentHandler struct {
+	books       repositories.BookRepository
+	attachments repositories.AttachmentRepository
+	revisions   repositories.RevisionRepository
+	blobs       blob.BlobStore
+}
+
+func NewAttachmentHandler(books repositories.BookRepository, attachments repositories.AttachmentRepository, revisions repositories.RevisionRepository, blobs blob.BlobStore) *AttachmentHandler {
+	return &AttachmentHandler{books: books, attachments: attachments, revisions: revisions, blobs: blobs}
+}
+
+func (h *AttachmentHandler) ListAttachments(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.books.GetByID(ctx, id, userID.(string)); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	attachments, err := h.attachments.ListAttachments(ctx, id, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"attachments": attachments,
+		"total":       len(attachments),
+	})
+}
+
+// UploadAttachment attaches a PDF or EPUB sent in one request, as the body
+// or as the "file" field of a multipart form. The file name comes from the
+// form or from ?file_name=; ?prefill=true lets an EPUB's title and author
+// replace the book's.
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	book, err := h.books.GetByID(ctx, id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+	usage, err := h.usage(ctx, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	available := usage.Quota - usage.Used
+	if available <= 0 {
+		writeQuotaError(c, usage)
+		return
+	}
+
+	upload, ok := uploadReader(c, min(maxAttachmentSize(), available))
+	if !ok {
+		return
+	}
+	fileName := c.Query("file_name")
+	if part, ok := upload.(*multipart.Part); ok && part.FileName() != "" {
+		fileName = part.FileName()
+	}
+
+	attachment, book, err := h.store(ctx, book, cleanFileName(fileName), upload, c.Query("prefill") == "true", usage.Quota)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) && tooLarge.Limit == available {
+		writeQuotaError(c, usage)
+		return
+	}
+	if errors.Is(err, repositories.ErrQuotaExceeded) {
+		// Other uploads took the space while this one arrived
+		h.writeQuotaExceeded(c, userID.(string))
+		return
+	}
+	if err != nil {
+		writeAttachmentError(c, err)
+		return
+	}
+
+	c.Header("Location", "/api/books/"+id+"/attachments/"+attachment.ID)
+	c.JSON(http.StatusCreated, gin.H{"attachment": attachment, "book": book})
+}
+
+// CreateUpload starts a resumable upload of a file of the declared size,
+// which is reserved against the user's quota until the upload finishes or
+// expires.
+func (h *AttachmentHandler) CreateUpload(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit := maxAttachmentSize(); req.Size > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachments can be at most " + strconv.FormatInt(limit, 10) + " bytes"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.books.GetByID(ctx, id, userID.(string)); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	usage, err := h.usage(ctx, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if usage.Used+req.Size > usage.Quota {
+		writeQuotaError(c, usage)
+		return
+	}
+
+	upload := &domain.Upload{
+		BookID:    id,
+		UserID:    userID.(string),
+		FileName:  cleanFileName(req.FileName),
+		Size:      req.Size,
+		Prefill:   req.Prefill,
+		ExpiresAt: time.Now().UTC().Truncate(time.Second).Add(uploadTTL),
+	}
+	err = h.attachments.CreateUpload(ctx, upload, usage.Quota)
+	if errors.Is(err, repositories.ErrQuotaExceeded) {
+		h.writeQuotaExceeded(c, userID.(string))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/api/books/"+id+"/attachments/uploads/"+upload.ID)
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, upload)
+}
+
+// GetUpload reports how much of an upload has arrived, so an interrupted
+// client knows where to resume.
+func (h *AttachmentHandler) GetUpload(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	upload, err := h.attachments.GetUpload(c.Request.Context(), c.Param("uploadId"), c.Param("id"), userID.(string))
+	if err != nil {
+		writeUploadSessionError(c, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.JSON(http.StatusOK, upload)
+}
+
+// PatchUpload appends the body to an upload. Upload-Offset must name where
+// the chunk starts, which is where the upload is; the chunk that completes
+// the file turns it into an attachment. An empty chunk at the end retries
+// a completion that failed.
+func (h *AttachmentHandler) PatchUpload(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header must be the byte offset of the chunk"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	book, err := h.books.GetByID(ctx, id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+	upload, err := h.attachments.GetUpload(ctx, c.Param("uploadId"), id, userID.(string))
+	if err != nil {
+		writeUploadSessionError(c, err)
+		return
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload has expired"})
+		return
+	}
+	if offset != upload.Offset {
+		writeOffsetConflict(c, upload.Offset)
+		return
+	}
+
+	if upload.Offset < upload.Size {
+		part := domain.UploadPrefix(id, upload.ID) + fmt.Sprintf("%020d-%s", offset, randomToken())
+		body := &countingReader{r: http.MaxBytesReader(c.Writer, c.Request.Body, upload.Size-offset)}
+		if err := h.blobs.Put(ctx, part, body); err != nil {
+			h.deleteBlob(ctx, part)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk runs past the declared size of the upload"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if body.n == 0 {
+			h.deleteBlob(ctx, part)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk is empty"})
+			return
+		}
+
+		upload, err = h.attachments.AdvanceUpload(ctx, upload.ID, userID.(string), offset, offset+body.n, part)
+		if err != nil {
+			h.deleteBlob(ctx, part)
+			if errors.Is(err, repositories.ErrVersionMismatch) {
+				if current, err := h.attachments.GetUpload(ctx, c.Param("uploadId"), id, userID.(string)); err == nil {
+					writeOffsetConflict(c, current.Offset)
+					return
+				}
+			}
+			writeUploadSessionError(c, err)
+			return
+		}
+		if upload.Offset < upload.Size {
+			c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			c.JSON(http.StatusOK, upload)
+			return
+		}
+	}
+
+	// The attachment takes over the space the upload reserved
+	attachment, book, err := h.store(ctx, book, upload.FileName, h.partsReader(ctx, upload.Parts), upload.Prefill, storageQuota()+upload.Size)
+	if err != nil {
+		// A file of the wrong type will not get any better, so the upload
+		// goes; other failures can be retried
+		if errors.Is(err, errEmptyAttachment) || errors.Is(err, errUnsupportedAttachment) || errors.Is(err, epub.ErrInvalid) {
+			h.deleteUpload(ctx, upload)
+		}
+		writeAttachmentError(c, err)
+		return
+	}
+	h.deleteUpload(ctx, upload)
+
+	c.Header("Location", "/api/books/"+id+"/attachments/"+attachment.ID)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.JSON(http.StatusCreated, gin.H{"attachment": attachment, "book": book})
+}
+
+// CancelUpload abandons an upload and frees the space it reserved.
+func (h *AttachmentHandler) CancelUpload(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	upload, err := h.attachments.GetUpload(ctx, c.Param("uploadId"), c.Param("id"), userID.(string))
+	if err != nil {
+		writeUploadSessionError(c, err)
+		return
+	}
+	h.deleteUpload(ctx, upload)
+
+	c.Status(http.StatusNoContent)
+}
+
+// DownloadAttachment serves an attachment, with range requests. It is sent
+// as a download unless ?inline=true asks to show it in the browser.
+func (h *AttachmentHandler) DownloadAttachment(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.books.GetByID(ctx, id, userID.(string)); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	attachment, err := h.attachments.GetAttachment(ctx, c.Param("attachmentId"), id, userID.(string))
+	if err != nil {
+		writeAttachmentNotFound(c, err)
+		return
+	}
+
+	file, err := h.blobs.Open(ctx, attachment.Key)
+	if err != nil {
+		log.Printf("Failed to open attachment %s of book %s: %v", attachment.ID, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+	defer file.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", attachment.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	c.Header("ETag", `"`+attachment.SHA256[:32]+`"`)
+	c.Header("Cache-Control", "private, no-cache")
+	http.ServeContent(c.Writer, c.Request, "", attachment.CreatedAt, file)
+}
+
+func (h *AttachmentHandler) DeleteAttachment(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	attachment, err := h.attachments.GetAttachment(ctx, c.Param("attachmentId"), id, userID.(string))
+	if err != nil {
+		writeAttachmentNotFound(c, err)
+		return
+	}
+	if err := h.attachments.DeleteAttachment(ctx, attachment.ID, id, userID.(string)); err != nil {
+		writeAttachmentNotFound(c, err)
+		return
+	}
+	h.deleteBlob(ctx, attachment.Key)
+
+	c.Status(http.StatusNoContent)
+}
+
+// StorageUsage reports the space the user's attachments take up and their
+// quota.
+func (h *AttachmentHandler) StorageUsage(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	usage, err := h.usage(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// writeQuotaExceeded answers a reservation that failed with
+// ErrQuotaExceeded with the usage as it now is.
+func (h *AttachmentHandler) writeQuotaExceeded(c *gin.Context, userID string) {
+	usage, err := h.usage(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeQuotaError(c, usage)
+}
+
+func (h *AttachmentHandler) usage(ctx context.Context, userID string) (*domain.StorageUsage, error) {
+	used, err := h.attachments.StorageUsed(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.StorageUsage{Used: used, Quota: storageQuota()}, nil
+}
+
+// store spools r to a temporary file while hashing it, checks that it is a
+// PDF or EPUB, and saves it as an attachment of book if it fits in quota.
+// The metadata of an EPUB fills the book's empty fields, or with prefill
+// replaces its title and author too. It returns the attachment and the book
+// as it now is.
+func (h *AttachmentHandler) store(ctx context.Context, book *domain.Book, fileName string, r io.Reader, prefill bool, quota int64) (*domain.Attachment, *domain.Book, error) {
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size == 0 {
+		return nil, nil, errEmptyAttachment
+	}
+
+	head := make([]byte, 64)
+	n, _ := tmp.ReadAt(head, 0)
+	contentType := attachmentType(head[:n])
+	if contentType == "" {
+		return nil, nil, errUnsupportedAttachment
+	}
+	var meta *domain.BookMetadata
+	if contentType == domain.AttachmentEPUB {
+		if meta, err = epub.Parse(tmp, size); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if fileName == "" {
+		fileName = "attachment." + strings.TrimPrefix(strings.TrimSuffix(contentType, "+zip"), "application/")
+	}
+
+	attachment := &domain.Attachment{
+		BookID:      book.ID,
+		UserID:      book.UserID,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Key:         domain.AttachmentKey(book.ID, randomToken()),
+		Metadata:    meta,
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	if err := h.blobs.Put(ctx, attachment.Key, tmp); err != nil {
+		h.deleteBlob(ctx, attachment.Key)
+		return nil, nil, err
+	}
+	if err := h.attachments.CreateAttachment(ctx, attachment, quota); err != nil {
+		h.deleteBlob(ctx, attachment.Key)
+		return nil, nil, err
+	}
+
+	if meta == nil {
+		return attachment, book, nil
+	}
+	update := metadataUpdate(book, meta, prefill)
+	if update == nil {
+		return attachment, book, nil
+	}
+	updated, err := h.books.Update(ctx, book.ID, book.UserID, nil, update)
+	if err != nil {
+		// The attachment is stored either way
+		log.Printf("Failed to fill book %s from EPUB metadata: %v", book.ID, err)
+		return attachment, book, nil
+	}
+	recordRevision(ctx, h.revisions, updated, &domain.Revision{
+		Action:  domain.RevisionUpdated,
+		Changes: domain.DiffBooks(book, updated),
+	})
+	return attachment, updated, nil
+}
+
+// metadataUpdate returns the update that fills book from an EPUB's
+// metadata, or nil when there is nothing to change.
+func metadataUpdate(book *domain.Book, meta *domain.BookMetadata, prefill bool) *domain.UpdateBookRequest {
+	update := &domain.UpdateBookRequest{}
+	changed := false
+	if meta.Title != "" && meta.Title != book.Title && (prefill || book.Title == "") {
+		update.Title = &meta.Title
+		changed = true
+	}
+	if author := meta.Author(); author != "" && author != book.Author && (prefill || book.Author == "") {
+		update.Author = &author
+		changed = true
+	}
+	if meta.Publisher != "" && book.Publisher == "" {
+		update.Publisher = &meta.Publisher
+		changed = true
+	}
+	if meta.Year != nil && book.Year == nil {
+		update.Year = meta.Year
+		changed = true
+	}
+	if meta.ISBN != "" && book.ISBN == "" {
+		update.ISBN = &meta.ISBN
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return update
+}
+
+// attachmentType sniffs the start of a file for the PDF header or the
+// uncompressed mimetype entry every EPUB begins with.
+func attachmentType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return domain.AttachmentPDF
+	case len(head) >= 58 && bytes.HasPrefix(head, []byte("PK\x03\x04")) &&
+		string(head[30:58]) == "mimetypeapplication/epub+zip":
+		return domain.AttachmentEPUB
+	}
+	return ""
+}
+
+// partsReader reads the chunks of an upload one after another, opening
+// each only when the previous one is used up.
+func (h *AttachmentHandler) partsReader(ctx context.Context, parts []string) io.Reader {
+	return &blobSequence{ctx: ctx, blobs: h.blobs, keys: parts}
+}
+
+type blobSequence struct {
+	ctx     context.Context
+	blobs   blob.BlobStore
+	keys    []string
+	current io.ReadCloser
+}
+
+func (s *blobSequence) Read(p []byte) (int, error) {
+	for {
+		if s.current == nil {
+			if len(s.keys) == 0 {
+				return 0, io.EOF
+			}
+			f, err := s.blobs.Open(s.ctx, s.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("cannot read chunk %s: %w", s.keys[0], err)
+			}
+			s.current, s.keys = f, s.keys[1:]
+		}
+		n, err := s.current.Read(p)
+		if err == io.EOF {
+			s.current.Close()
+			s.current = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// deleteUpload drops an upload with its chunks. Failures only leave unused
+// files behind, so they are logged.
+func (h *AttachmentHandler) deleteUpload(ctx context.Context, upload *domain.Upload) {
+	if err := h.attachments.DeleteUpload(ctx, upload.ID, upload.UserID); err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		log.Printf("Failed to delete upload %s: %v", upload.ID, err)
+	}
+	if err := h.blobs.DeletePrefix(ctx, domain.UploadPrefix(upload.BookID, upload.ID)); err != nil {
+		log.Printf("Failed to delete the chunks of upload %s: %v", upload.ID, err)
+	}
+}
+
+func (h *AttachmentHandler) deleteBlob(ctx context.Context, key string) {
+	if err := h.blobs.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete %s: %v", key, err)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func writeAttachmentError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeUploadError(c, err)
+	case errors.Is(err, errEmptyAttachment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Attachment is empty"})
+	case errors.Is(err, errUnsupportedAttachment):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Attachment must be a PDF or EPUB file"})
+	case errors.Is(err, epub.ErrInvalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, repositories.ErrQuotaExceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Storage quota exceeded"})
+	default:
+		log.Printf("Failed to store attachment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+	}
+}
+
+func writeQuotaError(c *gin.Context, usage *domain.StorageUsage) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error": "Storage quota exceeded",
+		"used":  usage.Used,
+		"quota": usage.Quota,
+	})
+}
+
+func writeOffsetConflict(c *gin.Context, offset int64) {
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.JSON(http.StatusConflict, gin.H{
+		"error":  "Upload-Offset does not match the upload, resume from offset",
+		"offset": offset,
+	})
+}
+
+func writeUploadSessionError(c *gin.Context, err error) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func writeAttachmentNotFound(c *gin.Context, err error) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// cleanFileName keeps the last element of a client's file name, without
+// control characters. Files left without a name are named after their type
+// once it is known.
+func cleanFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, `\`, "/")))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// randomToken names blobs that must not collide with earlier ones.
+func randomToken() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// storageQuota is how many bytes of attachments each user may store. Set
+// STORAGE_QUOTA to change it.
+func storageQuota() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("STORAGE_QUOTA"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 1 << 30
+}
+
+// maxAttachmentSize is the largest file, in bytes, that can be attached.
+// Set MAX_ATTACHMENT_SIZE to change it.
+func maxAttachmentSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MAX_ATTACHMENT_SIZE"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 200 << 20
+}
@@ -1,6 +1,12 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -9,7 +15,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/smartnotes/user-service/internal/models"
 	"github.com/smartnotes/user-service/internal/repositories"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AuthHandler struct {
@@ -31,6 +36,10 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -53,14 +62,16 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	token, err := generateToken(user)
+	tokens, err := h.startSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"token": token,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
@@ -88,14 +99,16 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := generateToken(user)
+	tokens, err := h.startSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
@@ -112,7 +125,7 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 		return
 	}
 
-	user, err := h.repo.FindUserByID(userID.(primitive.ObjectID))
+	user, err := h.repo.FindUserByID(userID.(string))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -126,13 +139,164 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 	})
 }
 
-func generateToken(user *models.User) (string, error) {
+// Refresh exchanges a refresh token for a new access/refresh token pair. The
+// presented token is rotated out; presenting it again revokes the session.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	presented := hashToken(req.RefreshToken)
+	session, err := h.repo.RotateSession(c.Request.Context(), presented, hashToken(refreshToken), time.Now().Add(refreshTokenTTL()), sessionMaxAge())
+	if errors.Is(err, repositories.ErrNotFound) {
+		if reused, err := h.repo.FindSessionByPreviousHash(c.Request.Context(), presented); err == nil {
+			log.Printf("Refresh token reuse detected for session %s, revoking", reused.ID)
+			if err := h.repo.RevokeSession(c.Request.Context(), reused.ID, reused.UserID); err != nil {
+				log.Printf("Failed to revoke session %s: %v", reused.ID, err)
+			}
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.FindUserByID(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	accessToken, err := generateToken(user, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int64(accessTokenTTL().Seconds()),
+	})
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, exists := c.Get("sessionID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.repo.RevokeSession(c.Request.Context(), sessionID.(string), userID.(string)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.repo.RevokeUserSessions(c.Request.Context(), userID.(string)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+}
+
+func (h *AuthHandler) startSession(c *gin.Context, user *models.User) (*tokenPair, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(min(refreshTokenTTL(), sessionMaxAge())),
+	}
+	if err := h.repo.CreateSession(c.Request.Context(), session); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := generateToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL().Seconds()),
+	}, nil
+}
+
+func generateToken(user *models.User, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
 		"id":   user.ID,
+		"sid":  sessionID,
 		"role": user.Role,
-		"exp":  time.Now().Add(time.Hour * 24).Unix(),
+		"exp":  time.Now().Add(accessTokenTTL()).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
+
+// newRefreshToken returns an opaque random token. Only its hash is stored.
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func accessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+func refreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+// sessionMaxAge is how long a session lasts after login at most, however
+// often its refresh token is rotated.
+func sessionMaxAge() time.Duration {
+	return durationFromEnv("SESSION_MAX_AGE", 90*24*time.Hour)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
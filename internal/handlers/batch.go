@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// batchOperation is a BatchOperation with its body decoded.
+type batchOperation struct {
+	domain.BatchOperation
+	create *domain.CreateBookRequest
+	update *domain.UpdateBookRequest
+	err    error
+}
+
+// errBatchAborted stops an atomic batch at its first failed operation.
+var errBatchAborted = errors.New("batch aborted")
+
+// Batch applies a list of create, update and delete operations to the
+// caller's books and reports on each. In atomic mode the first failure
+// rolls back the whole batch.
+func (h *BookHandler) Batch(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if max := maxBatchSize(); len(req.Operations) > max {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid batch",
+			"details": domain.ValidationErrors{{Field: "operations", Message: fmt.Sprintf("must hold at most %d operations", max)}},
+		})
+		return
+	}
+
+	ops := make([]batchOperation, len(req.Operations))
+	results := make([]domain.BatchResult, len(req.Operations))
+	invalid := false
+	for i, op := range req.Operations {
+		ops[i] = parseBatchOperation(op)
+		results[i] = domain.BatchResult{Index: i, Op: op.Op, ID: op.ID}
+		if ops[i].err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = ops[i].err.Error()
+			invalid = true
+		}
+	}
+	if req.Atomic && invalid {
+		skipBatchResults(results, "Not applied, the batch has invalid operations")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch, no operation was applied", "results": results})
+		return
+	}
+
+	type pendingRevision struct {
+		book *domain.Book
+		rev  *domain.Revision
+	}
+	var pending []pendingRevision
+	failed := -1
+
+	run := func(ctx context.Context, books repositories.BookRepository) error {
+		// Transactions may be retried, so every run starts over
+		pending = pending[:0]
+		for i, op := range ops {
+			if op.err != nil {
+				continue
+			}
+			results[i] = domain.BatchResult{Index: i, Op: op.Op, ID: op.ID}
+
+			status, book, rev, err := applyBatchOperation(ctx, books, userID.(string), op)
+			if err != nil {
+				results[i].Status, results[i].Error = bookErrorStatus(err)
+				if req.Atomic {
+					failed = i
+					return errBatchAborted
+				}
+				continue
+			}
+			results[i].Status = status
+			results[i].ID = book.ID
+			if status != http.StatusNoContent {
+				results[i].Book = book
+			}
+			pending = append(pending, pendingRevision{book, rev})
+		}
+		return nil
+	}
+
+	var err error
+	if req.Atomic {
+		err = h.repo.WithTransaction(c.Request.Context(), run)
+	} else {
+		err = run(c.Request.Context(), h.repo)
+	}
+	if errors.Is(err, errBatchAborted) {
+		skipBatchResults(results, fmt.Sprintf("Rolled back, operation %d failed", failed))
+		c.JSON(results[failed].Status, gin.H{
+			"error":   fmt.Sprintf("Operation %d failed, no operation was applied", failed),
+			"results": results,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, p := range pending {
+		recordRevision(c.Request.Context(), h.revisions, p.book, p.rev)
+	}
+
+	succeeded := 0
+	for _, res := range results {
+		if res.Error == "" {
+			succeeded++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	})
+}
+
+func parseBatchOperation(op domain.BatchOperation) batchOperation {
+	parsed := batchOperation{BatchOperation: op}
+	switch op.Op {
+	case domain.BatchCreate:
+		if len(op.Book) == 0 {
+			parsed.err = errors.New("book is required")
+			break
+		}
+		var req domain.CreateBookRequest
+		if err := json.Unmarshal(op.Book, &req); err != nil {
+			parsed.err = err
+			break
+		}
+		isbn, err := domain.ParseISBN(req.ISBN)
+		if err != nil {
+			parsed.err = err
+			break
+		}
+		req.ISBN = isbn
+		if err := binding.Validator.ValidateStruct(&req); err != nil {
+			parsed.err = err
+			break
+		}
+		if err := req.DeriveReading(readingTime()); err != nil {
+			parsed.err = err
+			break
+		}
+		req.Tags = domain.NormalizeTags(req.Tags)
+		parsed.create = &req
+	case domain.BatchUpdate:
+		if op.ID == "" {
+			parsed.err = errors.New("id is required")
+			break
+		}
+		if len(op.Book) == 0 {
+			parsed.err = errors.New("book is required")
+			break
+		}
+		parsed.update, parsed.err = domain.DecodeBookMergePatch(op.Book)
+	case domain.BatchDelete:
+		if op.ID == "" {
+			parsed.err = errors.New("id is required")
+		}
+	default:
+		parsed.err = fmt.Errorf("unknown op %q, expected create, update or delete", op.Op)
+	}
+	return parsed
+}
+
+// applyBatchOperation runs one operation and returns its status code, the
+// book it wrote and the revision to record for it.
+func applyBatchOperation(ctx context.Context, books repositories.BookRepository, userID string, op batchOperation) (int, *domain.Book, *domain.Revision, error) {
+	switch op.Op {
+	case domain.BatchCreate:
+		book := &domain.Book{
+			UserID:      userID,
+			Title:       op.create.Title,
+			Author:      op.create.Author,
+			Description: op.create.Description,
+			ISBN:        op.create.ISBN,
+			Publisher:   op.create.Publisher,
+			Year:        op.create.Year,
+			Edition:     op.create.Edition,
+			Pages:       op.create.Pages,
+			CoverURL:    op.create.CoverURL,
+			Tags:        op.create.Tags,
+			Status:      op.create.Status,
+			CurrentPage: op.create.CurrentPage,
+			Progress:    op.create.Progress,
+			StartedAt:   op.create.StartedAt,
+			FinishedAt:  op.create.FinishedAt,
+			Rating:      op.create.Rating,
+			Review:      op.create.Review,
+		}
+		if err := books.Create(ctx, book); err != nil {
+			return 0, nil, nil, err
+		}
+		return http.StatusCreated, book, &domain.Revision{
+			Action:  domain.RevisionCreated,
+			Changes: domain.DiffBooks(nil, book),
+		}, nil
+	case domain.BatchUpdate:
+		before, book, err := updateBook(ctx, books, op.ID, userID, op.Version, op.update)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return http.StatusOK, book, &domain.Revision{
+			Action:  domain.RevisionUpdated,
+			Changes: domain.DiffBooks(before, book),
+		}, nil
+	default:
+		book, err := deleteBook(ctx, books, op.ID, userID, op.Version)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return http.StatusNoContent, book, &domain.Revision{Action: domain.RevisionDeleted}, nil
+	}
+}
+
+// skipBatchResults marks every operation of a rejected atomic batch that
+// did not fail itself as not applied.
+func skipBatchResults(results []domain.BatchResult, reason string) {
+	for i := range results {
+		if results[i].Error == "" {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Book = nil
+			if results[i].Op == domain.BatchCreate {
+				results[i].ID = ""
+			}
+			results[i].Error = reason
+		}
+	}
+}
+
+// maxBatchSize is the most operations a batch may hold. Set MAX_BATCH_SIZE
+// to change it.
+func maxBatchSize() int {
+	if n, err := strconv.Atoi(os.Getenv("MAX_BATCH_SIZE")); err == nil && n > 0 {
+		return n
+	}
+	return 500
+}
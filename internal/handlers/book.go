@@ -1,29 +1,48 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"os"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/smartnotes/user-service/internal/metadata"
 	"github.com/smartnotes/user-service/internal/repositories"
 	"github.com/smartnotes/user-service/pkg/domain"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type BookHandler struct {
-	repo repositories.BookRepository
+	repo      repositories.BookRepository
+	revisions repositories.RevisionRepository
+	readings  repositories.ReadingRepository
+	metadata  metadata.MetadataProvider
 }
 
-func NewBookHandler(repo repositories.BookRepository) *BookHandler {
-	return &BookHandler{repo: repo}
+func NewBookHandler(repo repositories.BookRepository, revisions repositories.RevisionRepository, readings repositories.ReadingRepository, provider metadata.MetadataProvider) *BookHandler {
+	return &BookHandler{repo: repo, revisions: revisions, readings: readings, metadata: provider}
 }
 
+// CreateBook adds a book. When the body has an ISBN, the fields it leaves
+// empty are filled in from the metadata providers, so an ISBN alone is
+// enough for a book they know.
 func (h *BookHandler) CreateBook(c *gin.Context) {
 	var req domain.CreateBookRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	isbn, err := domain.ParseISBN(req.ISBN)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ISBN = isbn
 
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -31,28 +50,72 @@ func (h *BookHandler) CreateBook(c *gin.Context) {
 		return
 	}
 
+	if req.NeedsMetadata() {
+		meta, err := h.metadata.Lookup(c.Request.Context(), req.ISBN)
+		switch {
+		case err == nil:
+			meta.Fill(&req)
+		case req.Title != "":
+			// Enrichment is best effort once the client has named the book
+			if !errors.Is(err, metadata.ErrNotFound) {
+				log.Printf("Failed to look up ISBN %s: %v", req.ISBN, err)
+			}
+		case errors.Is(err, metadata.ErrNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No metadata found for this ISBN, a title is required"})
+			return
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": "ISBN lookup failed: " + err.Error()})
+			return
+		}
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.DeriveReading(readingTime()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Tags = domain.NormalizeTags(req.Tags)
+
 	book := &domain.Book{
-		UserID:      userID.(primitive.ObjectID),
+		UserID:      userID.(string),
 		Title:       req.Title,
 		Author:      req.Author,
 		Description: req.Description,
+		ISBN:        req.ISBN,
+		Publisher:   req.Publisher,
+		Year:        req.Year,
+		Edition:     req.Edition,
+		Pages:       req.Pages,
+		CoverURL:    req.CoverURL,
 		Tags:        req.Tags,
+		Status:      req.Status,
+		CurrentPage: req.CurrentPage,
+		Progress:    req.Progress,
+		StartedAt:   req.StartedAt,
+		FinishedAt:  req.FinishedAt,
+		Rating:      req.Rating,
+		Review:      req.Review,
 	}
 
 	if err := h.repo.Create(c.Request.Context(), book); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordRevision(c.Request.Context(), h.revisions, book, &domain.Revision{
+		Action:  domain.RevisionCreated,
+		Changes: domain.DiffBooks(nil, book),
+	})
 
+	c.Header("ETag", book.ETag())
 	c.JSON(http.StatusCreated, book)
 }
 
+// UpdateBook replaces every editable field of a book, so its body has the
+// same shape as for CreateBook.
 func (h *BookHandler) UpdateBook(c *gin.Context) {
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
-		return
-	}
+	id := c.Param("id")
 
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -60,40 +123,72 @@ func (h *BookHandler) UpdateBook(c *gin.Context) {
 		return
 	}
 
-	var req domain.UpdateBookRequest
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	var req domain.CreateBookRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	isbn, err := domain.ParseISBN(req.ISBN)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ISBN = isbn
 
-	book := &domain.Book{}
-	if req.Title != nil {
-		book.Title = *req.Title
+	book := applyBookUpdate(c, h.repo, h.revisions, id, userID.(string), version, req.Replace(),
+		&domain.Revision{Action: domain.RevisionUpdated})
+	if book == nil {
+		return
 	}
-	if req.Author != nil {
-		book.Author = *req.Author
+
+	c.Header("ETag", book.ETag())
+	c.JSON(http.StatusOK, book)
+}
+
+// PatchBook changes only the fields present in the body, which is a JSON
+// Merge Patch whether sent as application/merge-patch+json or plain JSON.
+func (h *BookHandler) PatchBook(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
 	}
-	if req.Description != nil {
-		book.Description = *req.Description
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
 	}
-	if req.Tags != nil {
-		book.Tags = *req.Tags
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req, err := domain.DecodeBookMergePatch(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := h.repo.Update(c.Request.Context(), id, userID.(primitive.ObjectID), book); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	book := applyBookUpdate(c, h.repo, h.revisions, id, userID.(string), version, req,
+		&domain.Revision{Action: domain.RevisionUpdated})
+	if book == nil {
 		return
 	}
 
+	c.Header("ETag", book.ETag())
 	c.JSON(http.StatusOK, book)
 }
 
 func (h *BookHandler) DeleteBook(c *gin.Context) {
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
-		return
-	}
+	id := c.Param("id")
 
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -101,20 +196,23 @@ func (h *BookHandler) DeleteBook(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.Delete(c.Request.Context(), id, userID.(primitive.ObjectID)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	book, err := deleteBook(c.Request.Context(), h.repo, id, userID.(string), version)
+	if err != nil {
+		writeBookError(c, err)
 		return
 	}
+	recordRevision(c.Request.Context(), h.revisions, book, &domain.Revision{Action: domain.RevisionDeleted})
 
 	c.Status(http.StatusNoContent)
 }
 
 func (h *BookHandler) GetBook(c *gin.Context) {
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
-		return
-	}
+	id := c.Param("id")
 
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -122,12 +220,16 @@ func (h *BookHandler) GetBook(c *gin.Context) {
 		return
 	}
 
-	book, err := h.repo.GetByID(c.Request.Context(), id, userID.(primitive.ObjectID))
+	book, err := h.repo.GetByID(c.Request.Context(), id, userID.(string))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
 		return
 	}
 
+	if notModified(c, book.ETag()) {
+		return
+	}
+	addTimeSpent(c.Request.Context(), h.readings, userID.(string), book)
 	c.JSON(http.StatusOK, book)
 }
 
@@ -138,26 +240,176 @@ func (h *BookHandler) ListBooks(c *gin.Context) {
 		return
 	}
 
-	search := c.Query("search")
-	sortBy := c.DefaultQuery("sort_by", "created_at")
-	order := c.DefaultQuery("order", "desc")
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	var query domain.ListBooksQuery
+	if !bindListBooksQuery(c, &query) {
+		return
+	}
+	listBooks(c, h.repo, h.readings, userID.(string), &query)
+}
+
+// bindListBooksQuery binds and validates the query string of a book
+// listing, answering 400 when it is invalid. Fields the caller set before,
+// such as the shelf, are kept.
+func bindListBooksQuery(c *gin.Context, query *domain.ListBooksQuery) bool {
+	if err := c.ShouldBindQuery(query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": domain.ValidationErrors{{Field: "query", Message: err.Error()}},
+		})
+		return false
+	}
+	if err := query.Validate(maxPageSize()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "details": err})
+		return false
+	}
+	return true
+}
 
-	books, err := h.repo.List(c.Request.Context(), userID.(primitive.ObjectID), search, sortBy, order, limit, offset)
+// listBooks writes a page of the books matching query, or with q its
+// full-text search results.
+func listBooks(c *gin.Context, repo repositories.BookRepository, readings repositories.ReadingRepository, userID string, query *domain.ListBooksQuery) {
+	ctx := c.Request.Context()
+
+	// q switches to full-text search: results are ranked by relevance and
+	// carry a score and highlighted snippets
+	if query.Query != "" {
+		results, total, err := repo.Search(ctx, userID, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		books := make([]*domain.Book, len(results))
+		for i, result := range results {
+			books[i] = &result.Book
+		}
+		addTimeSpent(ctx, readings, userID, books...)
+		addPositions(query.Shelf, books...)
+
+		writeCacheableJSON(c, gin.H{
+			"books": results,
+			"total": total,
+		})
+		return
+	}
+
+	// Fetch one extra book to learn whether there is a next page
+	page := *query
+	page.Limit++
+	books, err := repo.List(ctx, userID, &page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	addPositions(query.Shelf, books...)
+
+	var nextCursor *string
+	if len(books) > query.Limit {
+		books = books[:query.Limit]
+		cursor := domain.NewBookCursor(books[len(books)-1], query.SortKeys).Encode()
+		nextCursor = &cursor
+	}
 
-	total, err := h.repo.Count(c.Request.Context(), userID.(primitive.ObjectID), search)
+	total, err := repo.Count(ctx, userID, query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"books": books,
-		"total": total,
+	facets, err := repo.Facets(ctx, userID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	addTimeSpent(ctx, readings, userID, books...)
+	items := make([]bookItem, len(books))
+	for i, book := range books {
+		items[i] = bookItem{Book: book, ETag: book.ETag()}
+	}
+
+	writeCacheableJSON(c, gin.H{
+		"books":       items,
+		"total":       total,
+		"facets":      facets,
+		"next_cursor": nextCursor,
 	})
 }
+
+// bookItem is a listed book together with the ETag a client needs to
+// update it.
+type bookItem struct {
+	*domain.Book
+	ETag string `json:"etag"`
+}
+
+// updateBook applies update to a book and returns it as it was before and
+// after. Without a version the write is pinned to the version it read.
+// Tags are written in their normalized form.
+func updateBook(ctx context.Context, books repositories.BookRepository, id, userID string, version *int64, update *domain.UpdateBookRequest) (before, after *domain.Book, err error) {
+	before, err = books.GetByID(ctx, id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == nil {
+		version = &before.Version
+	}
+	if err := update.DeriveReading(before, readingTime()); err != nil {
+		return nil, nil, err
+	}
+	if update.Tags != nil {
+		tags := domain.NormalizeTags(*update.Tags)
+		update.Tags = &tags
+	}
+
+	after, err = books.Update(ctx, id, userID, version, update)
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// deleteBook moves a book to the trash and returns it as it is there.
+func deleteBook(ctx context.Context, books repositories.BookRepository, id, userID string, version *int64) (*domain.Book, error) {
+	book, err := books.GetByID(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		version = &book.Version
+	}
+
+	if err := books.Delete(ctx, id, userID, version); err != nil {
+		return nil, err
+	}
+	book.Version++
+	return book, nil
+}
+
+func writeBookError(c *gin.Context, err error) {
+	status, message := bookErrorStatus(err)
+	c.JSON(status, gin.H{"error": message})
+}
+
+// bookErrorStatus maps a repository error to a status code and message.
+func bookErrorStatus(err error) (int, string) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		return http.StatusNotFound, "Book not found"
+	}
+	if errors.Is(err, repositories.ErrVersionMismatch) {
+		return http.StatusPreconditionFailed, "Book has been modified, fetch it again and retry"
+	}
+	var invalid domain.ValidationErrors
+	if errors.As(err, &invalid) {
+		return http.StatusBadRequest, invalid.Error()
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+// maxPageSize is the largest limit ListBooks honours; larger ones are
+// clamped. Set MAX_PAGE_SIZE to change it.
+func maxPageSize() int {
+	if n, err := strconv.Atoi(os.Getenv("MAX_PAGE_SIZE")); err == nil && n > 0 {
+		return n
+	}
+	return 100
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/citation"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// CiteBook answers a citation of one book in the style given by ?style=:
+// bibtex (the default), ris or csl-json.
+func (h *BookHandler) CiteBook(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	style, contentType, ok := citationStyle(c)
+	if !ok {
+		return
+	}
+
+	book, err := h.repo.GetByID(c.Request.Context(), c.Param("id"), userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+
+	writeCitations(c, style, contentType, []*domain.Book{book})
+}
+
+// CiteBooks answers citations of every book matching the filters of
+// GET /api/books, in the order its sort gives. Paging parameters are
+// ignored.
+func (h *BookHandler) CiteBooks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	style, contentType, ok := citationStyle(c)
+	if !ok {
+		return
+	}
+
+	var query domain.ListBooksQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": domain.ValidationErrors{{Field: "query", Message: err.Error()}},
+		})
+		return
+	}
+	if err := query.Validate(maxPageSize()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "details": err})
+		return
+	}
+	if query.Query != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": domain.ValidationErrors{{Field: "q", Message: "full-text search cannot be cited, use filters instead"}},
+		})
+		return
+	}
+	query.Limit, query.Offset, query.Cursor = 0, 0, nil
+
+	books, err := h.repo.List(c.Request.Context(), userID.(string), &query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	writeCitations(c, style, contentType, books)
+}
+
+// citationStyle reads ?style=, writing the error response itself when it
+// names no known style.
+func citationStyle(c *gin.Context) (style, contentType string, ok bool) {
+	style = c.DefaultQuery("style", citation.BibTeX)
+	contentType, ok = citation.ContentType(style)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": domain.ValidationErrors{{Field: "style", Message: "must be bibtex, ris or csl-json"}},
+		})
+	}
+	return style, contentType, ok
+}
+
+func writeCitations(c *gin.Context, style, contentType string, books []*domain.Book) {
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if err := citation.Write(c.Writer, style, citation.Entries(books)); err != nil {
+		log.Printf("Failed to write citations: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/blob"
+	"github.com/smartnotes/user-service/internal/covers"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type CoverHandler struct {
+	books repositories.BookRepository
+	blobs blob.BlobStore
+}
+
+func NewCoverHandler(books repositories.BookRepository, blobs blob.BlobStore) *CoverHandler {
+	return &CoverHandler{books: books, blobs: blobs}
+}
+
+// UploadCover sets a book's cover from a JPEG, PNG or WebP image, sent as
+// the body or as the "file" field of a multipart form, and renders its
+// thumbnails. If-Match is optional.
+func (h *CoverHandler) UploadCover(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var version *int64
+	ok := true
+	if c.GetHeader("If-Match") != "" {
+		if version, ok = ifMatchVersion(c); !ok {
+			return
+		}
+	}
+
+	upload, ok := uploadReader(c, maxCoverSize())
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(upload)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	img, contentType, err := covers.Decode(data)
+	if errors.Is(err, covers.ErrUnsupported) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	book, err := h.books.GetByID(ctx, id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	cover := &domain.Cover{
+		ContentType: contentType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Size:        int64(len(data)),
+		Hash:        hex.EncodeToString(sum[:]),
+		UpdatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	if err := h.storeCover(ctx, id, cover, data, img); err != nil {
+		h.deleteCover(ctx, id, cover, book.Cover)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.books.SetCover(ctx, id, userID.(string), version, cover)
+	if err != nil {
+		h.deleteCover(ctx, id, cover, book.Cover)
+		writeBookError(c, err)
+		return
+	}
+	h.deleteCover(ctx, id, book.Cover, updated.Cover)
+
+	c.Header("ETag", updated.ETag())
+	c.JSON(http.StatusOK, updated)
+}
+
+// GetCover serves the original cover or one of its thumbnails. Requests
+// for a URL carrying the cover's hash as ?v= may be cached for good, as a
+// new cover gets a new hash; others are revalidated with the ETag.
+func (h *CoverHandler) GetCover(c *gin.Context) {
+	id := c.Param("id")
+	size := c.Param("size")
+	if size == "" {
+		size = domain.CoverOriginal
+	}
+	if _, ok := domain.CoverSizes[size]; !ok && size != domain.CoverOriginal {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown cover size, expected original, small, medium or large"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	book, err := h.books.GetByID(c.Request.Context(), id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+	if book.Cover == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book has no cover"})
+		return
+	}
+
+	file, err := h.blobs.Open(c.Request.Context(), book.Cover.Key(id, size))
+	if err != nil {
+		log.Printf("Failed to open %s cover of book %s: %v", size, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read cover"})
+		return
+	}
+	defer file.Close()
+
+	contentType := "image/jpeg"
+	if size == domain.CoverOriginal {
+		contentType = book.Cover.ContentType
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", `"`+book.Cover.Hash[:32]+"-"+size+`"`)
+	if c.Query("v") == book.Cover.Hash {
+		c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+	http.ServeContent(c.Writer, c.Request, "", book.Cover.UpdatedAt, file)
+}
+
+// DeleteCover removes a book's cover. If-Match is optional.
+func (h *CoverHandler) DeleteCover(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var version *int64
+	ok := true
+	if c.GetHeader("If-Match") != "" {
+		if version, ok = ifMatchVersion(c); !ok {
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	book, err := h.books.GetByID(ctx, id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+	if book.Cover == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book has no cover"})
+		return
+	}
+
+	if _, err := h.books.SetCover(ctx, id, userID.(string), version, nil); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	h.deleteCover(ctx, id, book.Cover, nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// storeCover writes the original and every thumbnail of a cover.
+func (h *CoverHandler) storeCover(ctx context.Context, bookID string, cover *domain.Cover, data []byte, img image.Image) error {
+	if err := h.blobs.Put(ctx, cover.Key(bookID, domain.CoverOriginal), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for size, pixels := range domain.CoverSizes {
+		var buf bytes.Buffer
+		if err := covers.EncodeJPEG(&buf, covers.Thumbnail(img, pixels)); err != nil {
+			return err
+		}
+		if err := h.blobs.Put(ctx, cover.Key(bookID, size), &buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCover removes the files of cover unless the book still uses them
+// as keep. Failures only leave unused files behind, so they are logged.
+func (h *CoverHandler) deleteCover(ctx context.Context, bookID string, cover, keep *domain.Cover) {
+	if cover == nil || (keep != nil && keep.Hash == cover.Hash) {
+		return
+	}
+	sizes := []string{domain.CoverOriginal}
+	for size := range domain.CoverSizes {
+		sizes = append(sizes, size)
+	}
+	for _, size := range sizes {
+		if err := h.blobs.Delete(ctx, cover.Key(bookID, size)); err != nil {
+			log.Printf("Failed to delete %s cover of book %s: %v", size, bookID, err)
+		}
+	}
+}
+
+// maxCoverSize is the largest cover image, in bytes, UploadCover accepts.
+// Set MAX_COVER_SIZE to change it.
+func maxCoverSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MAX_COVER_SIZE"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 10 << 20
+}
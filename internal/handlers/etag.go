@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ifMatchVersion reads the version a write is conditional on from If-Match.
+// It returns nil for "*", which matches any version. A missing header is
+// answered with 428 and one that cannot match a version with 412; ok is
+// false in both cases.
+func ifMatchVersion(c *gin.Context) (version *int64, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+		return nil, false
+	}
+	if header == "*" {
+		return nil, true
+	}
+
+	// Only a single strong entity tag can match a version
+	tag, opened := strings.CutPrefix(header, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	v, err := strconv.ParseInt(tag, 10, 64)
+	if !opened || !closed || err != nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match the current version"})
+		return nil, false
+	}
+	return &v, true
+}
+
+// notModified sets the ETag header and, when If-None-Match lists etag,
+// answers 304 and reports true.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// writeCacheableJSON answers 200 with body and a weak ETag derived from it,
+// or 304 when the client already has that representation.
+func writeCacheableJSON(c *gin.Context, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	if notModified(c, `W/"`+hex.EncodeToString(sum[:16])+`"`) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/exporter"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// exportPageSize is how many books an export reads at a time.
+const exportPageSize = 100
+
+type ExportHandler struct {
+	books       repositories.BookRepository
+	notes       repositories.NoteRepository
+	readings    repositories.ReadingRepository
+	attachments repositories.AttachmentRepository
+	shelves     repositories.ShelfRepository
+}
+
+func NewExportHandler(books repositories.BookRepository, notes repositories.NoteRepository, readings repositories.ReadingRepository, attachments repositories.AttachmentRepository, shelves repositories.ShelfRepository) *ExportHandler {
+	return &ExportHandler{books: books, notes: notes, readings: readings, attachments: attachments, shelves: shelves}
+}
+
+// exportFormats maps each format to its content type, file extension and
+// writer.
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+	open        func(w io.Writer, exportedAt time.Time) (exporter.Writer, error)
+}{
+	"json": {"application/json", "json", exporter.NewJSON},
+	"csv": {"text/csv; charset=utf-8", "csv", func(w io.Writer, _ time.Time) (exporter.Writer, error) {
+		return exporter.NewCSV(w)
+	}},
+	"markdown": {"application/zip", "zip", func(w io.Writer, _ time.Time) (exporter.Writer, error) {
+		return exporter.NewMarkdownZip(w)
+	}},
+}
+
+// Export streams all of the caller's books with their notes as a download
+// in the format given by ?format=: json (the default), csv or markdown.
+// The json dump also holds reading sessions, attachments and shelves, so
+// that ImportJSON can restore it.
+func (h *ExportHandler) Export(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	name := c.DefaultQuery("format", "json")
+	format, ok := exportFormats[name]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": domain.ValidationErrors{{Field: "format", Message: "must be json, csv or markdown"}},
+		})
+		return
+	}
+
+	// Read the first page before anything is written, so that storage
+	// errors can still be answered properly
+	ctx := c.Request.Context()
+	query := &domain.ListBooksQuery{SortKeys: []domain.SortKey{{Field: "created_at"}}, Limit: exportPageSize}
+	books, err := h.books.List(ctx, userID.(string), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	exportedAt := time.Now().UTC()
+	c.Header("Content-Type", format.contentType)
+	c.Header("Content-Disposition", `attachment; filename="smartnotes-`+exportedAt.Format("2006-01-02")+`.`+format.extension+`"`)
+	c.Status(http.StatusOK)
+
+	w, err := format.open(c.Writer, exportedAt)
+	if err == nil {
+		err = h.writeBooks(ctx, w, userID.(string), query, books)
+	}
+	if err == nil {
+		var shelves []*domain.Shelf
+		if shelves, err = h.shelves.ListShelves(ctx, userID.(string)); err == nil {
+			err = w.WriteShelves(shelves)
+		}
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		// The response has started; all that is left is to cut it short
+		log.Printf("Export for user %s failed: %v", userID, err)
+		c.Abort()
+	}
+}
+
+// writeBooks writes the given first page of books and all that follow it.
+func (h *ExportHandler) writeBooks(ctx context.Context, w exporter.Writer, userID string, query *domain.ListBooksQuery, books []*domain.Book) error {
+	for len(books) > 0 {
+		for _, book := range books {
+			exported, err := h.exportBook(ctx, book, userID)
+			if err != nil {
+				return err
+			}
+			if err := w.WriteBook(exported); err != nil {
+				return err
+			}
+		}
+		if len(books) < exportPageSize {
+			return nil
+		}
+
+		query.Cursor = domain.NewBookCursor(books[len(books)-1], query.SortKeys)
+		var err error
+		if books, err = h.books.List(ctx, userID, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportBook gathers what an export holds of a book.
+func (h *ExportHandler) exportBook(ctx context.Context, book *domain.Book, userID string) (*domain.ExportedBook, error) {
+	notes, err := h.notes.ListNotes(ctx, book.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := h.readings.ListReadingSessions(ctx, book.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := h.attachments.ListAttachments(ctx, book.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ExportedBook{Book: *book, Notes: notes, Sessions: sessions, Attachments: attachments}, nil
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/jobs"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+func TestJSONExportRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	repo := repositories.NewMemoryRepository()
+	imports := jobs.NewImports()
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", c.GetHeader("X-User")) })
+	router.GET("/export", NewExportHandler(repo, repo, repo, repo, repo).Export)
+	importHandler := NewImportHandler(repo, repo, repo, repo, repo, imports)
+	router.POST("/import", importHandler.ImportJSON)
+
+	pages, rating, added := 320, 4.5, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	dune := &domain.Book{UserID: "alice", Title: "Dune", Author: "Frank Herbert", Pages: &pages,
+		Tags: []string{"sci-fi", "classics"}, Status: domain.StatusReading, Rating: &rating, Review: "Spice.", CreatedAt: added}
+	solaris := &domain.Book{UserID: "alice", Title: "Solaris", Author: "Stanisław Lem"}
+	for _, book := range []*domain.Book{dune, solaris} {
+		if err := repo.Create(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := 12
+	if err := repo.CreateNote(ctx, &domain.Note{UserID: "alice", BookID: dune.ID, Kind: domain.NoteKindHighlight, Body: "Fear is the mind-killer.", Page: &page}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 2, 20, 0, 0, 0, time.UTC)
+	if err := repo.CreateReadingSession(ctx, &domain.ReadingSession{UserID: "alice", BookID: dune.ID, Start: start, End: start.Add(time.Hour), Pages: &page}); err != nil {
+		t.Fatal(err)
+	}
+	favourites := &domain.Shelf{UserID: "alice", Name: "Favourites"}
+	if err := repo.CreateShelf(ctx, favourites); err != nil {
+		t.Fatal(err)
+	}
+	translated := &domain.Shelf{UserID: "alice", ParentID: favourites.ID, Name: "Translated"}
+	if err := repo.CreateShelf(ctx, translated); err != nil {
+		t.Fatal(err)
+	}
+	for _, placed := range []struct{ shelf, book string }{{favourites.ID, solaris.ID}, {favourites.ID, dune.ID}, {translated.ID, solaris.ID}} {
+		if _, err := repo.PlaceShelfBook(ctx, placed.shelf, "alice", placed.book, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dump := exportJSON(t, router, "alice")
+	for i := 0; i < 2; i++ {
+		job := importJSON(t, router, imports, "bob", dump)
+		if job.Status != domain.ImportDone {
+			t.Fatalf("import %d: status %s, error %q", i, job.Status, job.Error)
+		}
+		// A second import finds every book in the library already
+		if want := 2 * (1 - i); job.Imported != want || len(job.Skipped) != 2-want {
+			t.Fatalf("import %d: imported %d and skipped %d, want %d and %d", i, job.Imported, len(job.Skipped), want, 2-want)
+		}
+	}
+
+	var original, restored domain.Export
+	if err := json.Unmarshal(dump, &original); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(exportJSON(t, router, "bob"), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if original.Version != domain.ExportVersion || len(restored.Books) != len(original.Books) {
+		t.Fatalf("restored %d books of version %d, want %d of version %d", len(restored.Books), original.Version, len(original.Books), domain.ExportVersion)
+	}
+
+	titles := map[string]string{}
+	for i, want := range original.Books {
+		got := restored.Books[i]
+		titles[want.ID], titles[got.ID] = want.Title, got.Title
+		if got.Title != want.Title || got.Author != want.Author || got.Status != want.Status || got.Review != want.Review ||
+			!got.CreatedAt.Equal(want.CreatedAt) || !equalJSON(got.Tags, want.Tags) || !equalJSON(got.Rating, want.Rating) || !equalJSON(got.Pages, want.Pages) {
+			t.Errorf("book %d: got %+v, want %+v", i, got.Book, want.Book)
+		}
+		if len(got.Notes) != len(want.Notes) || len(got.Sessions) != len(want.Sessions) {
+			t.Fatalf("book %q: got %d notes and %d sessions, want %d and %d", want.Title, len(got.Notes), len(got.Sessions), len(want.Notes), len(want.Sessions))
+		}
+		for j := range want.Notes {
+			if got.Notes[j].Body != want.Notes[j].Body || got.Notes[j].Kind != want.Notes[j].Kind || !equalJSON(got.Notes[j].Page, want.Notes[j].Page) {
+				t.Errorf("book %q note %d: got %+v, want %+v", want.Title, j, got.Notes[j], want.Notes[j])
+			}
+		}
+		for j := range want.Sessions {
+			if !got.Sessions[j].Start.Equal(want.Sessions[j].Start) || !got.Sessions[j].End.Equal(want.Sessions[j].End) || !equalJSON(got.Sessions[j].Pages, want.Sessions[j].Pages) {
+				t.Errorf("book %q session %d: got %+v, want %+v", want.Title, j, got.Sessions[j], want.Sessions[j])
+			}
+		}
+	}
+
+	if len(restored.Shelves) != len(original.Shelves) {
+		t.Fatalf("restored %d shelves, want %d", len(restored.Shelves), len(original.Shelves))
+	}
+	names := map[string]string{}
+	for i, want := range original.Shelves {
+		got := restored.Shelves[i]
+		names[want.ID], names[got.ID] = want.Name, got.Name
+		if got.Name != want.Name || names[got.ParentID] != names[want.ParentID] || len(got.BookIDs) != len(want.BookIDs) {
+			t.Fatalf("shelf %d: got %+v, want %+v", i, got, want)
+		}
+		for j := range want.BookIDs {
+			if titles[got.BookIDs[j]] != titles[want.BookIDs[j]] {
+				t.Errorf("shelf %q position %d: got %q, want %q", want.Name, j, titles[got.BookIDs[j]], titles[want.BookIDs[j]])
+			}
+		}
+	}
+}
+
+func TestImportJSONLeavesOutInvalidFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repositories.NewMemoryRepository()
+	imports := jobs.NewImports()
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", c.GetHeader("X-User")) })
+	router.POST("/import", NewImportHandler(repo, repo, repo, repo, repo, imports).ImportJSON)
+
+	dump := `{"format":"smartnotes","version":2,"books":[
+		{"title":"Dune","pages":300,"status":"skimmed","current_page":400,"progress":250},
+		{"title":"Solaris","pages":200,"current_page":50},
+		{"title":"Ubik","status":"finished","started_at":"2024-03-10T00:00:00Z","finished_at":"2024-03-01T00:00:00Z"},
+		{"title":"Hyperion","status":"finished","started_at":"2999-01-01T00:00:00Z"}
+	]}`
+	job := importJSON(t, router, imports, "alice", []byte(dump))
+	if job.Status != domain.ImportDone || job.Imported != 3 || len(job.Skipped) != 1 || job.Skipped[0].Title != "Hyperion" {
+		t.Fatalf("got %+v", job)
+	}
+	warned := map[string]int{}
+	for _, w := range job.Warnings {
+		warned[w.Title]++
+	}
+	if warned["Dune"] != 3 || warned["Solaris"] != 0 || warned["Ubik"] != 1 {
+		t.Errorf("warnings %+v", job.Warnings)
+	}
+
+	books, err := repo.List(context.Background(), "alice", &domain.ListBooksQuery{SortKeys: domain.DefaultSort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTitle := map[string]*domain.Book{}
+	for _, book := range books {
+		byTitle[book.Title] = book
+	}
+	if dune := byTitle["Dune"]; dune.Status != "" || dune.CurrentPage != nil || dune.Progress != nil {
+		t.Errorf("Dune: status %q, page %v, progress %v", dune.Status, dune.CurrentPage, dune.Progress)
+	}
+	// A page is derived into progress and a reading status, as on creation
+	if solaris := byTitle["Solaris"]; solaris.Status != domain.StatusReading || solaris.Progress == nil || *solaris.Progress != 25 || solaris.StartedAt == nil {
+		t.Errorf("Solaris: status %q, progress %v, started %v", solaris.Status, solaris.Progress, solaris.StartedAt)
+	}
+	if ubik := byTitle["Ubik"]; ubik.FinishedAt == nil || ubik.FinishedAt.Before(*ubik.StartedAt) {
+		t.Errorf("Ubik: started %v, finished %v", ubik.StartedAt, ubik.FinishedAt)
+	}
+}
+
+func TestImportJSONRejectsOtherFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repositories.NewMemoryRepository()
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", "alice") })
+	router.POST("/import", NewImportHandler(repo, repo, repo, repo, repo, jobs.NewImports()).ImportJSON)
+
+	for _, body := range []string{``, `{"books":[]}`, `{"format":"smartnotes","version":99}`, `Title,Author`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/import", bytes.NewBufferString(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func exportJSON(t *testing.T, router http.Handler, userID string) []byte {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/export", nil)
+	req.Header.Set("X-User", userID)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status %d: %s", w.Code, w.Body)
+	}
+	return w.Body.Bytes()
+}
+
+// importJSON imports a dump and waits for the job to finish.
+func importJSON(t *testing.T, router http.Handler, imports *jobs.Imports, userID string, dump []byte) *domain.ImportJob {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(dump))
+	req.Header.Set("X-User", userID)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("import: status %d: %s", w.Code, w.Body)
+	}
+	var job domain.ImportJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if current, ok := imports.Get(job.ID, userID); ok && current.Status != domain.ImportRunning {
+			return current
+		}
+	}
+	t.Fatal("import did not finish")
+	return nil
+}
+
+func equalJSON(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
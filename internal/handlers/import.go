@@ -0,0 +1,661 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/importer"
+	"github.com/smartnotes/user-service/internal/jobs"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type ImportHandler struct {
+	books     repositories.BookRepository
+	notes     repositories.NoteRepository
+	revisions repositories.RevisionRepository
+	readings  repositories.ReadingRepository
+	shelves   repositories.ShelfRepository
+	imports   *jobs.Imports
+}
+
+func NewImportHandler(books repositories.BookRepository, notes repositories.NoteRepository, revisions repositories.RevisionRepository, readings repositories.ReadingRepository, shelves repositories.ShelfRepository, imports *jobs.Imports) *ImportHandler {
+	return &ImportHandler{books: books, notes: notes, revisions: revisions, readings: readings, shelves: shelves, imports: imports}
+}
+
+// ImportGoodreads accepts a Goodreads or StoryGraph CSV export, either as
+// the "file" field of a multipart form or as the raw request body, and
+// imports it in the background. Poll the returned job for progress.
+func (h *ImportHandler) ImportGoodreads(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	path, file, ok := openUpload(c)
+	if !ok {
+		return
+	}
+	reader, err := importer.NewGoodreadsReader(file)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := h.imports.Start(userID.(string), "goodreads", func(ctx context.Context, p *jobs.ImportProgress) error {
+		defer os.Remove(path)
+		defer file.Close()
+		return h.importGoodreads(ctx, userID.(string), path, reader, p)
+	})
+
+	c.Header("Location", "/api/import/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ImportKindle accepts a Kindle My Clippings.txt file the same way and
+// imports its highlights and notes in the background. Clippings go to the
+// book with the same title and author, which is created when missing.
+// Clippings that are already notes of the book are skipped, so importing
+// the same file again adds nothing.
+func (h *ImportHandler) ImportKindle(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	path, file, ok := openUpload(c)
+	if !ok {
+		return
+	}
+
+	job := h.imports.Start(userID.(string), "kindle", func(ctx context.Context, p *jobs.ImportProgress) error {
+		defer os.Remove(path)
+		defer file.Close()
+		return h.importKindle(ctx, userID.(string), path, importer.NewKindleReader(file), p)
+	})
+
+	c.Header("Location", "/api/import/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ImportJSON accepts a JSON export of this service, sent the same way, and
+// restores it in the background: books with their notes and reading
+// sessions, then shelves. Books already in the library are skipped, but
+// keep their place on imported shelves, so importing the same export again
+// adds nothing. Cover and attachment files are not part of an export and
+// are not restored.
+func (h *ImportHandler) ImportJSON(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	path, file, ok := openUpload(c)
+	if !ok {
+		return
+	}
+	export, err := importer.ReadExport(file)
+	file.Close()
+	os.Remove(path)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := h.imports.Start(userID.(string), "json", func(ctx context.Context, p *jobs.ImportProgress) error {
+		return h.importExport(ctx, userID.(string), export, p)
+	})
+
+	c.Header("Location", "/api/import/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *ImportHandler) GetJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	job, ok := h.imports.Get(c.Param("id"), userID.(string))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *ImportHandler) importGoodreads(ctx context.Context, userID, path string, reader *importer.GoodreadsReader, p *jobs.ImportProgress) error {
+	if total, err := countUpload(path, importer.CountRows); err == nil {
+		p.SetTotal(total)
+	}
+
+	lib, err := h.loadLibrary(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if rec.Err != nil {
+			p.Skip(rec.Row, "", "unreadable row on line "+strconv.Itoa(rec.Line)+": "+rec.Err.Error())
+			continue
+		}
+		if rec.Title == "" {
+			p.Skip(rec.Row, "", "missing title")
+			continue
+		}
+		if _, reason := lib.duplicate(rec.Title, rec.Author, rec.ISBN); reason != "" {
+			p.Skip(rec.Row, rec.Title, reason)
+			continue
+		}
+
+		book := &domain.Book{
+			UserID:    userID,
+			Title:     rec.Title,
+			Author:    rec.Author,
+			ISBN:      rec.ISBN,
+			Publisher: rec.Publisher,
+			Year:      rec.Year,
+			Pages:     rec.Pages,
+			Tags:      domain.NormalizeTags(rec.Tags),
+			Rating:    rec.BookRating(),
+			Review:    rec.Review,
+		}
+		if rec.DateRead != nil {
+			full := 100.0
+			book.Status = domain.StatusFinished
+			book.FinishedAt = rec.DateRead
+			book.CurrentPage = rec.Pages
+			book.Progress = &full
+		}
+		if err := h.createBook(ctx, book); err != nil {
+			return err
+		}
+		lib.add(book)
+		p.Imported()
+	}
+}
+
+func (h *ImportHandler) importKindle(ctx context.Context, userID, path string, reader *importer.KindleReader, p *jobs.ImportProgress) error {
+	if total, err := countUpload(path, importer.CountClippings); err == nil {
+		p.SetTotal(total)
+	}
+
+	lib, err := h.loadLibrary(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// The clippings already stored as notes, by book ID
+	imported := make(map[string]map[string]bool)
+
+	for {
+		clip, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case clip.Err != nil:
+			p.Skip(clip.Entry, clip.Title, "unreadable entry: "+clip.Err.Error())
+			continue
+		case clip.Kind == importer.ClippingBookmark:
+			p.Skip(clip.Entry, clip.Title, "bookmarks have no text")
+			continue
+		case clip.Text == "":
+			p.Skip(clip.Entry, clip.Title, "the clipping is empty")
+			continue
+		}
+
+		book := lib.match(clip.Title, clip.Author)
+		if book == nil {
+			book = &domain.Book{UserID: userID, Title: clip.Title, Author: clip.Author}
+			if err := h.createBook(ctx, book); err != nil {
+				return err
+			}
+			lib.add(book)
+		}
+
+		keys, ok := imported[book.ID]
+		if !ok {
+			notes, err := h.notes.ListNotes(ctx, book.ID, userID)
+			if err != nil {
+				return err
+			}
+			keys = make(map[string]bool, len(notes))
+			for _, note := range notes {
+				keys[clippingKey(note)] = true
+			}
+			imported[book.ID] = keys
+		}
+
+		note := &domain.Note{
+			UserID:   userID,
+			BookID:   book.ID,
+			Kind:     domain.NoteKindHighlight,
+			Body:     clip.Text,
+			Page:     clip.Page,
+			Location: clip.Location,
+		}
+		if clip.Kind == importer.ClippingNote {
+			note.Kind = domain.NoteKindNote
+		}
+		if clip.AddedAt != nil {
+			note.CreatedAt = *clip.AddedAt
+		}
+		if keys[clippingKey(note)] {
+			p.Skip(clip.Entry, clip.Title, "already imported")
+			continue
+		}
+
+		if err := h.createNote(ctx, book, note); err != nil {
+			return err
+		}
+		keys[clippingKey(note)] = true
+		p.Imported()
+	}
+}
+
+func (h *ImportHandler) importExport(ctx context.Context, userID string, export *domain.Export, p *jobs.ImportProgress) error {
+	p.SetTotal(len(export.Books))
+
+	lib, err := h.loadLibrary(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// The IDs of the library's books by their IDs in the export, for
+	// putting them on shelves
+	ids := make(map[string]string, len(export.Books))
+
+	for i, exported := range export.Books {
+		row := i + 1
+		if exported.Title == "" {
+			p.Skip(row, "", "missing title")
+			continue
+		}
+		if book, reason := lib.duplicate(exported.Title, exported.Author, exported.ISBN); book != nil {
+			ids[exported.ID] = book.ID
+			p.Skip(row, exported.Title, reason)
+			continue
+		}
+
+		book, warnings, err := exportedBook(exported, userID, readingTime())
+		if err != nil {
+			p.Skip(row, exported.Title, err.Error())
+			continue
+		}
+		for _, warning := range warnings {
+			p.Warn(row, exported.Title, warning)
+		}
+		if err := h.createBook(ctx, book); err != nil {
+			return err
+		}
+		lib.add(book)
+		ids[exported.ID] = book.ID
+
+		for _, exportedNote := range exported.Notes {
+			note := *exportedNote
+			note.UserID, note.BookID = userID, book.ID
+			if err := h.createNote(ctx, book, &note); err != nil {
+				return err
+			}
+		}
+		for _, exportedSession := range exported.Sessions {
+			session := *exportedSession
+			session.UserID, session.BookID = userID, book.ID
+			if err := h.readings.CreateReadingSession(ctx, &session); err != nil {
+				return err
+			}
+		}
+		p.Imported()
+	}
+
+	return h.importShelves(ctx, userID, export.Shelves, ids)
+}
+
+// exportedBook returns the book to create for a book of an export: all of
+// its fields except those the library assigns. The cover is left out, as
+// its image is not part of the export. Fields CreateBook would reject are
+// left out too, each with a warning, and the reading fields derived from
+// the rest as CreateBook derives them. The error is why a book cannot be
+// imported at all.
+func exportedBook(exported *domain.ExportedBook, userID string, now time.Time) (*domain.Book, []string, error) {
+	book := exported.Book
+	book.ID = ""
+	book.UserID = userID
+	book.Cover = nil
+	book.Tags = domain.NormalizeTags(book.Tags)
+	book.TimeSpent = 0
+	book.Position = nil
+	book.DeletedAt = nil
+
+	var warnings []string
+	if book.Rating != nil && !domain.ValidRating(*book.Rating) {
+		warnings = append(warnings, "invalid rating "+strconv.FormatFloat(*book.Rating, 'f', -1, 64)+" left out")
+		book.Rating = nil
+	}
+	if book.Year != nil && (*book.Year < 1 || *book.Year > 9999) {
+		warnings = append(warnings, "invalid year "+strconv.Itoa(*book.Year)+" left out")
+		book.Year = nil
+	}
+	if book.Pages != nil && *book.Pages < 1 {
+		warnings = append(warnings, "invalid page count "+strconv.Itoa(*book.Pages)+" left out")
+		book.Pages = nil
+	}
+	if book.Status != "" && !slices.Contains(domain.ReadingStatuses, book.Status) {
+		warnings = append(warnings, "unknown status "+strconv.Quote(book.Status)+" left out")
+		book.Status = ""
+	}
+	if book.CurrentPage != nil && (*book.CurrentPage < 0 || book.Pages != nil && *book.CurrentPage > *book.Pages) {
+		warnings = append(warnings, "invalid current page "+strconv.Itoa(*book.CurrentPage)+" left out")
+		book.CurrentPage = nil
+	}
+	if book.Progress != nil && (*book.Progress < 0 || *book.Progress > 100) {
+		warnings = append(warnings, "invalid progress "+strconv.FormatFloat(*book.Progress, 'f', -1, 64)+" left out")
+		book.Progress = nil
+	}
+
+	if book.StartedAt != nil && book.FinishedAt != nil && book.FinishedAt.Before(*book.StartedAt) {
+		warnings = append(warnings, "finish date before the start date left out")
+		book.FinishedAt = nil
+	}
+
+	req := domain.CreateBookRequest{
+		Status:      book.Status,
+		Pages:       book.Pages,
+		CurrentPage: book.CurrentPage,
+		Progress:    book.Progress,
+		StartedAt:   book.StartedAt,
+		FinishedAt:  book.FinishedAt,
+	}
+	if err := req.DeriveReading(now); err != nil {
+		return nil, nil, err
+	}
+	book.Status = req.Status
+	book.CurrentPage = req.CurrentPage
+	book.Progress = req.Progress
+	book.StartedAt = req.StartedAt
+	book.FinishedAt = req.FinishedAt
+	return &book, warnings, nil
+}
+
+// importShelves recreates the shelves of an export, top-level shelves
+// first, and puts the books on them in their order. A shelf with the name
+// of an existing one in the same place is filled up instead.
+func (h *ImportHandler) importShelves(ctx context.Context, userID string, shelves []*domain.Shelf, ids map[string]string) error {
+	existing, err := h.shelves.ListShelves(ctx, userID)
+	if err != nil {
+		return err
+	}
+	shelfIDs := make(map[string]string, len(shelves))
+
+	for _, nested := range []bool{false, true} {
+		for _, exported := range shelves {
+			name := strings.TrimSpace(exported.Name)
+			if (exported.ParentID != "") != nested || name == "" {
+				continue
+			}
+			// A shelf whose parent is missing goes to the top level
+			parentID := shelfIDs[exported.ParentID]
+
+			var shelf *domain.Shelf
+			for _, s := range existing {
+				if s.Name == name && s.ParentID == parentID {
+					shelf = s
+					break
+				}
+			}
+			if shelf == nil {
+				shelf = &domain.Shelf{
+					UserID:      userID,
+					ParentID:    parentID,
+					Name:        name,
+					Description: exported.Description,
+				}
+				if err := h.shelves.CreateShelf(ctx, shelf); err != nil {
+					return err
+				}
+				existing = append(existing, shelf)
+			}
+			shelfIDs[exported.ID] = shelf.ID
+
+			for _, exportedID := range exported.BookIDs {
+				bookID, ok := ids[exportedID]
+				if !ok || slices.Contains(shelf.BookIDs, bookID) {
+					continue
+				}
+				placed, err := h.shelves.PlaceShelfBook(ctx, shelf.ID, userID, bookID, math.MaxInt32)
+				if err != nil {
+					return err
+				}
+				shelf.BookIDs = placed.BookIDs
+			}
+		}
+	}
+	return nil
+}
+
+// clippingKey identifies a clipping among the notes of its book.
+func clippingKey(note *domain.Note) string {
+	page := ""
+	if note.Page != nil {
+		page = strconv.Itoa(*note.Page)
+	}
+	return note.Kind + "\x00" + note.Location + "\x00" + page + "\x00" + note.Body
+}
+
+func (h *ImportHandler) createBook(ctx context.Context, book *domain.Book) error {
+	if err := h.books.Create(ctx, book); err != nil {
+		return err
+	}
+	recordRevision(ctx, h.revisions, book, &domain.Revision{
+		Action:  domain.RevisionCreated,
+		Changes: domain.DiffBooks(nil, book),
+	})
+	return nil
+}
+
+func (h *ImportHandler) createNote(ctx context.Context, book *domain.Book, note *domain.Note) error {
+	if err := h.notes.CreateNote(ctx, note); err != nil {
+		return err
+	}
+	recordRevision(ctx, h.revisions, book, &domain.Revision{
+		Action:  domain.RevisionNoteCreated,
+		NoteID:  note.ID,
+		Changes: domain.DiffNotes(nil, note),
+	})
+	return nil
+}
+
+// library indexes the user's books for matching imported ones against
+// them.
+type library struct {
+	byISBN map[string]*domain.Book
+	byKey  map[string]*domain.Book
+	// byTitle holds the books of each title, whatever their author
+	byTitle map[string][]*domain.Book
+}
+
+func (h *ImportHandler) loadLibrary(ctx context.Context, userID string) (*library, error) {
+	books, err := h.books.List(ctx, userID, &domain.ListBooksQuery{SortKeys: domain.DefaultSort})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &library{
+		byISBN:  make(map[string]*domain.Book),
+		byKey:   make(map[string]*domain.Book),
+		byTitle: make(map[string][]*domain.Book),
+	}
+	for _, book := range books {
+		l.add(book)
+	}
+	return l, nil
+}
+
+func (l *library) add(book *domain.Book) {
+	if book.ISBN != "" {
+		l.byISBN[isbnKey(book.ISBN)] = book
+	}
+	l.byKey[importer.MatchKey(book.Title, book.Author)] = book
+	title := importer.MatchKey(book.Title, "")
+	l.byTitle[title] = append(l.byTitle[title], book)
+}
+
+// duplicate returns the book of the library that a book counts as being
+// already, and why, or nil and "" when there is none.
+func (l *library) duplicate(title, author, isbn string) (*domain.Book, string) {
+	if book := l.byISBN[isbnKey(isbn)]; isbn != "" && book != nil {
+		return book, "a book with this ISBN is already in your library"
+	}
+	if book := l.byKey[importer.MatchKey(title, author)]; book != nil {
+		return book, "a book with this title and author is already in your library"
+	}
+	return nil, ""
+}
+
+// isbnKey matches books saved before ISBNs were stored as ISBN-13 too.
+func isbnKey(isbn string) string {
+	if normalized, err := domain.ParseISBN(isbn); err == nil {
+		return normalized
+	}
+	return isbn
+}
+
+// match finds the book with the given title and author, or with the given
+// title alone when the library has only one book of that title.
+func (l *library) match(title, author string) *domain.Book {
+	if book := l.byKey[importer.MatchKey(title, author)]; book != nil {
+		return book
+	}
+	if books := l.byTitle[importer.MatchKey(title, "")]; len(books) == 1 {
+		return books[0]
+	}
+	return nil
+}
+
+// spoolUpload copies the uploaded file to a temporary file, so that it can
+// be imported after the request has been answered, and returns its path.
+// It writes the error response itself when that fails.
+func spoolUpload(c *gin.Context) (string, bool) {
+	upload, ok := uploadReader(c, maxImportSize())
+	if !ok {
+		return "", false
+	}
+
+	file, err := os.CreateTemp("", "smartnotes-import-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, upload); err != nil {
+		os.Remove(file.Name())
+		writeUploadError(c, err)
+		return "", false
+	}
+	return file.Name(), true
+}
+
+func writeUploadError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is larger than " + strconv.FormatInt(tooLarge.Limit, 10) + " bytes"})
+	case errors.Is(err, io.EOF):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The form has no file field"})
+	default:
+		log.Printf("Failed to read upload: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+	}
+}
+
+// uploadReader returns the file of a request, sent either as the "file"
+// field of a multipart form or as the whole body, and caps it at limit
+// bytes. It writes the error response itself when there is no file.
+func uploadReader(c *gin.Context, limit int64) (io.Reader, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	if mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type")); mediaType != "multipart/form-data" {
+		return c.Request.Body, true
+	}
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			writeUploadError(c, err)
+			return nil, false
+		}
+		if part.FormName() == "file" {
+			return part, true
+		}
+	}
+}
+
+// openUpload spools the upload and opens the copy for reading. It writes
+// the error response itself when that fails.
+func openUpload(c *gin.Context) (string, *os.File, bool) {
+	path, ok := spoolUpload(c)
+	if !ok {
+		return "", nil, false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", nil, false
+	}
+	return path, file, true
+}
+
+// countUpload runs count over a fresh reader of a spooled upload.
+func countUpload(path string, count func(io.Reader) (int, error)) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return count(file)
+}
+
+// maxImportSize is the largest upload, in bytes, the import endpoints
+// accept. Set MAX_IMPORT_SIZE to change it.
+func maxImportSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MAX_IMPORT_SIZE"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 20 << 20
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/metadata"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type MetadataHandler struct {
+	provider metadata.MetadataProvider
+}
+
+func NewMetadataHandler(provider metadata.MetadataProvider) *MetadataHandler {
+	return &MetadataHandler{provider: provider}
+}
+
+// LookupISBN returns what the metadata providers know about an ISBN-10 or
+// ISBN-13, so clients can preview a book before adding it.
+func (h *MetadataHandler) LookupISBN(c *gin.Context) {
+	isbn, err := domain.ParseISBN(c.Param("isbn"))
+	if err != nil || isbn == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": domain.ErrInvalidISBN.Error()})
+		return
+	}
+
+	meta, err := h.provider.Lookup(c.Request.Context(), isbn)
+	if errors.Is(err, metadata.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "ISBN lookup failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
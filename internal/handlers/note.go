@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type NoteHandler struct {
+	books     repositories.BookRepository
+	notes     repositories.NoteRepository
+	revisions repositories.RevisionRepository
+}
+
+func NewNoteHandler(books repositories.BookRepository, notes repositories.NoteRepository, revisions repositories.RevisionRepository) *NoteHandler {
+	return &NoteHandler{books: books, notes: notes, revisions: revisions}
+}
+
+// bookForRequest resolves the :id path parameter to a book owned by the
+// current user, writing the error response itself when that fails.
+func (h *NoteHandler) bookForRequest(c *gin.Context) (*domain.Book, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	book, err := h.books.GetByID(c.Request.Context(), c.Param("id"), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return nil, false
+	}
+
+	return book, true
+}
+
+func (h *NoteHandler) CreateNote(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	var req domain.CreateNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	note := &domain.Note{
+		UserID:   book.UserID,
+		BookID:   book.ID,
+		Body:     req.Body,
+		Kind:     req.Kind,
+		Page:     req.Page,
+		Location: req.Location,
+	}
+
+	if err := h.notes.CreateNote(c.Request.Context(), note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordRevision(c.Request.Context(), h.revisions, book, &domain.Revision{
+		Action:  domain.RevisionNoteCreated,
+		NoteID:  note.ID,
+		Changes: domain.DiffNotes(nil, note),
+	})
+
+	c.JSON(http.StatusCreated, note)
+}
+
+func (h *NoteHandler) ListNotes(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	notes, err := h.notes.ListNotes(c.Request.Context(), book.ID, book.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notes": notes,
+		"total": len(notes),
+	})
+}
+
+func (h *NoteHandler) GetNote(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("noteId")
+
+	note, err := h.notes.GetNoteByID(c.Request.Context(), id, book.ID, book.UserID)
+	if err != nil {
+		writeNoteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, note)
+}
+
+func (h *NoteHandler) UpdateNote(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("noteId")
+
+	var req domain.UpdateNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := h.notes.GetNoteByID(c.Request.Context(), id, book.ID, book.UserID)
+	if err != nil {
+		writeNoteError(c, err)
+		return
+	}
+
+	note, err := h.notes.UpdateNote(c.Request.Context(), id, book.ID, book.UserID, &req)
+	if err != nil {
+		writeNoteError(c, err)
+		return
+	}
+	recordRevision(c.Request.Context(), h.revisions, book, &domain.Revision{
+		Action:  domain.RevisionNoteUpdated,
+		NoteID:  note.ID,
+		Changes: domain.DiffNotes(before, note),
+	})
+
+	c.JSON(http.StatusOK, note)
+}
+
+func (h *NoteHandler) DeleteNote(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("noteId")
+
+	note, err := h.notes.GetNoteByID(c.Request.Context(), id, book.ID, book.UserID)
+	if err != nil {
+		writeNoteError(c, err)
+		return
+	}
+
+	if err := h.notes.DeleteNote(c.Request.Context(), id, book.ID, book.UserID); err != nil {
+		writeNoteError(c, err)
+		return
+	}
+	recordRevision(c.Request.Context(), h.revisions, book, &domain.Revision{
+		Action:  domain.RevisionNoteDeleted,
+		NoteID:  note.ID,
+		Changes: domain.DiffNotes(note, nil),
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func writeNoteError(c *gin.Context, err error) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type ReadingHandler struct {
+	books     repositories.BookRepository
+	readings  repositories.ReadingRepository
+	revisions repositories.RevisionRepository
+}
+
+func NewReadingHandler(books repositories.BookRepository, readings repositories.ReadingRepository, revisions repositories.RevisionRepository) *ReadingHandler {
+	return &ReadingHandler{books: books, readings: readings, revisions: revisions}
+}
+
+// ListSessions returns a book's reading sessions, earliest first, and the
+// time spent in them.
+func (h *ReadingHandler) ListSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.books.GetByID(ctx, id, userID.(string)); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	sessions, err := h.readings.ListReadingSessions(ctx, id, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var spent int64
+	for _, session := range sessions {
+		spent += session.Duration()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":   sessions,
+		"total":      len(sessions),
+		"time_spent": spent,
+	})
+}
+
+// CreateSession logs a reading session. The book moves to the reading
+// status unless it is finished or abandoned, and its current page
+// advances by the pages read, up to its last page.
+func (h *ReadingHandler) CreateSession(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.CreateReadingSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.Validate(readingTime()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reading session", "details": err})
+		return
+	}
+
+	ctx := c.Request.Context()
+	book, err := h.books.GetByID(ctx, id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+
+	session := &domain.ReadingSession{
+		BookID: id,
+		UserID: userID.(string),
+		Start:  req.Start.UTC().Truncate(time.Second),
+		End:    req.End.UTC().Truncate(time.Second),
+		Pages:  req.Pages,
+	}
+	if err := h.readings.CreateReadingSession(ctx, session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	book, err = h.updateReading(ctx, book, func(book *domain.Book) *domain.UpdateBookRequest {
+		return sessionUpdate(book, session)
+	})
+	if err != nil {
+		// The session is logged either way; only the book is behind
+		log.Printf("Failed to record reading session %s on book %s: %v", session.ID, id, err)
+	}
+	addTimeSpent(ctx, h.readings, userID.(string), book)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"session": session,
+		"book":    book,
+	})
+}
+
+func (h *ReadingHandler) DeleteSession(c *gin.Context) {
+	id := c.Param("id")
+	sessionID := c.Param("sessionId")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.readings.DeleteReadingSession(ctx, sessionID, id, userID.(string))
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reading session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The book's time spent went down, so it gets a new version
+	book, err := h.books.GetByID(ctx, id, userID.(string))
+	if err == nil {
+		_, err = h.updateReading(ctx, book, func(*domain.Book) *domain.UpdateBookRequest { return nil })
+	}
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		log.Printf("Failed to record deleted reading session %s on book %s: %v", sessionID, id, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// updateReading applies the update a change of the book's reading sessions
+// makes to it and returns the book as it is afterwards. The book gets a new
+// version even when update returns nil, as its time spent changed and
+// clients revalidating it by ETag must see that. Concurrent updates of the
+// book are retried, as the client did not ask for a particular version.
+func (h *ReadingHandler) updateReading(ctx context.Context, book *domain.Book, update func(*domain.Book) *domain.UpdateBookRequest) (*domain.Book, error) {
+	for attempt := 0; ; attempt++ {
+		change := update(book)
+		if change == nil {
+			change = &domain.UpdateBookRequest{}
+		}
+		before, after, err := updateBook(ctx, h.books, book.ID, book.UserID, &book.Version, change)
+		if errors.Is(err, repositories.ErrVersionMismatch) && attempt < 2 {
+			current, err := h.books.GetByID(ctx, book.ID, book.UserID)
+			if err != nil {
+				return book, err
+			}
+			book = current
+			continue
+		}
+		if err != nil {
+			return book, err
+		}
+		if changes := domain.DiffBooks(before, after); len(changes) > 0 {
+			recordRevision(ctx, h.revisions, after, &domain.Revision{
+				Action:  domain.RevisionUpdated,
+				Changes: changes,
+			})
+		}
+		return after, nil
+	}
+}
+
+// sessionUpdate returns the update a session makes to a book, or nil when
+// it changes nothing.
+func sessionUpdate(book *domain.Book, session *domain.ReadingSession) *domain.UpdateBookRequest {
+	update := &domain.UpdateBookRequest{}
+	changed := false
+	if book.Status == "" || book.Status == domain.StatusWantToRead {
+		status := domain.StatusReading
+		update.Status = &status
+		changed = true
+	}
+	if book.StartedAt == nil || session.Start.Before(*book.StartedAt) {
+		if book.Status != domain.StatusFinished && book.Status != domain.StatusAbandoned {
+			update.StartedAt = &session.Start
+			changed = true
+		}
+	}
+	if session.Pages != nil && *session.Pages > 0 {
+		page := *session.Pages
+		if book.CurrentPage != nil {
+			page += *book.CurrentPage
+		}
+		if book.Pages != nil && page > *book.Pages {
+			page = *book.Pages
+		}
+		if book.CurrentPage == nil || page != *book.CurrentPage {
+			update.CurrentPage = &page
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return update
+}
+
+// addTimeSpent sets the TimeSpent of books from their reading sessions.
+// It only adds information, so a failure is logged rather than returned.
+func addTimeSpent(ctx context.Context, readings repositories.ReadingRepository, userID string, books ...*domain.Book) {
+	if len(books) == 0 {
+		return
+	}
+	ids := make([]string, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	spent, err := readings.TimeSpent(ctx, userID, ids)
+	if err != nil {
+		log.Printf("Failed to sum reading time of %d books: %v", len(books), err)
+		return
+	}
+	for _, book := range books {
+		book.TimeSpent = spent[book.ID]
+	}
+}
+
+// readingTime is the time recorded for reading events, such as starting
+// a book, at the precision of the dates clients send.
+func readingTime() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type RevisionHandler struct {
+	books     repositories.BookRepository
+	revisions repositories.RevisionRepository
+}
+
+func NewRevisionHandler(books repositories.BookRepository, revisions repositories.RevisionRepository) *RevisionHandler {
+	return &RevisionHandler{books: books, revisions: revisions}
+}
+
+func (h *RevisionHandler) ListRevisions(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	revisions, err := h.revisions.ListRevisions(c.Request.Context(), book.ID, book.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+		"total":     len(revisions),
+	})
+}
+
+func (h *RevisionHandler) GetRevision(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	rev, err := h.revisions.GetRevision(c.Request.Context(), c.Param("rev"), book.ID, book.UserID)
+	if err != nil {
+		writeRevisionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rev)
+}
+
+// RevertBook sets the book's fields back to what they were right after the
+// given revision. Notes are left alone. If-Match is optional here; without
+// it the revert applies to whatever version is current.
+func (h *RevisionHandler) RevertBook(c *gin.Context) {
+	book, ok := h.bookForRequest(c)
+	if !ok {
+		return
+	}
+
+	var version *int64
+	if c.GetHeader("If-Match") != "" {
+		if version, ok = ifMatchVersion(c); !ok {
+			return
+		}
+	}
+
+	rev, err := h.revisions.GetRevision(c.Request.Context(), c.Param("rev"), book.ID, book.UserID)
+	if err != nil {
+		writeRevisionError(c, err)
+		return
+	}
+
+	updated := applyBookUpdate(c, h.books, h.revisions, book.ID, book.UserID, version, rev.Book.Update(),
+		&domain.Revision{Action: domain.RevisionReverted, RevertedTo: rev.ID})
+	if updated == nil {
+		return
+	}
+
+	c.Header("ETag", updated.ETag())
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *RevisionHandler) bookForRequest(c *gin.Context) (*domain.Book, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	book, err := h.books.GetByID(c.Request.Context(), c.Param("id"), userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return nil, false
+	}
+
+	return book, true
+}
+
+func writeRevisionError(c *gin.Context, err error) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// applyBookUpdate updates a book and logs the change, described by rev, in
+// its history. It writes the error response itself and returns nil when the
+// update fails.
+func applyBookUpdate(c *gin.Context, books repositories.BookRepository, revisions repositories.RevisionRepository,
+	id, userID string, version *int64, update *domain.UpdateBookRequest, rev *domain.Revision) *domain.Book {
+	before, book, err := updateBook(c.Request.Context(), books, id, userID, version, update)
+	if err != nil {
+		writeBookError(c, err)
+		return nil
+	}
+
+	rev.Changes = domain.DiffBooks(before, book)
+	recordRevision(c.Request.Context(), revisions, book, rev)
+	return book
+}
+
+// recordRevision appends rev, made to book, to the book's history. The
+// change itself has already been stored, so a failure is only logged.
+func recordRevision(ctx context.Context, revisions repositories.RevisionRepository, book *domain.Book, rev *domain.Revision) {
+	rev.BookID = book.ID
+	rev.UserID = book.UserID
+	rev.Book = domain.NewBookSnapshot(book)
+	rev.Version = book.Version
+	if rev.Changes == nil {
+		rev.Changes = []domain.FieldChange{}
+	}
+
+	if err := revisions.AddRevision(ctx, rev, revisionRetention()); err != nil {
+		log.Printf("Failed to record %s revision of book %s: %v", rev.Action, book.ID, err)
+	}
+}
+
+// revisionRetention is how many revisions are kept per book. Set
+// REVISION_RETENTION to change it.
+func revisionRetention() int {
+	if n, err := strconv.Atoi(os.Getenv("REVISION_RETENTION")); err == nil && n > 0 {
+		return n
+	}
+	return 100
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type ShelfHandler struct {
+	shelves  repositories.ShelfRepository
+	books    repositories.BookRepository
+	readings repositories.ReadingRepository
+}
+
+func NewShelfHandler(shelves repositories.ShelfRepository, books repositories.BookRepository, readings repositories.ReadingRepository) *ShelfHandler {
+	return &ShelfHandler{shelves: shelves, books: books, readings: readings}
+}
+
+func (h *ShelfHandler) ListShelves(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	shelves, err := h.shelves.ListShelves(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shelves": shelves,
+		"total":   len(shelves),
+	})
+}
+
+func (h *ShelfHandler) CreateShelf(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.CreateShelfRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shelf name must not be blank"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.ParentID != "" {
+		if problem, err := h.checkParent(ctx, userID.(string), "", req.ParentID); err != nil {
+			writeShelfError(c, err)
+			return
+		} else if problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+	}
+
+	shelf := &domain.Shelf{
+		UserID:      userID.(string),
+		ParentID:    req.ParentID,
+		Name:        name,
+		Description: req.Description,
+	}
+	if err := h.shelves.CreateShelf(ctx, shelf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, shelf)
+}
+
+func (h *ShelfHandler) GetShelf(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	shelf, err := h.shelves.GetShelf(c.Request.Context(), c.Param("id"), userID.(string))
+	if err != nil {
+		writeShelfError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shelf)
+}
+
+// UpdateShelf renames, describes or moves a shelf; an empty parent_id moves
+// it to the top level.
+func (h *ShelfHandler) UpdateShelf(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.UpdateShelfRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Shelf name must not be blank"})
+			return
+		}
+		req.Name = &name
+	}
+
+	ctx := c.Request.Context()
+	if req.ParentID != nil && *req.ParentID != "" {
+		if problem, err := h.checkParent(ctx, userID.(string), id, *req.ParentID); err != nil {
+			writeShelfError(c, err)
+			return
+		} else if problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+	}
+
+	shelf, err := h.shelves.UpdateShelf(ctx, id, userID.(string), &req)
+	if err != nil {
+		writeShelfError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shelf)
+}
+
+// DeleteShelf deletes a shelf but not its books; its own shelves move to
+// the top level.
+func (h *ShelfHandler) DeleteShelf(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.shelves.DeleteShelf(c.Request.Context(), c.Param("id"), userID.(string)); err != nil {
+		writeShelfError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListShelfBooks lists the books on a shelf with the filters, sorting and
+// paging of ListBooks. They come in the shelf's order unless sorted
+// otherwise, each with its position on the shelf.
+func (h *ShelfHandler) ListShelfBooks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	shelf, err := h.shelves.GetShelf(c.Request.Context(), c.Param("id"), userID.(string))
+	if err != nil {
+		writeShelfError(c, err)
+		return
+	}
+
+	query := domain.ListBooksQuery{Shelf: shelf}
+	if !bindListBooksQuery(c, &query) {
+		return
+	}
+	listBooks(c, h.books, h.readings, userID.(string), &query)
+}
+
+// PlaceBook puts a book on a shelf at the given position, last by default.
+// A book already on the shelf moves there, which is how books are dragged
+// into a new order one at a time.
+func (h *ShelfHandler) PlaceBook(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.PlaceShelfBookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.books.GetByID(ctx, req.BookID, userID.(string)); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	position := math.MaxInt32
+	if req.Position != nil {
+		position = *req.Position
+	}
+
+	shelf, err := h.shelves.PlaceShelfBook(ctx, id, userID.(string), req.BookID, position)
+	if err != nil {
+		writeShelfError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shelf)
+}
+
+// ReorderBooks puts the books of a shelf in a new order, given as the full
+// list of their IDs.
+func (h *ShelfHandler) ReorderBooks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req domain.ReorderShelfRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shelf, err := h.shelves.ReorderShelf(c.Request.Context(), c.Param("id"), userID.(string), req.BookIDs)
+	if err != nil {
+		writeShelfError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shelf)
+}
+
+func (h *ShelfHandler) RemoveBook(c *gin.Context) {
+	id := c.Param("id")
+	bookID := c.Param("bookId")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	_, err := h.shelves.RemoveShelfBook(c.Request.Context(), id, userID.(string), bookID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book is not on this shelf"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkParent returns why parentID cannot hold the shelf id, which is ""
+// for a new shelf, or "" when it can. Shelves nest one level deep, so the
+// parent must be a top-level shelf and the shelf must have none of its own.
+func (h *ShelfHandler) checkParent(ctx context.Context, userID, id, parentID string) (string, error) {
+	if parentID == id {
+		return "A shelf cannot be nested in itself", nil
+	}
+	parent, err := h.shelves.GetShelf(ctx, parentID, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return "Parent shelf not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if parent.ParentID != "" {
+		return "Shelves can only be nested one level deep", nil
+	}
+	if id == "" {
+		return "", nil
+	}
+
+	shelves, err := h.shelves.ListShelves(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	for _, shelf := range shelves {
+		if shelf.ParentID == id {
+			return "A shelf holding other shelves cannot be nested", nil
+		}
+	}
+	return "", nil
+}
+
+func writeShelfError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repositories.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shelf not found"})
+	case errors.Is(err, domain.ErrShelfOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// addPositions sets the Position of books listed from shelf, if any.
+func addPositions(shelf *domain.Shelf, books ...*domain.Book) {
+	if shelf == nil {
+		return
+	}
+	positions := shelf.Positions()
+	for _, book := range books {
+		if position, ok := positions[book.ID]; ok {
+			book.Position = &position
+		}
+	}
+}
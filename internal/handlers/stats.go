@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type StatsHandler struct {
+	books repositories.BookRepository
+	stats repositories.StatsRepository
+}
+
+func NewStatsHandler(books repositories.BookRepository, stats repositories.StatsRepository) *StatsHandler {
+	return &StatsHandler{books: books, stats: stats}
+}
+
+// ReadingStats returns the reading dashboard of the caller, optionally for
+// the books added or finished and the sessions started between from and to.
+func (h *StatsHandler) ReadingStats(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var query domain.StatsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": domain.ValidationErrors{{Field: "query", Message: err.Error()}},
+		})
+		return
+	}
+	if err := query.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "details": err})
+		return
+	}
+
+	stats, err := h.stats.ReadingStats(c.Request.Context(), userID.(string), &query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "private, no-cache")
+	writeCacheableJSON(c, stats)
+}
+
+// RatingStats returns the average rating and the rating histogram of the
+// books matching the filters ListBooks takes.
+func (h *StatsHandler) RatingStats(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var query domain.ListBooksQuery
+	if !bindListBooksQuery(c, &query) {
+		return
+	}
+
+	stats, err := h.books.RatingStats(c.Request.Context(), userID.(string), &query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeCacheableJSON(c, stats)
+}
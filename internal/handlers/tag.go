@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type TagHandler struct {
+	tags      repositories.TagRepository
+	books     repositories.BookRepository
+	revisions repositories.RevisionRepository
+}
+
+func NewTagHandler(tags repositories.TagRepository, books repositories.BookRepository, revisions repositories.RevisionRepository) *TagHandler {
+	return &TagHandler{tags: tags, books: books, revisions: revisions}
+}
+
+// ListTags returns the caller's tags with the number of books carrying
+// each, most used first. A prefix turns it into tag autocompletion.
+func (h *TagHandler) ListTags(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var query domain.ListTagsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := h.tags.ListTags(c.Request.Context(), userID.(string), domain.NormalizeTag(query.Prefix), query.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeCacheableJSON(c, gin.H{
+		"tags":  tags,
+		"total": len(tags),
+	})
+}
+
+// RenameTag renames a tag on every book carrying it. Books that already
+// carry the new name keep it once.
+func (h *TagHandler) RenameTag(c *gin.Context) {
+	var req domain.RenameTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.retag(c, []string{req.From}, req.To)
+}
+
+// MergeTags replaces several tags with one on every book carrying any of
+// them.
+func (h *TagHandler) MergeTags(c *gin.Context) {
+	var req domain.MergeTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.retag(c, req.Tags, req.Into)
+}
+
+// DeleteTag takes a tag off every book carrying it. The books stay. The tag
+// is the rest of the path, as tags may contain slashes.
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	h.retag(c, []string{strings.TrimPrefix(c.Param("tag"), "/")}, "")
+}
+
+// retag replaces the tags from with to, or drops them when to is "", on all
+// of the caller's books, one conditional update per book so it works
+// without database transactions. Tags are matched by their
+// normalized form, so spellings stored before normalization are caught too.
+// Books that keep changing while they are retagged are reported as
+// conflicts with 409, and a failure stops with the number of books retagged
+// so far; either way the request can be repeated to finish the job.
+func (h *TagHandler) retag(c *gin.Context, from []string, to string) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	replace := map[string]bool{}
+	for _, tag := range from {
+		if tag = domain.NormalizeTag(tag); tag != "" {
+			replace[tag] = true
+		}
+	}
+	deleting := to == ""
+	to = domain.NormalizeTag(to)
+	if len(replace) == 0 || to == "" && !deleting {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tags must not be blank"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	stored, err := h.storedTags(ctx, userID.(string), replace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(stored) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	tagged, err := h.books.List(ctx, userID.(string), &domain.ListBooksQuery{
+		Tags:     stored,
+		TagMode:  domain.TagModeAny,
+		SortKeys: domain.DefaultSort,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	changed := 0
+	conflicts := []string{}
+	for _, book := range tagged {
+		ok, err := h.retagBook(ctx, book, replace, to)
+		if errors.Is(err, repositories.ErrVersionMismatch) {
+			conflicts = append(conflicts, book.ID)
+			continue
+		}
+		if err != nil {
+			status, message := bookErrorStatus(err)
+			c.JSON(status, gin.H{"error": message, "tag": to, "books": changed})
+			return
+		}
+		if ok {
+			changed++
+		}
+	}
+
+	if len(conflicts) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Some books changed while they were retagged, repeat the request to retag them",
+			"tag":       to,
+			"books":     changed,
+			"conflicts": conflicts,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tag":   to,
+		"books": changed,
+	})
+}
+
+// retagBook retags one book and reports whether it changed. The update is
+// pinned to the version read and retried twice when the book changed
+// meanwhile, then fails with ErrVersionMismatch.
+func (h *TagHandler) retagBook(ctx context.Context, book *domain.Book, replace map[string]bool, to string) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		tags := retagged(book.Tags, replace, to)
+		if slices.Equal(tags, book.Tags) {
+			return false, nil
+		}
+		before, after, err := updateBook(ctx, h.books, book.ID, book.UserID, &book.Version, &domain.UpdateBookRequest{Tags: &tags})
+		if errors.Is(err, repositories.ErrVersionMismatch) && attempt < 2 {
+			current, err := h.books.GetByID(ctx, book.ID, book.UserID)
+			if errors.Is(err, repositories.ErrNotFound) {
+				// Deleted meanwhile, nothing left to retag
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			book = current
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		recordRevision(ctx, h.revisions, after, &domain.Revision{
+			Action:  domain.RevisionUpdated,
+			Changes: domain.DiffBooks(before, after),
+		})
+		return true, nil
+	}
+}
+
+// storedTags returns the tags as stored on the user's books whose
+// normalized form is in normalized.
+func (h *TagHandler) storedTags(ctx context.Context, userID string, normalized map[string]bool) ([]string, error) {
+	tags, err := h.tags.ListTags(ctx, userID, "", math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	var stored []string
+	for _, tag := range tags {
+		if normalized[domain.NormalizeTag(tag.Value)] {
+			stored = append(stored, tag.Value)
+		}
+	}
+	return stored, nil
+}
+
+// retagged returns tags with those in replace swapped for to, or dropped
+// when to is "", in normalized form.
+func retagged(tags []string, replace map[string]bool, to string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if replace[domain.NormalizeTag(tag)] {
+			tag = to
+		}
+		result = append(result, tag)
+	}
+	return domain.NormalizeTags(result)
+}
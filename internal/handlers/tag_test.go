@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// busyBooks fails every update of the book with ID busy, as if another
+// client kept editing it.
+type busyBooks struct {
+	*repositories.MemoryRepository
+	busy string
+}
+
+func (b *busyBooks) Update(ctx context.Context, id, userID string, version *int64, update *domain.UpdateBookRequest) (*domain.Book, error) {
+	if id == b.busy {
+		return nil, repositories.ErrVersionMismatch
+	}
+	return b.MemoryRepository.Update(ctx, id, userID, version, update)
+}
+
+func TestRenameTagReportsConflicts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	repo := repositories.NewMemoryRepository()
+	dune := &domain.Book{UserID: "alice", Title: "Dune", Tags: []string{"sf"}}
+	solaris := &domain.Book{UserID: "alice", Title: "Solaris", Tags: []string{"sf", "classics"}}
+	for _, book := range []*domain.Book{dune, solaris} {
+		if err := repo.Create(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	books := &busyBooks{MemoryRepository: repo, busy: solaris.ID}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", "alice") })
+	router.POST("/rename", NewTagHandler(repo, books, repo).RenameTag)
+	rename := func() (int, map[string]any) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rename", bytes.NewBufferString(`{"from":"SF","to":"sci-fi"}`)))
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, body
+	}
+
+	status, body := rename()
+	if status != http.StatusConflict || body["books"] != 1.0 || !slices.Equal(body["conflicts"].([]any), []any{solaris.ID}) {
+		t.Fatalf("first rename: status %d, body %v", status, body)
+	}
+	if got, _ := repo.GetByID(ctx, dune.ID, "alice"); !slices.Equal(got.Tags, []string{"sci-fi"}) {
+		t.Errorf("Dune tags = %q", got.Tags)
+	}
+
+	// Repeating the request once the book is free finishes the job
+	books.busy = ""
+	status, body = rename()
+	if status != http.StatusOK || body["books"] != 1.0 {
+		t.Fatalf("second rename: status %d, body %v", status, body)
+	}
+	if got, _ := repo.GetByID(ctx, solaris.ID, "alice"); !slices.Equal(got.Tags, []string{"sci-fi", "classics"}) {
+		t.Errorf("Solaris tags = %q", got.Tags)
+	}
+}
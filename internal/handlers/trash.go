@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smartnotes/user-service/internal/blob"
+	"github.com/smartnotes/user-service/internal/jobs"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type TrashHandler struct {
+	repo      repositories.TrashRepository
+	revisions repositories.RevisionRepository
+	blobs     blob.BlobStore
+}
+
+func NewTrashHandler(repo repositories.TrashRepository, revisions repositories.RevisionRepository, blobs blob.BlobStore) *TrashHandler {
+	return &TrashHandler{repo: repo, revisions: revisions, blobs: blobs}
+}
+
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	books, err := h.repo.ListTrash(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"books": books,
+		"total": len(books),
+	})
+}
+
+func (h *TrashHandler) RestoreBook(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	book, err := h.repo.Restore(c.Request.Context(), id, userID.(string))
+	if err != nil {
+		writeBookError(c, err)
+		return
+	}
+	recordRevision(c.Request.Context(), h.revisions, book, &domain.Revision{Action: domain.RevisionRestored})
+
+	c.Header("ETag", book.ETag())
+	c.JSON(http.StatusOK, book)
+}
+
+// PurgeBook permanently deletes a book from the trash, files included.
+func (h *TrashHandler) PurgeBook(c *gin.Context) {
+	id := c.Param("id")
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.repo.Purge(c.Request.Context(), id, userID.(string)); err != nil {
+		writeBookError(c, err)
+		return
+	}
+	jobs.DeleteBookFiles(c.Request.Context(), h.blobs, id)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	purged, err := h.repo.EmptyTrash(c.Request.Context(), userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, id := range purged {
+		jobs.DeleteBookFiles(c.Request.Context(), h.blobs, id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": len(purged)})
+}
@@ -0,0 +1,29 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// ReadExport reads a JSON export of this service, of the current or an
+// earlier version, and fails when the file is not one.
+func ReadExport(r io.Reader) (*domain.Export, error) {
+	var export domain.Export
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("the file is empty")
+		}
+		return nil, fmt.Errorf("the file is not valid JSON: %w", err)
+	}
+	if export.Format != domain.ExportFormat {
+		return nil, errors.New("the file is not a SmartNotes JSON export")
+	}
+	if export.Version < 1 || export.Version > domain.ExportVersion {
+		return nil, fmt.Errorf("export version %d is not supported, expected at most %d", export.Version, domain.ExportVersion)
+	}
+	return &export, nil
+}
@@ -0,0 +1,268 @@
+// Package importer reads the exports of other reading services into
+// records that map onto books.
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// Record is one book read from an export.
+type Record struct {
+	// Row is the 1-based row number, not counting the header
+	Row int
+	// Line is the line of the file the row starts on; quoted fields may
+	// span lines
+	Line   int
+	Title  string
+	Author string
+	ISBN   string
+	// Publisher, Year and Pages describe the edition read
+	Publisher string
+	Year      *int
+	Pages     *int
+	// Rating is out of 5; 0 means unrated
+	Rating   float64
+	Tags     []string
+	DateRead *time.Time
+	Review   string
+	// Err is set when the row could not be read; the other fields are
+	// then empty
+	Err error
+}
+
+// BookRating returns the rating of the record rounded to half stars, or
+// nil when it is unrated.
+func (r *Record) BookRating() *float64 {
+	if r.Rating <= 0 {
+		return nil
+	}
+	rating := math.Max(math.Round(r.Rating*2)/2, domain.MinRating)
+	return &rating
+}
+
+// goodreadsColumns lists the headers each field is read from: Goodreads'
+// first, then StoryGraph's. The first non-empty ISBN column wins; the tags
+// are collected from all shelf columns.
+var goodreadsColumns = map[string][]string{
+	"title":     {"Title"},
+	"author":    {"Author", "Authors"},
+	"isbn":      {"ISBN13", "ISBN", "ISBN/UID"},
+	"publisher": {"Publisher"},
+	"year":      {"Year Published", "Original Publication Year"},
+	"pages":     {"Number of Pages"},
+	"rating":    {"My Rating", "Star Rating"},
+	"tags":      {"Bookshelves", "Exclusive Shelf", "Read Status", "Tags"},
+	"date_read": {"Date Read", "Last Date Read"},
+	"review":    {"My Review", "Review"},
+}
+
+var dateReadLayouts = []string{"2006/01/02", "2006-01-02", "2006/1/2", "2006/01", "2006"}
+
+// GoodreadsReader reads a Goodreads or StoryGraph CSV export row by row.
+type GoodreadsReader struct {
+	csv     *csv.Reader
+	columns map[string][]int
+	row     int
+}
+
+// NewGoodreadsReader reads the header of an export and fails when it does
+// not look like one.
+func NewGoodreadsReader(r io.Reader) (*GoodreadsReader, error) {
+	// Quotes are strict and every row must have as many fields as the
+	// header, so that a broken row is skipped instead of shifting its
+	// fields into the wrong columns
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the file is not valid CSV: %w", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		index[strings.ToLower(name)] = i
+	}
+
+	columns := make(map[string][]int, len(goodreadsColumns))
+	for field, names := range goodreadsColumns {
+		for _, name := range names {
+			if i, ok := index[strings.ToLower(name)]; ok {
+				columns[field] = append(columns[field], i)
+			}
+		}
+	}
+	if len(columns["title"]) == 0 {
+		return nil, errors.New("not a Goodreads or StoryGraph export: there is no Title column")
+	}
+
+	return &GoodreadsReader{csv: cr, columns: columns}, nil
+}
+
+// Next returns the next row, or io.EOF after the last one. A row that is
+// not valid CSV, or has a different number of fields than the header,
+// comes back as a Record with Err set. An unterminated quote runs to the
+// end of the file, so it ends the export.
+func (g *GoodreadsReader) Next() (*Record, error) {
+	fields, err := g.csv.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	g.row++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &Record{Row: g.row, Line: parseErr.StartLine, Err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	line, _ := g.csv.FieldPos(0)
+
+	rec := &Record{
+		Row:       g.row,
+		Line:      line,
+		Title:     g.first(fields, "title"),
+		Author:    g.first(fields, "author"),
+		Publisher: g.first(fields, "publisher"),
+		Year:      positiveInt(g.first(fields, "year")),
+		Pages:     positiveInt(g.first(fields, "pages")),
+		Review:    cleanReview(g.first(fields, "review")),
+	}
+	for _, i := range g.columns["isbn"] {
+		if i < len(fields) {
+			if isbn := cleanISBN(fields[i]); isbn != "" {
+				rec.ISBN = isbn
+				break
+			}
+		}
+	}
+	if rating, err := strconv.ParseFloat(g.first(fields, "rating"), 64); err == nil && rating > 0 && rating <= 5 {
+		rec.Rating = rating
+	}
+	if s := g.first(fields, "date_read"); s != "" {
+		for _, layout := range dateReadLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				rec.DateRead = &t
+				break
+			}
+		}
+	}
+	for _, i := range g.columns["tags"] {
+		if i < len(fields) {
+			rec.Tags = appendTags(rec.Tags, fields[i])
+		}
+	}
+	return rec, nil
+}
+
+// first returns the first non-empty column of field.
+func (g *GoodreadsReader) first(fields []string, field string) string {
+	for _, i := range g.columns[field] {
+		if i < len(fields) {
+			if s := strings.TrimSpace(fields[i]); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func positiveInt(s string) *int {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return &n
+	}
+	return nil
+}
+
+// CountRows returns the number of rows of a CSV file, not counting the
+// header.
+func CountRows(r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	n := 0
+	for {
+		_, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return 0, err
+		}
+		n++
+	}
+	if n > 0 {
+		n--
+	}
+	return n, nil
+}
+
+// cleanISBN extracts an ISBN from a column and returns it as ISBN-13.
+// Goodreads writes ISBNs as ="0345391802" to keep spreadsheets from
+// mangling them; anything that is not a valid ISBN, such as StoryGraph's
+// own IDs, gives "".
+func cleanISBN(s string) string {
+	isbn, err := domain.ParseISBN(strings.Trim(strings.TrimSpace(s), `="`))
+	if err != nil {
+		return ""
+	}
+	return isbn
+}
+
+var breakTag = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+// cleanReview turns the HTML line breaks and entities of Goodreads reviews
+// into plain text.
+func cleanReview(s string) string {
+	return strings.TrimSpace(html.UnescapeString(breakTag.ReplaceAllString(s, "\n")))
+}
+
+// appendTags adds the comma-separated shelves of a column to tags, leaving
+// out duplicates.
+func appendTags(tags []string, column string) []string {
+	for _, tag := range strings.Split(column, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || containsFold(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchKey normalizes a title and author for spotting the same book under
+// slightly different spellings: case, punctuation and spacing are ignored.
+func MatchKey(title, author string) string {
+	normalize := func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}), " ")
+	}
+	return normalize(title) + "\x00" + normalize(author)
+}
@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGoodreadsReaderSkipsBrokenRows(t *testing.T) {
+	export := strings.Join([]string{
+		`Title,Author,My Rating,My Review`,
+		`Dune,Frank Herbert,5,"Spice,<br/>worms"`,
+		`The "Hobbit",J.R.R. Tolkien,4,`,
+		`Solaris,Stanisław Lem,4`,
+		`Hyperion,Dan Simmons,3,"Two`,
+		`lines"`,
+		`Ubik,Philip K. Dick,4,,extra`,
+		`Neuromancer,William Gibson,"4,`,
+		`Foundation,Isaac Asimov,5,`,
+	}, "\n")
+
+	want := []struct {
+		title string
+		line  int
+		err   error
+	}{
+		{"Dune", 2, nil},
+		{"", 3, csv.ErrBareQuote},
+		{"", 4, csv.ErrFieldCount},
+		{"Hyperion", 5, nil},
+		{"", 7, csv.ErrFieldCount},
+		// The unterminated quote swallows the rest of the file
+		{"", 8, csv.ErrQuote},
+	}
+
+	reader, err := NewGoodreadsReader(strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want {
+		rec, err := reader.Next()
+		if err != nil {
+			t.Fatalf("row %d: %v", i+1, err)
+		}
+		if rec.Row != i+1 || rec.Line != w.line || rec.Title != w.title || !errors.Is(rec.Err, w.err) {
+			t.Errorf("row %d: got row %d on line %d titled %q with error %v, want line %d titled %q with error %v",
+				i+1, rec.Row, rec.Line, rec.Title, rec.Err, w.line, w.title, w.err)
+		}
+	}
+	if rec, err := reader.Next(); err != io.EOF {
+		t.Errorf("got %+v, %v after the last row, want io.EOF", rec, err)
+	}
+
+	rows, err := CountRows(strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != len(want) {
+		t.Errorf("CountRows = %d, want %d", rows, len(want))
+	}
+}
+
+func TestGoodreadsReaderFields(t *testing.T) {
+	export := "\ufeffTitle,Author,ISBN13,My Rating,Bookshelves,Exclusive Shelf,Date Read,My Review\n" +
+		`Dune,Frank Herbert,"=""9780441172719""",4,"sci-fi, classics",read,2024/03/01,"Spice,<br/>worms &amp; sand"` + "\n"
+
+	reader, err := NewGoodreadsReader(strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Err != nil || rec.Title != "Dune" || rec.Author != "Frank Herbert" || rec.ISBN != "9780441172719" || rec.Rating != 4 {
+		t.Errorf("got %+v", rec)
+	}
+	if strings.Join(rec.Tags, "|") != "sci-fi|classics|read" {
+		t.Errorf("tags = %q", rec.Tags)
+	}
+	if rec.DateRead == nil || rec.DateRead.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("date read = %v", rec.DateRead)
+	}
+	if rec.Review != "Spice,\nworms & sand" {
+		t.Errorf("review = %q", rec.Review)
+	}
+}
+
+func TestNewGoodreadsReaderRejectsOtherFiles(t *testing.T) {
+	for _, export := range []string{"", "Name,Author\nDune,Frank Herbert\n", "\"Title,Author\n"} {
+		if _, err := NewGoodreadsReader(strings.NewReader(export)); err == nil {
+			t.Errorf("%q: got no error", export)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clipping kinds
+const (
+	ClippingHighlight = "highlight"
+	ClippingNote      = "note"
+	ClippingBookmark  = "bookmark"
+)
+
+// kindleSeparator ends every entry of a My Clippings.txt file.
+const kindleSeparator = "=========="
+
+// Clipping is one entry of a Kindle My Clippings.txt file.
+type Clipping struct {
+	// Entry is the 1-based position of the entry in the file
+	Entry    int
+	Title    string
+	Author   string
+	Kind     string
+	Page     *int
+	Location string
+	AddedAt  *time.Time
+	Text     string
+	// Err is set when the entry could not be read
+	Err error
+}
+
+var (
+	clippingKind     = regexp.MustCompile(`(?i)your (highlight|note|bookmark|clip)`)
+	clippingPage     = regexp.MustCompile(`(?i)\bpage (\d+)`)
+	clippingLocation = regexp.MustCompile(`(?i)\b(?:location|loc\.) ([\d-]+)`)
+	clippingAdded    = regexp.MustCompile(`(?i)added on (.+)$`)
+)
+
+var clippingTimeLayouts = []string{
+	"Monday, January 2, 2006 3:04:05 PM",
+	"Monday, 2 January 2006 15:04:05",
+	"Monday, January 2, 2006, 03:04 PM",
+	"Monday, January 2, 2006 3:04 PM",
+}
+
+// KindleReader reads the entries of a My Clippings.txt file one by one.
+type KindleReader struct {
+	scanner *bufio.Scanner
+	entry   int
+}
+
+func NewKindleReader(r io.Reader) *KindleReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &KindleReader{scanner: scanner}
+}
+
+// Next returns the next entry, or io.EOF after the last one. An entry
+// that does not look like a clipping comes back with Err set.
+func (k *KindleReader) Next() (*Clipping, error) {
+	var lines []string
+	for k.scanner.Scan() {
+		line := strings.TrimRight(k.scanner.Text(), "\r")
+		line = strings.TrimPrefix(line, "\ufeff")
+		if strings.TrimSpace(line) == kindleSeparator {
+			if len(lines) == 0 {
+				continue
+			}
+			k.entry++
+			return parseClipping(k.entry, lines), nil
+		}
+		lines = append(lines, line)
+	}
+	if err := k.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The last entry may lack its separator
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 {
+		k.entry++
+		return parseClipping(k.entry, lines), nil
+	}
+	return nil, io.EOF
+}
+
+// parseClipping reads an entry: the title line, the metadata line, a
+// blank line and the clipped text.
+func parseClipping(entry int, lines []string) *Clipping {
+	c := &Clipping{Entry: entry}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) < 2 {
+		c.Err = errors.New("entry is incomplete")
+		return c
+	}
+
+	c.Title, c.Author = splitClippingTitle(strings.TrimSpace(lines[0]))
+
+	meta := strings.TrimSpace(lines[1])
+	m := clippingKind.FindStringSubmatch(meta)
+	if m == nil {
+		c.Err = errors.New("unrecognized entry type")
+		return c
+	}
+	switch strings.ToLower(m[1]) {
+	case "note":
+		c.Kind = ClippingNote
+	case "bookmark":
+		c.Kind = ClippingBookmark
+	default:
+		c.Kind = ClippingHighlight
+	}
+	if m := clippingPage.FindStringSubmatch(meta); m != nil {
+		if page, err := strconv.Atoi(m[1]); err == nil && page > 0 {
+			c.Page = &page
+		}
+	}
+	if m := clippingLocation.FindStringSubmatch(meta); m != nil {
+		c.Location = m[1]
+	}
+	if m := clippingAdded.FindStringSubmatch(meta); m != nil {
+		for _, layout := range clippingTimeLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(m[1])); err == nil {
+				c.AddedAt = &t
+				break
+			}
+		}
+	}
+
+	c.Text = strings.TrimSpace(strings.Join(lines[2:], "\n"))
+	return c
+}
+
+// splitClippingTitle splits "Title (Author)" into its parts. Authors given
+// as "Last, First" are turned around.
+func splitClippingTitle(line string) (title, author string) {
+	if !strings.HasSuffix(line, ")") {
+		return line, ""
+	}
+	open := strings.LastIndex(line, "(")
+	if open <= 0 {
+		return line, ""
+	}
+
+	title = strings.TrimSpace(line[:open])
+	author = strings.TrimSpace(line[open+1 : len(line)-1])
+	if last, first, ok := strings.Cut(author, ", "); ok && !strings.ContainsAny(first, ",;") {
+		author = first + " " + last
+	}
+	return title, author
+}
+
+// CountClippings returns the number of entries in a My Clippings.txt file.
+func CountClippings(r io.Reader) (int, error) {
+	reader := NewKindleReader(r)
+	n := 0
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
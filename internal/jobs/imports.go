@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// importRetention is how long finished imports can still be polled.
+const importRetention = 24 * time.Hour
+
+// Imports runs imports in the background and keeps track of their
+// progress. Jobs live in process memory, so a restart forgets them.
+type Imports struct {
+	mu   sync.Mutex
+	jobs map[string]*domain.ImportJob
+}
+
+func NewImports() *Imports {
+	return &Imports{jobs: make(map[string]*domain.ImportJob)}
+}
+
+// Start runs fn in the background as a new import job of userID and
+// returns a snapshot of the job. fn reports its progress through the
+// ImportProgress it is given; an error fails the whole job.
+func (i *Imports) Start(userID, source string, fn func(ctx context.Context, p *ImportProgress) error) *domain.ImportJob {
+	job := &domain.ImportJob{
+		ID:        newJobID(),
+		UserID:    userID,
+		Source:    source,
+		Status:    domain.ImportRunning,
+		Skipped:   []domain.SkippedRow{},
+		Warnings:  []domain.SkippedRow{},
+		CreatedAt: time.Now().UTC(),
+	}
+
+	i.mu.Lock()
+	i.pruneLocked()
+	i.jobs[job.ID] = job
+	snapshot := copyImportJob(job)
+	i.mu.Unlock()
+
+	go func() {
+		err := fn(context.Background(), &ImportProgress{imports: i, job: job})
+
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		finished := time.Now().UTC()
+		job.FinishedAt = &finished
+		job.Status = domain.ImportDone
+		if err != nil {
+			log.Printf("Import %s failed: %v", job.ID, err)
+			job.Status = domain.ImportFailed
+			job.Error = err.Error()
+		}
+	}()
+
+	return snapshot
+}
+
+// Get returns a snapshot of the user's import job with the given ID.
+func (i *Imports) Get(id, userID string) (*domain.ImportJob, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	job, ok := i.jobs[id]
+	if !ok || job.UserID != userID {
+		return nil, false
+	}
+	return copyImportJob(job), true
+}
+
+func (i *Imports) pruneLocked() {
+	cutoff := time.Now().Add(-importRetention)
+	for id, job := range i.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(i.jobs, id)
+		}
+	}
+}
+
+// ImportProgress updates the counters of a running import.
+type ImportProgress struct {
+	imports *Imports
+	job     *domain.ImportJob
+}
+
+func (p *ImportProgress) SetTotal(total int) {
+	p.imports.mu.Lock()
+	defer p.imports.mu.Unlock()
+	p.job.Total = total
+}
+
+// Imported counts a row as imported.
+func (p *ImportProgress) Imported() {
+	p.imports.mu.Lock()
+	defer p.imports.mu.Unlock()
+	p.job.Processed++
+	p.job.Imported++
+}
+
+// Skip counts a row as skipped for the given reason.
+func (p *ImportProgress) Skip(row int, title, reason string) {
+	p.imports.mu.Lock()
+	defer p.imports.mu.Unlock()
+	p.job.Processed++
+	p.job.Skipped = append(p.job.Skipped, domain.SkippedRow{Row: row, Title: title, Reason: reason})
+}
+
+// Warn notes what was left out of a row imported anyway.
+func (p *ImportProgress) Warn(row int, title, reason string) {
+	p.imports.mu.Lock()
+	defer p.imports.mu.Unlock()
+	p.job.Warnings = append(p.job.Warnings, domain.SkippedRow{Row: row, Title: title, Reason: reason})
+}
+
+func copyImportJob(job *domain.ImportJob) *domain.ImportJob {
+	c := *job
+	c.Skipped = append([]domain.SkippedRow{}, job.Skipped...)
+	c.Warnings = append([]domain.SkippedRow{}, job.Warnings...)
+	return &c
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+// Package jobs holds the background work the service runs next to the API.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/blob"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// PurgeTrash permanently deletes books that have been in the trash for
+// longer than retention, with their files, checking every interval until
+// ctx is done.
+func PurgeTrash(ctx context.Context, trash repositories.TrashRepository, blobs blob.BlobStore, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := trash.PurgeTrashedBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+		} else if len(purged) > 0 {
+			for _, id := range purged {
+				DeleteBookFiles(ctx, blobs, id)
+			}
+			log.Printf("Purged %d books from the trash", len(purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeleteBookFiles removes the cover and attachments of a purged book.
+// Failures only leave unused files behind, so they are logged.
+func DeleteBookFiles(ctx context.Context, blobs blob.BlobStore, bookID string) {
+	for _, prefix := range domain.BookBlobPrefixes(bookID) {
+		if err := blobs.DeletePrefix(ctx, prefix); err != nil {
+			log.Printf("Failed to delete the files of book %s: %v", bookID, err)
+		}
+	}
+}
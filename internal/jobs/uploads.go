@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/blob"
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// PurgeUploads drops attachment uploads that expired unfinished, with the
+// chunks received so far, checking every interval until ctx is done.
+func PurgeUploads(ctx context.Context, attachments repositories.AttachmentRepository, blobs blob.BlobStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		uploads, err := attachments.ExpiredUploads(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to find expired uploads: %v", err)
+		}
+		purged := 0
+		for _, upload := range uploads {
+			if err := attachments.DeleteUpload(ctx, upload.ID, upload.UserID); err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				log.Printf("Failed to delete upload %s: %v", upload.ID, err)
+				continue
+			}
+			if err := blobs.DeletePrefix(ctx, domain.UploadPrefix(upload.BookID, upload.ID)); err != nil {
+				log.Printf("Failed to delete the chunks of upload %s: %v", upload.ID, err)
+			}
+			purged++
+		}
+		if purged > 0 {
+			log.Printf("Purged %d expired uploads", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// Dataset answers lookups from a local file, so the service can enrich
+// books without network access. The file holds one JSON object per line
+// in the shape of domain.BookMetadata:
+//
+//	{"isbn": "0-441-17271-7", "title": "Dune", "authors": ["Frank Herbert"], "publisher": "Ace", "year": 1965}
+//
+// ISBNs may be given in either form; blank lines and lines starting with #
+// are ignored.
+type Dataset struct {
+	books map[string]*domain.BookMetadata
+}
+
+func LoadDataset(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &Dataset{books: make(map[string]*domain.BookMetadata)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var meta domain.BookMetadata
+		if err := json.Unmarshal([]byte(text), &meta); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		isbn, err := domain.ParseISBN(meta.ISBN)
+		if err != nil || isbn == "" {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, domain.ErrInvalidISBN)
+		}
+		meta.ISBN = isbn
+		meta.Source = "dataset"
+		d.books[isbn] = &meta
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Len returns the number of books in the dataset.
+func (d *Dataset) Len() int {
+	return len(d.books)
+}
+
+func (d *Dataset) Lookup(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	meta, ok := d.books[isbn]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *meta
+	copied.Authors = append([]string(nil), meta.Authors...)
+	copied.Year = copyInt(meta.Year)
+	copied.Pages = copyInt(meta.Pages)
+	return &copied, nil
+}
+
+func copyInt(n *int) *int {
+	if n == nil {
+		return nil
+	}
+	v := *n
+	return &v
+}
@@ -0,0 +1,72 @@
+// Package metadata looks up bibliographic details of books by ISBN.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/repositories"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// ErrNotFound is returned when a provider does not know an ISBN.
+var ErrNotFound = errors.New("no metadata found for this ISBN")
+
+// MetadataProvider fills in the details of a book from its ISBN. Lookup
+// takes a bare ISBN-13, as returned by domain.ParseISBN, and returns
+// ErrNotFound for ISBNs the provider does not know.
+type MetadataProvider interface {
+	Lookup(ctx context.Context, isbn string) (*domain.BookMetadata, error)
+}
+
+// Chain asks each provider in turn and returns the first answer. It only
+// fails with a provider error when no later provider knows the ISBN.
+type Chain []MetadataProvider
+
+func (c Chain) Lookup(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	err := ErrNotFound
+	for _, p := range c {
+		meta, lookupErr := p.Lookup(ctx, isbn)
+		if lookupErr == nil {
+			return meta, nil
+		}
+		if !errors.Is(lookupErr, ErrNotFound) {
+			err = lookupErr
+		}
+	}
+	return nil, err
+}
+
+// Cached keeps the answers of a provider in a MetadataRepository for ttl.
+// Misses are not cached, so books added to a dataset or an upstream
+// catalogue show up right away.
+type Cached struct {
+	provider MetadataProvider
+	cache    repositories.MetadataRepository
+	ttl      time.Duration
+}
+
+func NewCached(provider MetadataProvider, cache repositories.MetadataRepository, ttl time.Duration) *Cached {
+	return &Cached{provider: provider, cache: cache, ttl: ttl}
+}
+
+func (c *Cached) Lookup(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	meta, err := c.cache.GetMetadata(ctx, isbn)
+	if err == nil {
+		return meta, nil
+	}
+	if !errors.Is(err, repositories.ErrNotFound) {
+		log.Printf("Failed to read cached metadata of %s: %v", isbn, err)
+	}
+
+	meta, err = c.provider.Lookup(ctx, isbn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cache.PutMetadata(ctx, meta, time.Now().Add(c.ttl)); err != nil {
+		log.Printf("Failed to cache metadata of %s: %v", isbn, err)
+	}
+	return meta, nil
+}
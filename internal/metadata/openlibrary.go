@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// OpenLibrary looks ISBNs up with the Open Library Books API,
+// GET /api/books?bibkeys=ISBN:...&format=json&jscmd=data, at baseURL.
+// Anything that speaks the same API, such as a local stub, will do.
+type OpenLibrary struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewOpenLibrary(baseURL string) *OpenLibrary {
+	return &OpenLibrary{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// openLibraryBook is the part of a jscmd=data record the service uses.
+type openLibraryBook struct {
+	Title         string `json:"title"`
+	Subtitle      string `json:"subtitle"`
+	NumberOfPages int    `json:"number_of_pages"`
+	PublishDate   string `json:"publish_date"`
+	Authors       []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	Cover struct {
+		Small  string `json:"small"`
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+}
+
+func (o *OpenLibrary) Lookup(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	key := "ISBN:" + isbn
+	query := url.Values{"bibkeys": {key}, "format": {"json"}, "jscmd": {"data"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("open library: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("open library: unexpected status %s", resp.Status)
+	}
+
+	var records map[string]openLibraryBook
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("open library: %w", err)
+	}
+	record, ok := records[key]
+	if !ok || record.Title == "" {
+		return nil, ErrNotFound
+	}
+
+	meta := &domain.BookMetadata{
+		ISBN:     isbn,
+		Title:    record.Title,
+		Authors:  []string{},
+		Year:     publishYear(record.PublishDate),
+		CoverURL: firstNonEmpty(record.Cover.Large, record.Cover.Medium, record.Cover.Small),
+		Source:   "openlibrary",
+	}
+	if record.Subtitle != "" {
+		meta.Title += ": " + record.Subtitle
+	}
+	for _, a := range record.Authors {
+		if a.Name != "" {
+			meta.Authors = append(meta.Authors, a.Name)
+		}
+	}
+	if len(record.Publishers) > 0 {
+		meta.Publisher = record.Publishers[0].Name
+	}
+	if record.NumberOfPages > 0 {
+		pages := record.NumberOfPages
+		meta.Pages = &pages
+	}
+	return meta, nil
+}
+
+var yearPattern = regexp.MustCompile(`\b\d{4}\b`)
+
+// publishYear finds the year in free-form dates such as "June 1, 1990" or
+// "1965".
+func publishYear(date string) *int {
+	year, err := strconv.Atoi(yearPattern.FindString(date))
+	if err != nil || year < 1 {
+		return nil
+	}
+	return &year
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -4,13 +4,16 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/smartnotes/user-service/internal/repositories"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware validates the bearer access token and rejects tokens whose
+// session has been logged out, revoked or has expired.
+func AuthMiddleware(sessions repositories.SessionRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -41,14 +44,29 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			userID, err := primitive.ObjectIDFromHex(claims["id"].(string))
-			if err != nil {
+			userID, _ := claims["id"].(string)
+			if userID == "" {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
 				c.Abort()
 				return
 			}
 
+			sessionID, _ := claims["sid"].(string)
+			if sessionID == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+				c.Abort()
+				return
+			}
+
+			session, err := sessions.FindSessionByID(c.Request.Context(), sessionID)
+			if err != nil || session.UserID != userID || !session.Active(time.Now()) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+				c.Abort()
+				return
+			}
+
 			c.Set("userID", userID)
+			c.Set("sessionID", sessionID)
 			c.Set("userRole", claims["role"])
 			c.Next()
 		} else {
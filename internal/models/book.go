@@ -1,18 +1,14 @@
 package models
 
-import (
-	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
-)
+import "time"
 
 type Book struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Title       string             `bson:"title" json:"title"`
-	Author      string             `bson:"author" json:"author"`
-	Description string             `bson:"description" json:"description"`
-	Tags        []string           `bson:"tags" json:"tags"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	ID          string    `bson:"-" json:"id"`
+	UserID      string    `bson:"-" json:"user_id"`
+	Title       string    `bson:"title" json:"title"`
+	Author      string    `bson:"author" json:"author"`
+	Description string    `bson:"description" json:"description"`
+	Tags        []string  `bson:"tags" json:"tags"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
 }
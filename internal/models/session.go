@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Session is a single login. It holds the hash of the refresh token that is
+// currently valid for it and the hashes of the ones it has been rotated away
+// from, so that a replayed refresh token can be detected.
+type Session struct {
+	ID             string     `bson:"-" json:"id"`
+	UserID         string     `bson:"-" json:"user_id"`
+	TokenHash      string     `bson:"token_hash" json:"-"`
+	PreviousHashes []string   `bson:"previous_hashes" json:"-"`
+	CreatedAt      time.Time  `bson:"created_at" json:"created_at"`
+	LastUsedAt     time.Time  `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt      time.Time  `bson:"expires_at" json:"expires_at"`
+	RevokedAt      *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// CapExpiry returns expiresAt, or the moment the session reaches maxAge if
+// that comes first.
+func (s *Session) CapExpiry(expiresAt time.Time, maxAge time.Duration) time.Time {
+	if limit := s.CreatedAt.Add(maxAge); limit.Before(expiresAt) {
+		return limit
+	}
+	return expiresAt
+}
@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// AttachmentRepository keeps the records of attachment files and of the
+// resumable uploads that produce them. The files themselves are in a
+// blob store.
+type AttachmentRepository interface {
+	// CreateAttachment and CreateUpload fail with ErrQuotaExceeded, storing
+	// nothing, when the user's attachments and uploads would then take more
+	// than quota bytes. The check and the insert are atomic, so concurrent
+	// uploads cannot pass the quota together.
+	CreateAttachment(ctx context.Context, attachment *domain.Attachment, quota int64) error
+	ListAttachments(ctx context.Context, bookID, userID string) ([]*domain.Attachment, error)
+	GetAttachment(ctx context.Context, id, bookID, userID string) (*domain.Attachment, error)
+	DeleteAttachment(ctx context.Context, id, bookID, userID string) error
+
+	CreateUpload(ctx context.Context, upload *domain.Upload, quota int64) error
+	GetUpload(ctx context.Context, id, bookID, userID string) (*domain.Upload, error)
+	// AdvanceUpload records a chunk stored under part that takes the
+	// upload from offset from to offset to. It fails with
+	// ErrVersionMismatch when the upload is no longer at from, as when
+	// two chunks race for the same offset.
+	AdvanceUpload(ctx context.Context, id, userID string, from, to int64, part string) (*domain.Upload, error)
+	DeleteUpload(ctx context.Context, id, userID string) error
+	// ExpiredUploads returns the uploads of every user that expired
+	// before cutoff.
+	ExpiredUploads(ctx context.Context, cutoff time.Time) ([]*domain.Upload, error)
+
+	// StorageUsed sums the sizes of a user's attachments and unfinished
+	// uploads, in bytes.
+	StorageUsed(ctx context.Context, userID string) (int64, error)
+}
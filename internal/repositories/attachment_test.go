@@ -0,0 +1,70 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/models"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+func TestConcurrentUploadsStayWithinQuota(t *testing.T) {
+	sqlite, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "quota.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, repo := range map[string]Repository{
+		"memory": NewMemoryRepository(),
+		"sqlite": sqlite,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := &models.User{Username: "alice", Email: "alice@example.com", Role: "user"}
+			if err := repo.CreateUser(user); err != nil {
+				t.Fatal(err)
+			}
+			book := &domain.Book{UserID: user.ID, Title: "Dune"}
+			if err := repo.Create(ctx, book); err != nil {
+				t.Fatal(err)
+			}
+
+			// Ten uploads of 40 bytes race for a quota of 100
+			const size, quota = 40, 100
+			var wg sync.WaitGroup
+			errs := make([]error, 10)
+			for i := range errs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if i%2 == 0 {
+						errs[i] = repo.CreateUpload(ctx, &domain.Upload{BookID: book.ID, UserID: user.ID, Size: size, ExpiresAt: time.Now().Add(time.Hour)}, quota)
+					} else {
+						errs[i] = repo.CreateAttachment(ctx, &domain.Attachment{BookID: book.ID, UserID: user.ID, Size: size, Key: "key"}, quota)
+					}
+				}()
+			}
+			wg.Wait()
+
+			stored := 0
+			for _, err := range errs {
+				switch {
+				case err == nil:
+					stored++
+				case !errors.Is(err, ErrQuotaExceeded):
+					t.Fatal(err)
+				}
+			}
+			used, err := repo.StorageUsed(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != quota/size || used != int64(stored*size) {
+				t.Errorf("stored %d files taking %d bytes, want %d within the quota of %d", stored, used, quota/size, quota)
+			}
+		})
+	}
+}
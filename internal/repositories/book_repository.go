@@ -4,15 +4,32 @@ import (
 	"context"
 
 	"github.com/smartnotes/user-service/pkg/domain"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type BookRepository interface {
 	Create(ctx context.Context, book *domain.Book) error
-	Update(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, update *domain.Book) error
-	Delete(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) error
-	GetByID(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) (*domain.Book, error)
-	List(ctx context.Context, userID primitive.ObjectID, search, sortBy, order string, limit, offset int) ([]*domain.Book, error)
-	Count(ctx context.Context, userID primitive.ObjectID, search string) (int64, error)
+	// Update sets the fields present in update, bumps the version and
+	// returns the updated book, or ErrNotFound when the user has no such
+	// book. With a non-nil version it only applies to that version and
+	// fails with ErrVersionMismatch otherwise; Delete, which moves the book
+	// to the trash, works the same way.
+	Update(ctx context.Context, id string, userID string, version *int64, update *domain.UpdateBookRequest) (*domain.Book, error)
+	Delete(ctx context.Context, id string, userID string, version *int64) error
+	// SetCover replaces the book's cover, or removes it when cover is nil,
+	// and bumps the version like Update.
+	SetCover(ctx context.Context, id string, userID string, version *int64, cover *domain.Cover) (*domain.Book, error)
+	// WithTransaction runs fn against a repository whose book writes all
+	// take effect if fn returns nil and none of them otherwise.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context, books BookRepository) error) error
+	GetByID(ctx context.Context, id string, userID string) (*domain.Book, error)
+	List(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.Book, error)
+	Count(ctx context.Context, userID string, query *domain.ListBooksQuery) (int64, error)
+	Facets(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.BookFacets, error)
+	// RatingStats summarizes the ratings of the books matching the filters
+	// of query.
+	RatingStats(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.RatingStats, error)
+	// Search runs the full-text query.Query over the user's books and their
+	// notes, restricted by the other filters of query, and returns one page
+	// of relevance-ranked results plus the total.
+	Search(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.BookSearchResult, int64, error)
 }
@@ -0,0 +1,15 @@
+package repositories
+
+import "errors"
+
+// ErrNotFound is returned when a document does not exist or is not owned by
+// the requesting user.
+var ErrNotFound = errors.New("not found")
+
+// ErrVersionMismatch is returned by conditional writes when the document
+// exists but has moved on from the expected version.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrQuotaExceeded is returned when storing a file would take the user's
+// attachments and uploads past their storage quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"sort"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// maxFacetValues caps how many values each facet reports.
+const maxFacetValues = 50
+
+// countFacets tallies tags and authors over books in process, ordered like
+// the database backends: most frequent first, then by value.
+func countFacets(books []*domain.Book) *domain.BookFacets {
+	tags := map[string]int64{}
+	authors := map[string]int64{}
+	for _, b := range books {
+		for _, tag := range b.Tags {
+			if tag != "" {
+				tags[tag]++
+			}
+		}
+		if b.Author != "" {
+			authors[b.Author]++
+		}
+	}
+	return &domain.BookFacets{Tags: topFacets(tags, maxFacetValues), Authors: topFacets(authors, maxFacetValues)}
+}
+
+// topFacets returns the limit most frequent values of counts.
+func topFacets(counts map[string]int64, limit int) []domain.FacetCount {
+	facets := make([]domain.FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, domain.FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	if len(facets) > limit {
+		facets = facets[:limit]
+	}
+	return facets
+}
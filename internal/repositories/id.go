@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newID returns a fresh ID for any backend. IDs use the ObjectID hex format
+// everywhere so they are time-ordered and valid across storage backends.
+func newID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// optionalInt stores n in a field where 0 means unset.
+func optionalInt(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
+
+func optionalFloat(f float64) *float64 {
+	if f == 0 {
+		return nil
+	}
+	return &f
+}
+
+// optionalTime stores t in a field where the zero time means unset, at the
+// precision of now.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC().Truncate(time.Millisecond)
+	return &t
+}
+
+// now returns the current time at the millisecond precision Mongo stores,
+// so timestamps look the same whatever the backend. Tests set it to move
+// the clock.
+var now = func() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
@@ -0,0 +1,1348 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/models"
+	"github.com/smartnotes/user-service/internal/search"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+var _ Repository = (*MemoryRepository)(nil)
+
+// MemoryRepository is a Repository that keeps everything in process memory.
+// It mirrors the query semantics of MongoDBRepository so the API behaves the
+// same without a database; it is meant for tests and local development.
+type MemoryRepository struct {
+	mu          sync.RWMutex
+	users       []*models.User
+	books       []*domain.Book
+	notes       []*domain.Note
+	sessions    []*models.Session
+	revisions   []*domain.Revision
+	metadata    map[string]cachedMetadata
+	attachments []*domain.Attachment
+	uploads     []*domain.Upload
+	// readingSessions are kept in the order they were logged
+	readingSessions []*domain.ReadingSession
+	// shelves are kept in the order they were created
+	shelves []*domain.Shelf
+}
+
+type cachedMetadata struct {
+	meta      domain.BookMetadata
+	expiresAt time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{metadata: make(map[string]cachedMetadata)}
+}
+
+// User methods
+func (r *MemoryRepository) CreateUser(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == user.Email || u.Username == user.Username {
+			return errors.New("user already exists")
+		}
+	}
+
+	user.ID = newID()
+	user.CreatedAt = now()
+	user.Role = "user"
+
+	stored := *user
+	r.users = append(r.users, &stored)
+	return nil
+}
+
+func (r *MemoryRepository) FindUserByEmail(email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) FindUserByID(id string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.ID == id {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Book methods
+func (r *MemoryRepository) Create(ctx context.Context, book *domain.Book) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book.ID = newID()
+	// Imports keep the date a book was added
+	book.UpdatedAt = now()
+	if book.CreatedAt.IsZero() {
+		book.CreatedAt = book.UpdatedAt
+	}
+	book.Version = 1
+
+	r.books = append(r.books, copyBook(book))
+	return nil
+}
+
+func (r *MemoryRepository) Update(ctx context.Context, id string, userID string, version *int64, update *domain.UpdateBookRequest) (*domain.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.books {
+		if b.ID != id || b.UserID != userID || b.DeletedAt != nil {
+			continue
+		}
+		if version != nil && b.Version != *version {
+			return nil, ErrVersionMismatch
+		}
+		if update.Title != nil {
+			b.Title = *update.Title
+		}
+		if update.Author != nil {
+			b.Author = *update.Author
+		}
+		if update.Description != nil {
+			b.Description = *update.Description
+		}
+		if update.ISBN != nil {
+			b.ISBN = *update.ISBN
+		}
+		if update.Publisher != nil {
+			b.Publisher = *update.Publisher
+		}
+		if update.Year != nil {
+			b.Year = optionalInt(*update.Year)
+		}
+		if update.Edition != nil {
+			b.Edition = *update.Edition
+		}
+		if update.Pages != nil {
+			b.Pages = optionalInt(*update.Pages)
+		}
+		if update.CoverURL != nil {
+			b.CoverURL = *update.CoverURL
+		}
+		if update.Tags != nil {
+			b.Tags = append([]string(nil), (*update.Tags)...)
+		}
+		if update.Status != nil {
+			b.Status = *update.Status
+		}
+		if update.CurrentPage != nil {
+			b.CurrentPage = optionalInt(*update.CurrentPage)
+		}
+		if update.Progress != nil {
+			b.Progress = optionalFloat(*update.Progress)
+		}
+		if update.StartedAt != nil {
+			b.StartedAt = optionalTime(*update.StartedAt)
+		}
+		if update.FinishedAt != nil {
+			b.FinishedAt = optionalTime(*update.FinishedAt)
+		}
+		if update.Rating != nil {
+			b.Rating = optionalFloat(*update.Rating)
+		}
+		if update.Review != nil {
+			b.Review = *update.Review
+		}
+		b.UpdatedAt = now()
+		b.Version++
+		return copyBook(b), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, id string, userID string, version *int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.books {
+		if b.ID != id || b.UserID != userID || b.DeletedAt != nil {
+			continue
+		}
+		if version != nil && b.Version != *version {
+			return ErrVersionMismatch
+		}
+		ts := now()
+		b.DeletedAt = &ts
+		b.Version++
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryRepository) SetCover(ctx context.Context, id string, userID string, version *int64, cover *domain.Cover) (*domain.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.books {
+		if b.ID != id || b.UserID != userID || b.DeletedAt != nil {
+			continue
+		}
+		if version != nil && b.Version != *version {
+			return nil, ErrVersionMismatch
+		}
+		b.Cover = nil
+		if cover != nil {
+			stored := *cover
+			b.Cover = &stored
+		}
+		b.UpdatedAt = now()
+		b.Version++
+		return copyBook(b), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context, books BookRepository) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Stage the writes on a copy of the books and keep them only when fn
+	// succeeds
+	staged := &MemoryRepository{books: make([]*domain.Book, len(r.books)), notes: r.notes}
+	for i, b := range r.books {
+		staged.books[i] = copyBook(b)
+	}
+	if err := fn(ctx, staged); err != nil {
+		return err
+	}
+	r.books = staged.books
+	return nil
+}
+
+func (r *MemoryRepository) GetByID(ctx context.Context, id string, userID string) (*domain.Book, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, b := range r.books {
+		if b.ID == id && b.UserID == userID && b.DeletedAt == nil {
+			return copyBook(b), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) List(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.Book, error) {
+	limit, offset := query.Limit, query.Offset
+	if offset < 0 {
+		return nil, errors.New("skip must be non-negative")
+	}
+
+	r.mu.RLock()
+	books, err := r.matchBooks(userID, query)
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(books, func(i, j int) bool {
+		return compareSortKeys(query.SortKeys, bookSortValues(books[i], query.SortKeys), books[i].ID,
+			bookSortValues(books[j], query.SortKeys), books[j].ID) < 0
+	})
+
+	if cursor := query.Cursor; cursor != nil {
+		start := sort.Search(len(books), func(i int) bool {
+			return compareSortKeys(query.SortKeys, bookSortValues(books[i], query.SortKeys), books[i].ID,
+				cursor.Values, cursor.ID) > 0
+		})
+		books = books[start:]
+	}
+
+	if offset >= len(books) {
+		return nil, nil
+	}
+	books = books[offset:]
+
+	// A negative limit behaves like its absolute value, 0 means no limit
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < len(books) {
+		books = books[:limit]
+	}
+
+	return books, nil
+}
+
+func (r *MemoryRepository) Count(ctx context.Context, userID string, query *domain.ListBooksQuery) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	books, err := r.matchBooks(userID, query)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(books)), nil
+}
+
+func (r *MemoryRepository) Facets(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.BookFacets, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	books, err := r.matchBooks(userID, query)
+	if err != nil {
+		return nil, err
+	}
+	return countFacets(books), nil
+}
+
+func (r *MemoryRepository) RatingStats(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.RatingStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	books, err := r.matchBooks(userID, query)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[float64]int64{}
+	for _, b := range books {
+		var rating float64
+		if b.Rating != nil {
+			rating = *b.Rating
+		}
+		counts[rating]++
+	}
+	return domain.NewRatingStats(counts), nil
+}
+
+func (r *MemoryRepository) Search(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.BookSearchResult, int64, error) {
+	q := search.Parse(query.Query)
+	if q.Empty() {
+		return []*domain.BookSearchResult{}, 0, nil
+	}
+
+	r.mu.RLock()
+	books, err := r.matchBooks(userID, query)
+	if err != nil {
+		r.mu.RUnlock()
+		return nil, 0, err
+	}
+	notesByBook := map[string][]*domain.Note{}
+	for _, n := range r.notes {
+		if n.UserID == userID {
+			notesByBook[n.BookID] = append(notesByBook[n.BookID], copyNote(n))
+		}
+	}
+	var results []*domain.BookSearchResult
+	for _, b := range books {
+		if result, ok := scoreBook(q, b, notesByBook[b.ID]); ok {
+			results = append(results, result)
+		}
+	}
+	r.mu.RUnlock()
+
+	page, total := rankResults(results, query.Limit, query.Offset)
+	return page, total, nil
+}
+
+// matchBooks returns copies of the user's books that pass the structured
+// filters of query; the full-text query is left to Search. Callers must
+// hold r.mu.
+func (r *MemoryRepository) matchBooks(userID string, query *domain.ListBooksQuery) ([]*domain.Book, error) {
+	var re *regexp.Regexp
+	if query.Search != "" {
+		var err error
+		if re, err = regexp.Compile("(?i)" + regexp.QuoteMeta(query.Search)); err != nil {
+			return nil, err
+		}
+	}
+
+	var books []*domain.Book
+	for _, b := range r.books {
+		if b.UserID != userID || b.DeletedAt != nil {
+			continue
+		}
+		if re != nil && !re.MatchString(b.Title) && !re.MatchString(b.Description) {
+			continue
+		}
+		if !matchesBookFilters(b, query) {
+			continue
+		}
+		book := copyBook(b)
+		if query.Shelf != nil {
+			position := slices.Index(query.Shelf.BookIDs, b.ID)
+			book.Position = &position
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+func matchesBookFilters(b *domain.Book, query *domain.ListBooksQuery) bool {
+	if len(query.Tags) > 0 {
+		matched := 0
+		for _, tag := range query.Tags {
+			if containsString(b.Tags, tag) {
+				matched++
+			}
+		}
+		if matched == 0 || (query.TagMode == domain.TagModeAll && matched < len(query.Tags)) {
+			return false
+		}
+	}
+	if query.Shelf != nil && !slices.Contains(query.Shelf.BookIDs, b.ID) {
+		return false
+	}
+	if query.Author != "" && !strings.EqualFold(b.Author, query.Author) {
+		return false
+	}
+	if len(query.Statuses) > 0 && !containsString(query.Statuses, b.Status) &&
+		!(b.Status == "" && containsString(query.Statuses, domain.StatusNone)) {
+		return false
+	}
+	if query.RatingMin != nil && (b.Rating == nil || *b.Rating < *query.RatingMin) {
+		return false
+	}
+	if query.RatingMax != nil && (b.Rating == nil || *b.Rating > *query.RatingMax) {
+		return false
+	}
+	if query.CreatedAfter != nil && b.CreatedAt.Before(query.CreatedAfter.Time) {
+		return false
+	}
+	if query.CreatedBefore != nil && !b.CreatedAt.Before(query.CreatedBefore.Time) {
+		return false
+	}
+	if query.UpdatedSince != nil && b.UpdatedAt.Before(query.UpdatedSince.Time) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func bookSortValues(b *domain.Book, keys []domain.SortKey) []any {
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i], _ = domain.BookSortValue(b, key.Field)
+	}
+	return values
+}
+
+// compareSortKeys orders two books, given as their sort values and IDs,
+// the way a listing sorted by keys does: negative when a comes first.
+func compareSortKeys(keys []domain.SortKey, a []any, aID string, b []any, bID string) int {
+	for i, key := range keys {
+		if c := compareSortValues(a[i], b[i]); c != 0 {
+			if key.Desc {
+				return -c
+			}
+			return c
+		}
+	}
+	c := strings.Compare(aID, bID)
+	if keys[len(keys)-1].Desc {
+		return -c
+	}
+	return c
+}
+
+// compareSortValues orders two values returned by domain.BookSortValue.
+func compareSortValues(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		b, _ := b.(string)
+		return strings.Compare(a, b)
+	case time.Time:
+		b, _ := b.(time.Time)
+		return a.Compare(b)
+	case float64:
+		b, _ := b.(float64)
+		return cmp.Compare(a, b)
+	case int:
+		b, _ := b.(int)
+		return cmp.Compare(a, b)
+	}
+	return 0
+}
+
+func copyBook(b *domain.Book) *domain.Book {
+	book := *b
+	if b.Tags != nil {
+		book.Tags = append([]string(nil), b.Tags...)
+	}
+	if b.DeletedAt != nil {
+		deletedAt := *b.DeletedAt
+		book.DeletedAt = &deletedAt
+	}
+	if b.Year != nil {
+		book.Year = optionalInt(*b.Year)
+	}
+	if b.Pages != nil {
+		book.Pages = optionalInt(*b.Pages)
+	}
+	if b.Cover != nil {
+		cover := *b.Cover
+		book.Cover = &cover
+	}
+	if b.CurrentPage != nil {
+		book.CurrentPage = optionalInt(*b.CurrentPage)
+	}
+	if b.Progress != nil {
+		book.Progress = optionalFloat(*b.Progress)
+	}
+	if b.StartedAt != nil {
+		book.StartedAt = optionalTime(*b.StartedAt)
+	}
+	if b.FinishedAt != nil {
+		book.FinishedAt = optionalTime(*b.FinishedAt)
+	}
+	if b.Rating != nil {
+		book.Rating = optionalFloat(*b.Rating)
+	}
+	return &book
+}
+
+// Trash methods
+func (r *MemoryRepository) ListTrash(ctx context.Context, userID string) ([]*domain.Book, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	books := []*domain.Book{}
+	for _, b := range r.books {
+		if b.UserID == userID && b.DeletedAt != nil {
+			books = append(books, copyBook(b))
+		}
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		if c := books[i].DeletedAt.Compare(*books[j].DeletedAt); c != 0 {
+			return c > 0
+		}
+		return books[i].ID > books[j].ID
+	})
+	return books, nil
+}
+
+func (r *MemoryRepository) Restore(ctx context.Context, id, userID string) (*domain.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.books {
+		if b.ID == id && b.UserID == userID && b.DeletedAt != nil {
+			b.DeletedAt = nil
+			b.Version++
+			return copyBook(b), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) Purge(ctx context.Context, id, userID string) error {
+	if len(r.purgeBooks(func(b *domain.Book) bool { return b.ID == id && b.UserID == userID })) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MemoryRepository) EmptyTrash(ctx context.Context, userID string) ([]string, error) {
+	return r.purgeBooks(func(b *domain.Book) bool { return b.UserID == userID }), nil
+}
+
+func (r *MemoryRepository) PurgeTrashedBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	return r.purgeBooks(func(b *domain.Book) bool { return b.DeletedAt.Before(cutoff) }), nil
+}
+
+// purgeBooks permanently deletes the trashed books selected by match along
+// with their notes, history and attachments, and returns their IDs.
+func (r *MemoryRepository) purgeBooks(match func(*domain.Book) bool) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := map[string]bool{}
+	ids := []string{}
+	kept := r.books[:0]
+	for _, b := range r.books {
+		if b.DeletedAt != nil && match(b) {
+			purged[b.ID] = true
+			ids = append(ids, b.ID)
+			continue
+		}
+		kept = append(kept, b)
+	}
+	r.books = kept
+	r.notes = filterNotes(r.notes, func(n *domain.Note) bool { return !purged[n.BookID] })
+	r.revisions = filterRevisions(r.revisions, func(rev *domain.Revision) bool { return !purged[rev.BookID] })
+	r.attachments = filterAttachments(r.attachments, func(a *domain.Attachment) bool { return !purged[a.BookID] })
+	r.uploads = filterUploads(r.uploads, func(u *domain.Upload) bool { return !purged[u.BookID] })
+	r.readingSessions = filterReadingSessions(r.readingSessions, func(s *domain.ReadingSession) bool { return !purged[s.BookID] })
+	for _, shelf := range r.shelves {
+		shelf.BookIDs = slices.DeleteFunc(shelf.BookIDs, func(id string) bool { return purged[id] })
+	}
+	return ids
+}
+
+// Revision methods
+func (r *MemoryRepository) AddRevision(ctx context.Context, rev *domain.Revision, keep int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rev.ID = newID()
+	rev.CreatedAt = now()
+	r.revisions = append(r.revisions, copyRevision(rev))
+
+	// Revisions are appended in order, so the oldest of the book come first
+	count := 0
+	for _, stored := range r.revisions {
+		if stored.BookID == rev.BookID {
+			count++
+		}
+	}
+	r.revisions = filterRevisions(r.revisions, func(stored *domain.Revision) bool {
+		if stored.BookID != rev.BookID || count <= keep {
+			return true
+		}
+		count--
+		return false
+	})
+	return nil
+}
+
+func (r *MemoryRepository) ListRevisions(ctx context.Context, bookID, userID string) ([]*domain.Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := []*domain.Revision{}
+	for i := len(r.revisions) - 1; i >= 0; i-- {
+		if rev := r.revisions[i]; rev.BookID == bookID && rev.UserID == userID {
+			revisions = append(revisions, copyRevision(rev))
+		}
+	}
+	return revisions, nil
+}
+
+func (r *MemoryRepository) GetRevision(ctx context.Context, id, bookID, userID string) (*domain.Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, rev := range r.revisions {
+		if rev.ID == id && rev.BookID == bookID && rev.UserID == userID {
+			return copyRevision(rev), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func copyRevision(rev *domain.Revision) *domain.Revision {
+	revision := *rev
+	revision.Changes = append([]domain.FieldChange(nil), rev.Changes...)
+	if rev.Book.Tags != nil {
+		revision.Book.Tags = append([]string(nil), rev.Book.Tags...)
+	}
+	return &revision
+}
+
+func filterRevisions(revisions []*domain.Revision, keep func(*domain.Revision) bool) []*domain.Revision {
+	kept := revisions[:0]
+	for _, rev := range revisions {
+		if keep(rev) {
+			kept = append(kept, rev)
+		}
+	}
+	return kept
+}
+
+// Metadata methods
+func (r *MemoryRepository) GetMetadata(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cached, ok := r.metadata[isbn]
+	if !ok || !now().Before(cached.expiresAt) {
+		return nil, ErrNotFound
+	}
+	return copyMetadata(&cached.meta), nil
+}
+
+func (r *MemoryRepository) PutMetadata(ctx context.Context, meta *domain.BookMetadata, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for isbn, cached := range r.metadata {
+		if !now().Before(cached.expiresAt) {
+			delete(r.metadata, isbn)
+		}
+	}
+	r.metadata[meta.ISBN] = cachedMetadata{meta: *copyMetadata(meta), expiresAt: expiresAt}
+	return nil
+}
+
+func copyMetadata(m *domain.BookMetadata) *domain.BookMetadata {
+	meta := *m
+	meta.Authors = append([]string(nil), m.Authors...)
+	if m.Year != nil {
+		meta.Year = optionalInt(*m.Year)
+	}
+	if m.Pages != nil {
+		meta.Pages = optionalInt(*m.Pages)
+	}
+	return &meta
+}
+
+// Attachment methods
+func (r *MemoryRepository) CreateAttachment(ctx context.Context, attachment *domain.Attachment, quota int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.storageUsed(attachment.UserID) > quota-attachment.Size {
+		return ErrQuotaExceeded
+	}
+	attachment.ID = newID()
+	attachment.CreatedAt = now()
+	r.attachments = append(r.attachments, copyAttachment(attachment))
+	return nil
+}
+
+func (r *MemoryRepository) ListAttachments(ctx context.Context, bookID, userID string) ([]*domain.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attachments := []*domain.Attachment{}
+	for _, a := range r.attachments {
+		if a.BookID == bookID && a.UserID == userID {
+			attachments = append(attachments, copyAttachment(a))
+		}
+	}
+	return attachments, nil
+}
+
+func (r *MemoryRepository) GetAttachment(ctx context.Context, id, bookID, userID string) (*domain.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, a := range r.attachments {
+		if a.ID == id && a.BookID == bookID && a.UserID == userID {
+			return copyAttachment(a), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) DeleteAttachment(ctx context.Context, id, bookID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, a := range r.attachments {
+		if a.ID == id && a.BookID == bookID && a.UserID == userID {
+			r.attachments = append(r.attachments[:i], r.attachments[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryRepository) CreateUpload(ctx context.Context, upload *domain.Upload, quota int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.storageUsed(upload.UserID) > quota-upload.Size {
+		return ErrQuotaExceeded
+	}
+	upload.ID = newID()
+	upload.CreatedAt = now()
+	upload.Offset = 0
+	upload.Parts = []string{}
+	r.uploads = append(r.uploads, copyUpload(upload))
+	return nil
+}
+
+func (r *MemoryRepository) GetUpload(ctx context.Context, id, bookID, userID string) (*domain.Upload, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.uploads {
+		if u.ID == id && u.BookID == bookID && u.UserID == userID {
+			return copyUpload(u), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) AdvanceUpload(ctx context.Context, id, userID string, from, to int64, part string) (*domain.Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.uploads {
+		if u.ID != id || u.UserID != userID {
+			continue
+		}
+		if u.Offset != from {
+			return nil, ErrVersionMismatch
+		}
+		u.Offset = to
+		u.Parts = append(u.Parts, part)
+		return copyUpload(u), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) DeleteUpload(ctx context.Context, id, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, u := range r.uploads {
+		if u.ID == id && u.UserID == userID {
+			r.uploads = append(r.uploads[:i], r.uploads[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryRepository) ExpiredUploads(ctx context.Context, cutoff time.Time) ([]*domain.Upload, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	uploads := []*domain.Upload{}
+	for _, u := range r.uploads {
+		if u.ExpiresAt.Before(cutoff) {
+			uploads = append(uploads, copyUpload(u))
+		}
+	}
+	return uploads, nil
+}
+
+func (r *MemoryRepository) StorageUsed(ctx context.Context, userID string) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.storageUsed(userID), nil
+}
+
+// storageUsed needs r.mu held.
+func (r *MemoryRepository) storageUsed(userID string) int64 {
+	var used int64
+	for _, a := range r.attachments {
+		if a.UserID == userID {
+			used += a.Size
+		}
+	}
+	for _, u := range r.uploads {
+		if u.UserID == userID {
+			used += u.Size
+		}
+	}
+	return used
+}
+
+func copyAttachment(a *domain.Attachment) *domain.Attachment {
+	attachment := *a
+	if a.Metadata != nil {
+		attachment.Metadata = copyMetadata(a.Metadata)
+	}
+	return &attachment
+}
+
+func copyUpload(u *domain.Upload) *domain.Upload {
+	upload := *u
+	upload.Parts = append([]string{}, u.Parts...)
+	return &upload
+}
+
+func filterAttachments(attachments []*domain.Attachment, keep func(*domain.Attachment) bool) []*domain.Attachment {
+	kept := attachments[:0]
+	for _, a := range attachments {
+		if keep(a) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+func filterUploads(uploads []*domain.Upload, keep func(*domain.Upload) bool) []*domain.Upload {
+	kept := uploads[:0]
+	for _, u := range uploads {
+		if keep(u) {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+// Reading session methods
+func (r *MemoryRepository) CreateReadingSession(ctx context.Context, session *domain.ReadingSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session.ID = newID()
+	session.CreatedAt = now()
+	r.readingSessions = append(r.readingSessions, copyReadingSession(session))
+	return nil
+}
+
+func (r *MemoryRepository) ListReadingSessions(ctx context.Context, bookID, userID string) ([]*domain.ReadingSession, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := []*domain.ReadingSession{}
+	for _, s := range r.readingSessions {
+		if s.BookID == bookID && s.UserID == userID {
+			sessions = append(sessions, copyReadingSession(s))
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions, nil
+}
+
+func (r *MemoryRepository) DeleteReadingSession(ctx context.Context, id, bookID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, s := range r.readingSessions {
+		if s.ID == id && s.BookID == bookID && s.UserID == userID {
+			r.readingSessions = append(r.readingSessions[:i], r.readingSessions[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryRepository) TimeSpent(ctx context.Context, userID string, bookIDs []string) (map[string]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	spent := map[string]int64{}
+	for _, s := range r.readingSessions {
+		if s.UserID == userID && containsString(bookIDs, s.BookID) {
+			spent[s.BookID] += s.Duration()
+		}
+	}
+	return spent, nil
+}
+
+func copyReadingSession(s *domain.ReadingSession) *domain.ReadingSession {
+	session := *s
+	if s.Pages != nil {
+		pages := *s.Pages
+		session.Pages = &pages
+	}
+	return &session
+}
+
+func filterReadingSessions(sessions []*domain.ReadingSession, keep func(*domain.ReadingSession) bool) []*domain.ReadingSession {
+	kept := sessions[:0]
+	for _, s := range sessions {
+		if keep(s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// Shelf methods
+func (r *MemoryRepository) CreateShelf(ctx context.Context, shelf *domain.Shelf) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shelf.ID = newID()
+	shelf.CreatedAt = now()
+	shelf.UpdatedAt = shelf.CreatedAt
+	if shelf.BookIDs == nil {
+		shelf.BookIDs = []string{}
+	}
+	r.shelves = append(r.shelves, copyShelf(shelf))
+	return nil
+}
+
+func (r *MemoryRepository) GetShelf(ctx context.Context, id, userID string) (*domain.Shelf, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	shelf := r.findShelf(id, userID)
+	if shelf == nil {
+		return nil, ErrNotFound
+	}
+	return copyShelf(shelf), nil
+}
+
+func (r *MemoryRepository) ListShelves(ctx context.Context, userID string) ([]*domain.Shelf, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	shelves := []*domain.Shelf{}
+	for _, shelf := range r.shelves {
+		if shelf.UserID == userID {
+			shelves = append(shelves, copyShelf(shelf))
+		}
+	}
+	return shelves, nil
+}
+
+func (r *MemoryRepository) UpdateShelf(ctx context.Context, id, userID string, update *domain.UpdateShelfRequest) (*domain.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shelf := r.findShelf(id, userID)
+	if shelf == nil {
+		return nil, ErrNotFound
+	}
+	if update.Name != nil {
+		shelf.Name = *update.Name
+	}
+	if update.Description != nil {
+		shelf.Description = *update.Description
+	}
+	if update.ParentID != nil {
+		shelf.ParentID = *update.ParentID
+	}
+	shelf.UpdatedAt = now()
+	return copyShelf(shelf), nil
+}
+
+func (r *MemoryRepository) DeleteShelf(ctx context.Context, id, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.findShelf(id, userID) == nil {
+		return ErrNotFound
+	}
+	r.shelves = slices.DeleteFunc(r.shelves, func(shelf *domain.Shelf) bool { return shelf.ID == id })
+	for _, shelf := range r.shelves {
+		if shelf.ParentID == id {
+			shelf.ParentID = ""
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) PlaceShelfBook(ctx context.Context, id, userID, bookID string, position int) (*domain.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shelf := r.findShelf(id, userID)
+	if shelf == nil {
+		return nil, ErrNotFound
+	}
+	books := slices.DeleteFunc(shelf.BookIDs, func(id string) bool { return id == bookID })
+	shelf.BookIDs = slices.Insert(books, min(position, len(books)), bookID)
+	shelf.UpdatedAt = now()
+	return copyShelf(shelf), nil
+}
+
+func (r *MemoryRepository) RemoveShelfBook(ctx context.Context, id, userID, bookID string) (*domain.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shelf := r.findShelf(id, userID)
+	if shelf == nil || !slices.Contains(shelf.BookIDs, bookID) {
+		return nil, ErrNotFound
+	}
+	shelf.BookIDs = slices.DeleteFunc(shelf.BookIDs, func(id string) bool { return id == bookID })
+	shelf.UpdatedAt = now()
+	return copyShelf(shelf), nil
+}
+
+func (r *MemoryRepository) ReorderShelf(ctx context.Context, id, userID string, bookIDs []string) (*domain.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shelf := r.findShelf(id, userID)
+	if shelf == nil {
+		return nil, ErrNotFound
+	}
+	if !shelf.SameBooks(bookIDs) {
+		return nil, domain.ErrShelfOrder
+	}
+	shelf.BookIDs = append([]string{}, bookIDs...)
+	shelf.UpdatedAt = now()
+	return copyShelf(shelf), nil
+}
+
+// findShelf returns the stored shelf, or nil. Callers must hold r.mu.
+func (r *MemoryRepository) findShelf(id, userID string) *domain.Shelf {
+	for _, shelf := range r.shelves {
+		if shelf.ID == id && shelf.UserID == userID {
+			return shelf
+		}
+	}
+	return nil
+}
+
+func copyShelf(s *domain.Shelf) *domain.Shelf {
+	shelf := *s
+	shelf.BookIDs = append([]string{}, s.BookIDs...)
+	return &shelf
+}
+
+// Tag methods
+func (r *MemoryRepository) ListTags(ctx context.Context, userID, prefix string, limit int) ([]domain.FacetCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	counts := map[string]int64{}
+	for _, b := range r.books {
+		if b.UserID != userID || b.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range b.Tags {
+			if tag != "" && strings.HasPrefix(strings.ToLower(tag), prefix) {
+				counts[tag]++
+			}
+		}
+	}
+	return topFacets(counts, limit), nil
+}
+
+// Statistics methods
+func (r *MemoryRepository) ReadingStats(ctx context.Context, userID string, query *domain.StatsQuery) (*domain.ReadingStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var books []*domain.Book
+	live := map[string]bool{}
+	for _, b := range r.books {
+		if b.UserID == userID && b.DeletedAt == nil {
+			books = append(books, b)
+			live[b.ID] = true
+		}
+	}
+	// Sessions of books in the trash do not count, like the books
+	var sessions []*domain.ReadingSession
+	for _, s := range r.readingSessions {
+		if s.UserID == userID && live[s.BookID] {
+			sessions = append(sessions, s)
+		}
+	}
+	return tallyReadingStats(books, sessions, query), nil
+}
+
+// Note methods
+func (r *MemoryRepository) CreateNote(ctx context.Context, note *domain.Note) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	note.ID = newID()
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now()
+	}
+	note.UpdatedAt = note.CreatedAt
+	if note.Kind == "" {
+		note.Kind = domain.NoteKindNote
+	}
+
+	r.notes = append(r.notes, copyNote(note))
+	return nil
+}
+
+func (r *MemoryRepository) UpdateNote(ctx context.Context, id, bookID, userID string, update *domain.UpdateNoteRequest) (*domain.Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, n := range r.notes {
+		if n.ID != id || n.BookID != bookID || n.UserID != userID {
+			continue
+		}
+		if update.Body != nil {
+			n.Body = *update.Body
+		}
+		if update.Page != nil {
+			page := *update.Page
+			n.Page = &page
+		}
+		if update.Location != nil {
+			n.Location = *update.Location
+		}
+		n.UpdatedAt = now()
+		return copyNote(n), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) DeleteNote(ctx context.Context, id, bookID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, n := range r.notes {
+		if n.ID == id && n.BookID == bookID && n.UserID == userID {
+			r.notes = append(r.notes[:i], r.notes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryRepository) GetNoteByID(ctx context.Context, id, bookID, userID string) (*domain.Note, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, n := range r.notes {
+		if n.ID == id && n.BookID == bookID && n.UserID == userID {
+			return copyNote(n), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) ListNotes(ctx context.Context, bookID, userID string) ([]*domain.Note, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	notes := []*domain.Note{}
+	for _, n := range r.notes {
+		if n.BookID == bookID && n.UserID == userID {
+			notes = append(notes, copyNote(n))
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].CreatedAt.Before(notes[j].CreatedAt)
+	})
+	return notes, nil
+}
+
+func copyNote(n *domain.Note) *domain.Note {
+	note := *n
+	if n.Page != nil {
+		page := *n.Page
+		note.Page = &page
+	}
+	return &note
+}
+
+func filterNotes(notes []*domain.Note, keep func(*domain.Note) bool) []*domain.Note {
+	kept := notes[:0]
+	for _, n := range notes {
+		if keep(n) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// Session methods
+func (r *MemoryRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session.ID = newID()
+	session.CreatedAt = now()
+	session.LastUsedAt = session.CreatedAt
+	if session.PreviousHashes == nil {
+		session.PreviousHashes = []string{}
+	}
+
+	for _, s := range r.sessions {
+		if s.TokenHash == session.TokenHash {
+			return errors.New("duplicate refresh token")
+		}
+	}
+
+	r.sessions = append(r.sessions, copySession(session))
+	return nil
+}
+
+func (r *MemoryRepository) FindSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, s := range r.sessions {
+		if s.ID == id {
+			return copySession(s), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) RotateSession(ctx context.Context, oldHash, newHash string, expiresAt time.Time, maxAge time.Duration) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ts := now()
+	for _, s := range r.sessions {
+		if s.TokenHash != oldHash || !s.Active(ts) {
+			continue
+		}
+		s.TokenHash = newHash
+		s.LastUsedAt = ts
+		s.ExpiresAt = s.CapExpiry(expiresAt, maxAge)
+		s.PreviousHashes = append(s.PreviousHashes, oldHash)
+		if len(s.PreviousHashes) > maxPreviousHashes {
+			s.PreviousHashes = s.PreviousHashes[len(s.PreviousHashes)-maxPreviousHashes:]
+		}
+		return copySession(s), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) FindSessionByPreviousHash(ctx context.Context, hash string) (*models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, s := range r.sessions {
+		for _, h := range s.PreviousHashes {
+			if h == hash {
+				return copySession(s), nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRepository) RevokeSession(ctx context.Context, id, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revokedAt := now()
+	for _, s := range r.sessions {
+		if s.ID == id && s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revokedAt := now()
+	for _, s := range r.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func copySession(s *models.Session) *models.Session {
+	session := *s
+	session.PreviousHashes = append([]string{}, s.PreviousHashes...)
+	if s.RevokedAt != nil {
+		revokedAt := *s.RevokedAt
+		session.RevokedAt = &revokedAt
+	}
+	return &session
+}
@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// MetadataRepository caches the answers of ISBN metadata providers.
+type MetadataRepository interface {
+	// GetMetadata returns the cached metadata of an ISBN-13, or ErrNotFound
+	// when there is none or it has expired.
+	GetMetadata(ctx context.Context, isbn string) (*domain.BookMetadata, error)
+	// PutMetadata caches meta under its ISBN until expiresAt.
+	PutMetadata(ctx context.Context, meta *domain.BookMetadata, expiresAt time.Time) error
+}
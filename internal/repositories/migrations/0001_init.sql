@@ -0,0 +1,66 @@
+-- Users, books, notes and login sessions.
+-- Statements must run unchanged on both PostgreSQL and SQLite.
+
+CREATE TABLE users (
+    id         TEXT PRIMARY KEY,
+    username   TEXT NOT NULL UNIQUE,
+    email      TEXT NOT NULL UNIQUE,
+    password   TEXT NOT NULL,
+    role       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE books (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    title       TEXT NOT NULL,
+    author      TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX books_user_id_created_at ON books (user_id, created_at);
+
+CREATE TABLE book_tags (
+    book_id  TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    tag      TEXT NOT NULL,
+    PRIMARY KEY (book_id, position)
+);
+
+CREATE INDEX book_tags_tag ON book_tags (tag);
+
+CREATE TABLE notes (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    book_id    TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    body       TEXT NOT NULL,
+    page       INTEGER,
+    location   TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX notes_user_id_book_id_created_at ON notes (user_id, book_id, created_at);
+
+CREATE TABLE sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash   TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NOT NULL,
+    expires_at   TIMESTAMP NOT NULL,
+    revoked_at   TIMESTAMP
+);
+
+CREATE INDEX sessions_user_id ON sessions (user_id);
+
+-- Refresh tokens a session has been rotated away from, for reuse detection
+CREATE TABLE session_previous_tokens (
+    token_hash TEXT PRIMARY KEY,
+    session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    rotated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX session_previous_tokens_session_id ON session_previous_tokens (session_id, rotated_at);
@@ -0,0 +1,14 @@
+CREATE TABLE book_revisions (
+    id          TEXT PRIMARY KEY,
+    book_id     TEXT NOT NULL,
+    user_id     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    note_id     TEXT NOT NULL DEFAULT '',
+    action      TEXT NOT NULL,
+    changes     TEXT NOT NULL,
+    book        TEXT NOT NULL,
+    version     BIGINT NOT NULL,
+    reverted_to TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX book_revisions_book_id ON book_revisions (book_id, created_at);
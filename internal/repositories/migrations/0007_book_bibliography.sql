@@ -0,0 +1,5 @@
+-- Optional bibliographic details for citations
+ALTER TABLE books ADD COLUMN publisher TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN year INTEGER NULL;
+ALTER TABLE books ADD COLUMN edition TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN pages INTEGER NULL;
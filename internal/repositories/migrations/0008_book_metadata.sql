@@ -0,0 +1,8 @@
+-- Cover images found by ISBN lookups, and the cache of those lookups
+ALTER TABLE books ADD COLUMN cover_url TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE isbn_metadata (
+    isbn       TEXT PRIMARY KEY,
+    data       TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
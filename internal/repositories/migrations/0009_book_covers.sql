@@ -0,0 +1,2 @@
+-- The uploaded cover image of a book, as JSON
+ALTER TABLE books ADD COLUMN cover TEXT NULL;
@@ -0,0 +1,33 @@
+-- Files attached to books, and the resumable uploads that produce them.
+-- metadata and parts are JSON.
+CREATE TABLE attachments (
+    id           TEXT PRIMARY KEY,
+    book_id      TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    file_name    TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size         BIGINT NOT NULL,
+    sha256       TEXT NOT NULL,
+    blob_key     TEXT NOT NULL,
+    metadata     TEXT NULL,
+    created_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX attachments_book_id ON attachments (book_id, created_at);
+CREATE INDEX attachments_user_id ON attachments (user_id);
+
+CREATE TABLE uploads (
+    id         TEXT PRIMARY KEY,
+    book_id    TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    file_name  TEXT NOT NULL,
+    size       BIGINT NOT NULL,
+    received   BIGINT NOT NULL,
+    prefill    BOOLEAN NOT NULL,
+    parts      TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX uploads_user_id ON uploads (user_id);
+CREATE INDEX uploads_expires_at ON uploads (expires_at);
@@ -0,0 +1,21 @@
+-- Reading status and progress of books, and the sessions spent reading them
+ALTER TABLE books ADD COLUMN status TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN current_page INTEGER NULL;
+ALTER TABLE books ADD COLUMN progress DOUBLE PRECISION NULL;
+ALTER TABLE books ADD COLUMN started_at TIMESTAMP NULL;
+ALTER TABLE books ADD COLUMN finished_at TIMESTAMP NULL;
+
+CREATE INDEX books_status ON books (user_id, status);
+
+CREATE TABLE reading_sessions (
+    id         TEXT PRIMARY KEY,
+    book_id    TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    started_at TIMESTAMP NOT NULL,
+    ended_at   TIMESTAMP NOT NULL,
+    pages      INTEGER NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX reading_sessions_book_id ON reading_sessions (book_id, started_at);
+CREATE INDEX reading_sessions_user_id ON reading_sessions (user_id, started_at);
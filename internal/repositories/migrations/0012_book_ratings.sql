@@ -0,0 +1,5 @@
+-- Star ratings and reviews of books
+ALTER TABLE books ADD COLUMN rating DOUBLE PRECISION NULL;
+ALTER TABLE books ADD COLUMN review TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX books_rating ON books (user_id, rating);
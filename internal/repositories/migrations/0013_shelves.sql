@@ -0,0 +1,21 @@
+-- Shelves: named, ordered collections of books, nested one level deep
+CREATE TABLE shelves (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    parent_id   TEXT NULL REFERENCES shelves (id) ON DELETE SET NULL,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX shelves_user_id ON shelves (user_id, created_at);
+
+CREATE TABLE shelf_books (
+    shelf_id TEXT NOT NULL REFERENCES shelves (id) ON DELETE CASCADE,
+    book_id  TEXT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (shelf_id, book_id)
+);
+
+CREATE INDEX shelf_books_book_id ON shelf_books (book_id);
@@ -3,10 +3,12 @@ package repositories
 import (
 	"context"
 	"errors"
-	"os"
+	"math"
+	"regexp"
 	"time"
 
 	"github.com/smartnotes/user-service/internal/models"
+	"github.com/smartnotes/user-service/internal/search"
 	"github.com/smartnotes/user-service/pkg/domain"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,27 +16,193 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var _ Repository = (*MongoDBRepository)(nil)
+
 type MongoDBRepository struct {
 	client *mongo.Client
 	db     *mongo.Database
 }
 
-func NewMongoDBRepository() (*MongoDBRepository, error) {
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		uri = "mongodb://localhost:27017"
-	}
-
+func NewMongoDBRepository(uri string) (*MongoDBRepository, error) {
 	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
 	if err != nil {
 		return nil, err
 	}
 
 	db := client.Database("smartnotes")
-	return &MongoDBRepository{
+	repo := &MongoDBRepository{
 		client: client,
 		db:     db,
-	}, nil
+	}
+	if err := repo.ensureIndexes(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := repo.normalizeStoredTags(context.Background()); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Database returns the database the repository works in, for storing
+// files in GridFS next to it.
+func (r *MongoDBRepository) Database() *mongo.Database {
+	return r.db
+}
+
+func (r *MongoDBRepository) ensureIndexes(ctx context.Context) error {
+	_, err := r.db.Collection("notes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "book_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Full-text search; weights match the in-process scorer
+	_, err = r.db.Collection("books").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "title", Value: "text"},
+			{Key: "author", Value: "text"},
+			{Key: "tags", Value: "text"},
+			{Key: "description", Value: "text"},
+		},
+		Options: options.Index().SetName("books_text").SetWeights(bson.M{
+			"title":       titleSearchWeight,
+			"author":      authorSearchWeight,
+			"tags":        tagsSearchWeight,
+			"description": descriptionSearchWeight,
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Finds trashed books for the trash view and the purge job
+	_, err = r.db.Collection("books").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("notes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "body", Value: "text"}},
+		Options: options.Index().SetName("notes_text").SetWeights(bson.M{"body": noteSearchWeight}),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("revisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("isbn_metadata").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("attachments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Expired uploads are not left to a TTL index, as their chunks have to
+	// be deleted from the blob store too
+	_, err = r.db.Collection("uploads").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("reading_sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "start", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "start", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("books").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "rating", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("shelves").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "books", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Collection("sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "previous_hashes", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		// Let Mongo drop sessions once their refresh token can no longer be used
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// normalizeStoredTags rewrites the tags of books written before tags were
+// normalized. Each book gets a new version; once every tag is normalized it
+// only reads the distinct tags.
+func (r *MongoDBRepository) normalizeStoredTags(ctx context.Context) error {
+	collection := r.db.Collection("books")
+	tags, err := collection.Distinct(ctx, "tags", bson.M{})
+	if err != nil {
+		return err
+	}
+	stale := bson.A{}
+	for _, tag := range tags {
+		if s, ok := tag.(string); ok && domain.NormalizeTag(s) != s {
+			stale = append(stale, s)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"tags": bson.M{"$in": stale}}, options.Find().SetProjection(bson.M{"tags": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Tags []string           `bson:"tags"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		// Matching the old tags leaves a book that changed meanwhile alone;
+		// it was written normalized
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID, "tags": doc.Tags}, bson.M{
+			"$set": bson.M{"tags": domain.NormalizeTags(doc.Tags)},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
 }
 
 // User methods
@@ -42,7 +210,7 @@ func (r *MongoDBRepository) CreateUser(user *models.User) error {
 	collection := r.db.Collection("users")
 
 	// Check if user already exists
-	var existingUser models.User
+	var existingUser userDocument
 	err := collection.FindOne(context.Background(), bson.M{
 		"$or": []bson.M{
 			{"email": user.Email},
@@ -57,124 +225,1855 @@ func (r *MongoDBRepository) CreateUser(user *models.User) error {
 		return err
 	}
 
-	user.CreatedAt = time.Now()
+	user.ID = newID()
+	user.CreatedAt = now()
 	user.Role = "user"
 
-	_, err = collection.InsertOne(context.Background(), user)
+	doc := &userDocument{User: *user}
+	doc.ID, _ = primitive.ObjectIDFromHex(user.ID)
+	_, err = collection.InsertOne(context.Background(), doc)
 	return err
 }
 
 func (r *MongoDBRepository) FindUserByEmail(email string) (*models.User, error) {
 	collection := r.db.Collection("users")
-	var user models.User
+	var doc userDocument
 
-	err := collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	err := collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return doc.toModel(), nil
 }
 
-func (r *MongoDBRepository) FindUserByID(id primitive.ObjectID) (*models.User, error) {
+func (r *MongoDBRepository) FindUserByID(id string) (*models.User, error) {
+	oids, err := objectIDs(id)
+	if err != nil {
+		return nil, err
+	}
+
 	collection := r.db.Collection("users")
-	var user models.User
+	var doc userDocument
 
-	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user)
+	err = collection.FindOne(context.Background(), bson.M{"_id": oids[0]}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return doc.toModel(), nil
 }
 
 // Book methods
 func (r *MongoDBRepository) Create(ctx context.Context, book *domain.Book) error {
 	collection := r.db.Collection("books")
-	book.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
-	book.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
-	_, err := collection.InsertOne(ctx, book)
+	book.ID = newID()
+	// Imports keep the date a book was added
+	book.UpdatedAt = now()
+	if book.CreatedAt.IsZero() {
+		book.CreatedAt = book.UpdatedAt
+	}
+	book.Version = 1
+	_, err := collection.InsertOne(ctx, newBookDocument(book))
 	return err
 }
 
-func (r *MongoDBRepository) Update(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, update *domain.Book) error {
+func (r *MongoDBRepository) Update(ctx context.Context, id string, userID string, version *int64, update *domain.UpdateBookRequest) (*domain.Book, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	collection := r.db.Collection("books")
-	update.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
-	_, err := collection.UpdateOne(ctx, bson.M{
-		"_id":     id,
-		"user_id": userID,
-	}, bson.M{"$set": update})
-	return err
+
+	set := bson.M{"updated_at": now()}
+	if update.Title != nil {
+		set["title"] = *update.Title
+	}
+	if update.Author != nil {
+		set["author"] = *update.Author
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.ISBN != nil {
+		set["isbn"] = *update.ISBN
+	}
+	unset := bson.M{}
+	if update.Publisher != nil {
+		set["publisher"] = *update.Publisher
+	}
+	if update.Year != nil {
+		if *update.Year == 0 {
+			unset["year"] = ""
+		} else {
+			set["year"] = *update.Year
+		}
+	}
+	if update.Edition != nil {
+		set["edition"] = *update.Edition
+	}
+	if update.Pages != nil {
+		if *update.Pages == 0 {
+			unset["pages"] = ""
+		} else {
+			set["pages"] = *update.Pages
+		}
+	}
+	if update.CoverURL != nil {
+		set["cover_url"] = *update.CoverURL
+	}
+	if update.Tags != nil {
+		set["tags"] = *update.Tags
+	}
+	if update.Status != nil {
+		if *update.Status == "" {
+			unset["status"] = ""
+		} else {
+			set["status"] = *update.Status
+		}
+	}
+	if update.CurrentPage != nil {
+		if *update.CurrentPage == 0 {
+			unset["current_page"] = ""
+		} else {
+			set["current_page"] = *update.CurrentPage
+		}
+	}
+	if update.Progress != nil {
+		if *update.Progress == 0 {
+			unset["progress"] = ""
+		} else {
+			set["progress"] = *update.Progress
+		}
+	}
+	if update.StartedAt != nil {
+		if update.StartedAt.IsZero() {
+			unset["started_at"] = ""
+		} else {
+			set["started_at"] = optionalTime(*update.StartedAt)
+		}
+	}
+	if update.FinishedAt != nil {
+		if update.FinishedAt.IsZero() {
+			unset["finished_at"] = ""
+		} else {
+			set["finished_at"] = optionalTime(*update.FinishedAt)
+		}
+	}
+	if update.Rating != nil {
+		if *update.Rating == 0 {
+			unset["rating"] = ""
+		} else {
+			set["rating"] = *update.Rating
+		}
+	}
+	if update.Review != nil {
+		set["review"] = *update.Review
+	}
+
+	changes := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
+
+	var doc bookDocument
+	filter := bookVersionFilter(oids[0], oids[1], version)
+	err = collection.FindOneAndUpdate(ctx, filter, changes, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, r.bookMissOrConflict(ctx, oids[0], oids[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) SetCover(ctx context.Context, id string, userID string, version *int64, cover *domain.Cover) (*domain.Book, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": now()}
+	changes := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if cover != nil {
+		set["cover"] = cover
+	} else {
+		changes["$unset"] = bson.M{"cover": ""}
+	}
+
+	var doc bookDocument
+	err = r.db.Collection("books").FindOneAndUpdate(ctx, bookVersionFilter(oids[0], oids[1], version), changes,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, r.bookMissOrConflict(ctx, oids[0], oids[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
 }
 
-func (r *MongoDBRepository) Delete(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) error {
+func (r *MongoDBRepository) Delete(ctx context.Context, id string, userID string, version *int64) error {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return err
+	}
+
 	collection := r.db.Collection("books")
-	_, err := collection.DeleteOne(ctx, bson.M{
-		"_id":     id,
-		"user_id": userID,
+	res, err := collection.UpdateOne(ctx, bookVersionFilter(oids[0], oids[1], version), bson.M{
+		"$set": bson.M{"deleted_at": now()},
+		"$inc": bson.M{"version": 1},
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return r.bookMissOrConflict(ctx, oids[0], oids[1])
+	}
+	return nil
+}
+
+// bookVersionFilter matches the user's book unless it is in the trash, and
+// with a non-nil version only that version of it. Books written before
+// versioning have none and count as version 0.
+func bookVersionFilter(id, userID primitive.ObjectID, version *int64) bson.M {
+	filter := bson.M{"_id": id, "user_id": userID, "deleted_at": nil}
+	if version != nil {
+		filter["version"] = *version
+		if *version == 0 {
+			filter["version"] = bson.M{"$in": bson.A{0, nil}}
+		}
+	}
+	return filter
 }
 
-func (r *MongoDBRepository) GetByID(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) (*domain.Book, error) {
+// bookMissOrConflict explains why a conditional write matched nothing.
+func (r *MongoDBRepository) bookMissOrConflict(ctx context.Context, id, userID primitive.ObjectID) error {
+	n, err := r.db.Collection("books").CountDocuments(ctx, bson.M{"_id": id, "user_id": userID, "deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrVersionMismatch
+	}
+	return ErrNotFound
+}
+
+// WithTransaction requires MongoDB to run as a replica set or sharded
+// cluster. The driver retries fn on transient transaction errors.
+func (r *MongoDBRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context, books BookRepository) error) error {
+	return r.client.UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (any, error) {
+			return nil, fn(sc, r)
+		})
+		return err
+	})
+}
+
+func (r *MongoDBRepository) GetByID(ctx context.Context, id string, userID string) (*domain.Book, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	collection := r.db.Collection("books")
-	var book domain.Book
-	err := collection.FindOne(ctx, bson.M{
-		"_id":     id,
-		"user_id": userID,
-	}).Decode(&book)
+	var doc bookDocument
+	err = collection.FindOne(ctx, bson.M{
+		"_id":        oids[0],
+		"user_id":    oids[1],
+		"deleted_at": nil,
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &book, nil
+	return doc.toDomain(), nil
 }
 
-func (r *MongoDBRepository) List(ctx context.Context, userID primitive.ObjectID, search, sortBy, order string, limit, offset int) ([]*domain.Book, error) {
+func (r *MongoDBRepository) List(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.Book, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	collection := r.db.Collection("books")
 
 	// Build filter
-	filter := bson.M{"user_id": userID}
-	if search != "" {
-		filter["$or"] = []bson.M{
-			{"title": bson.M{"$regex": search, "$options": "i"}},
-			{"description": bson.M{"$regex": search, "$options": "i"}},
-		}
-	}
+	filter := bookListFilter(oids[0], query)
 
-	// Build sort
-	sort := 1
-	if order == "desc" {
-		sort = -1
+	// Build sort, with _id as tiebreaker so pages are stable
+	sort := bson.D{}
+	for _, key := range query.SortKeys {
+		sort = append(sort, bson.E{Key: key.Field, Value: sortDirection(key)})
 	}
+	sort = append(sort, bson.E{Key: "_id", Value: sortDirection(query.SortKeys[len(query.SortKeys)-1])})
 	opts := options.Find().
-		SetSort(bson.D{{Key: sortBy, Value: sort}}).
-		SetLimit(int64(limit)).
-		SetSkip(int64(offset))
+		SetSort(sort).
+		SetLimit(int64(query.Limit)).
+		SetSkip(int64(query.Offset))
+
+	var after bson.M
+	if query.Cursor != nil {
+		after, err = cursorFilter(query.Cursor, query.SortKeys)
+		if errors.Is(err, ErrNotFound) {
+			// Not one of our IDs, so nothing can come after it
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	cursor, err := collection.Find(ctx, filter, opts)
+	var cursor *mongo.Cursor
+	if query.Shelf != nil {
+		// Positions on a shelf are not stored with the books, so they are
+		// worked out from the shelf's order before sorting
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$addFields", Value: bson.M{"position": bson.M{"$indexOfArray": bson.A{shelfBookIDs(query.Shelf), "$_id"}}}}},
+		}
+		if after != nil {
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+		if query.Offset > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$skip", Value: query.Offset}})
+		}
+		if limit := query.Limit; limit != 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$limit", Value: max(limit, -limit)}})
+		}
+		cursor, err = collection.Aggregate(ctx, pipeline)
+	} else {
+		if after != nil {
+			filter = bson.M{"$and": bson.A{filter, after}}
+		}
+		cursor, err = collection.Find(ctx, filter, opts)
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var books []*domain.Book
-	if err = cursor.All(ctx, &books); err != nil {
+	var docs []*bookDocument
+	if err = cursor.All(ctx, &docs); err != nil {
 		return nil, err
 	}
 
+	var books []*domain.Book
+	for _, doc := range docs {
+		books = append(books, doc.toDomain())
+	}
 	return books, nil
 }
 
-func (r *MongoDBRepository) Count(ctx context.Context, userID primitive.ObjectID, search string) (int64, error) {
+func (r *MongoDBRepository) Count(ctx context.Context, userID string, query *domain.ListBooksQuery) (int64, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return 0, err
+	}
+
 	collection := r.db.Collection("books")
+	return collection.CountDocuments(ctx, bookListFilter(oids[0], query))
+}
+
+func (r *MongoDBRepository) Facets(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.BookFacets, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	countBy := func(field string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$match": bson.M{"_id": bson.M{"$nin": bson.A{nil, ""}}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": maxFacetValues},
+			bson.M{"$project": bson.M{"_id": 0, "value": "$_id", "count": 1}},
+		}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bookListFilter(oids[0], query)}},
+		{{Key: "$facet", Value: bson.M{
+			"tags":    append(bson.A{bson.M{"$unwind": "$tags"}}, countBy("$tags")...),
+			"authors": countBy("$author"),
+		}}},
+	}
+
+	cursor, err := r.db.Collection("books").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var facets []*domain.BookFacets
+	if err := cursor.All(ctx, &facets); err != nil {
+		return nil, err
+	}
+	if len(facets) == 0 {
+		return &domain.BookFacets{Tags: []domain.FacetCount{}, Authors: []domain.FacetCount{}}, nil
+	}
+	return facets[0], nil
+}
+
+func (r *MongoDBRepository) RatingStats(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.RatingStats, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bookListFilter(oids[0], query)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{"$rating", 0}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := r.db.Collection("books").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Rating float64 `bson:"_id"`
+		Count  int64   `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := map[float64]int64{}
+	for _, group := range groups {
+		counts[group.Rating] = group.Count
+	}
+	return domain.NewRatingStats(counts), nil
+}
+
+// nullableSortFields are the sort fields that books may lack. Cursors carry
+// their zero value for a missing field, which sorts before any other.
+var nullableSortFields = map[string]bool{"rating": true}
+
+func sortDirection(key domain.SortKey) int {
+	if key.Desc {
+		return -1
+	}
+	return 1
+}
+
+// cursorFilter matches the books that come after cursor in a listing sorted
+// by keys and then _id: those past it on the first key that differs.
+func cursorFilter(cursor *domain.BookCursor, keys []domain.SortKey) (bson.M, error) {
+	oids, err := objectIDs(cursor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	past := func(key domain.SortKey) string {
+		if key.Desc {
+			return "$lt"
+		}
+		return "$gt"
+	}
+
+	var or bson.A
+	equal := bson.M{}
+	for i, key := range keys {
+		value := cursor.Values[i]
+		condition := bson.M{past(key): value}
+		if nullableSortFields[key.Field] {
+			// Range operators skip missing fields, which sort last in
+			// descending order
+			if value == 0.0 {
+				value = nil
+			} else if key.Desc {
+				condition = bson.M{"$not": bson.M{"$gte": value}}
+			}
+		}
+		branch := bson.M{key.Field: condition}
+		for field, value := range equal {
+			branch[field] = value
+		}
+		or = append(or, branch)
+		equal[key.Field] = value
+	}
+	equal["_id"] = bson.M{past(keys[len(keys)-1]): oids[0]}
+	return bson.M{"$or": append(or, equal)}, nil
+}
 
-	filter := bson.M{"user_id": userID}
-	if search != "" {
+// bookListFilter translates the structured filters of a ListBooksQuery,
+// except the full-text query, into a filter over the user's books.
+func bookListFilter(userID primitive.ObjectID, query *domain.ListBooksQuery) bson.M {
+	filter := bson.M{"user_id": userID, "deleted_at": nil}
+	if query.Search != "" {
+		pattern := regexp.QuoteMeta(query.Search)
 		filter["$or"] = []bson.M{
-			{"title": bson.M{"$regex": search, "$options": "i"}},
-			{"description": bson.M{"$regex": search, "$options": "i"}},
+			{"title": bson.M{"$regex": pattern, "$options": "i"}},
+			{"description": bson.M{"$regex": pattern, "$options": "i"}},
+		}
+	}
+	if len(query.Tags) > 0 {
+		op := "$in"
+		if query.TagMode == domain.TagModeAll {
+			op = "$all"
+		}
+		filter["tags"] = bson.M{op: query.Tags}
+	}
+	if query.Shelf != nil {
+		filter["_id"] = bson.M{"$in": shelfBookIDs(query.Shelf)}
+	}
+	if query.Author != "" {
+		filter["author"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.Author) + "$", "$options": "i"}
+	}
+	if len(query.Statuses) > 0 {
+		// Books without a status have no status field, which $in matches
+		// with null
+		statuses := make([]any, len(query.Statuses))
+		for i, status := range query.Statuses {
+			statuses[i] = status
+			if status == domain.StatusNone {
+				statuses[i] = nil
+			}
 		}
+		filter["status"] = bson.M{"$in": statuses}
 	}
 
-	return collection.CountDocuments(ctx, filter)
+	rating := bson.M{}
+	if query.RatingMin != nil {
+		rating["$gte"] = *query.RatingMin
+	}
+	if query.RatingMax != nil {
+		rating["$lte"] = *query.RatingMax
+	}
+	if len(rating) > 0 {
+		filter["rating"] = rating
+	}
+
+	created := bson.M{}
+	if query.CreatedAfter != nil {
+		created["$gte"] = query.CreatedAfter.Time
+	}
+	if query.CreatedBefore != nil {
+		created["$lt"] = query.CreatedBefore.Time
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	if query.UpdatedSince != nil {
+		filter["updated_at"] = bson.M{"$gte": query.UpdatedSince.Time}
+	}
+	return filter
+}
+
+// scoredBookDocument and scoredNoteDocument carry the $meta textScore.
+type scoredBookDocument struct {
+	bookDocument `bson:",inline"`
+	Score        float64 `bson:"score"`
+}
+
+type scoredNoteDocument struct {
+	noteDocument `bson:",inline"`
+	Score        float64 `bson:"score"`
+}
+
+// Search uses the text indexes for terms and phrases and anchored regexes
+// for prefixes, which $text cannot express. Books and notes are queried
+// separately and merged, a book scoring for its own fields plus every note
+// that matches.
+func (r *MongoDBRepository) Search(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.BookSearchResult, int64, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q := search.Parse(query.Query)
+	if q.Empty() {
+		return []*domain.BookSearchResult{}, 0, nil
+	}
+
+	// Matches through the book's own fields
+	var books []*scoredBookDocument
+	filter := textSearchFilter(bookListFilter(oids[0], query), q, "title", "author", "tags", "description")
+	if err := r.findScored(ctx, "books", filter, &books); err != nil {
+		return nil, 0, err
+	}
+
+	docs := map[primitive.ObjectID]*bookDocument{}
+	scores := map[primitive.ObjectID]float64{}
+	for _, b := range books {
+		docs[b.ID] = &b.bookDocument
+		scores[b.ID] = b.Score + prefixScore(q, bookSearchFields(b.toDomain())...)
+	}
+
+	// Matches through the book's notes
+	var notes []*scoredNoteDocument
+	filter = textSearchFilter(bson.M{"user_id": oids[0]}, q, "body")
+	if err := r.findScored(ctx, "notes", filter, &notes); err != nil {
+		return nil, 0, err
+	}
+
+	notesByBook := map[primitive.ObjectID][]*domain.Note{}
+	var missing []primitive.ObjectID
+	for _, n := range notes {
+		scores[n.BookID] += n.Score + prefixScore(q, search.Field{Name: "notes", Text: n.Body, Weight: noteSearchWeight})
+		notesByBook[n.BookID] = append(notesByBook[n.BookID], n.toDomain())
+		if _, ok := docs[n.BookID]; !ok {
+			docs[n.BookID] = nil
+			missing = append(missing, n.BookID)
+		}
+	}
+
+	if len(missing) > 0 {
+		filter = bookListFilter(oids[0], query)
+		filter["_id"] = bson.M{"$in": missing}
+		cursor, err := r.db.Collection("books").Find(ctx, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		var found []*bookDocument
+		if err := cursor.All(ctx, &found); err != nil {
+			return nil, 0, err
+		}
+		for _, b := range found {
+			docs[b.ID] = b
+		}
+	}
+
+	var results []*domain.BookSearchResult
+	for id, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		score := math.Round(scores[id]*1000) / 1000
+		results = append(results, newBookSearchResult(q, doc.toDomain(), score, notesByBook[id]))
+	}
+
+	page, total := rankResults(results, query.Limit, query.Offset)
+	return page, total, nil
+}
+
+// textSearchFilter narrows filter to the documents matching q: $text for
+// terms and phrases, and for every prefix a regex anchored at a word start
+// in one of fields.
+func textSearchFilter(filter bson.M, q search.Query, fields ...string) bson.M {
+	if text := q.TextSearch(); text != "" {
+		filter["$text"] = bson.M{"$search": text}
+	}
+
+	var and []bson.M
+	for _, prefix := range q.Prefixes {
+		pattern := `(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(prefix)
+		var or []bson.M
+		for _, field := range fields {
+			or = append(or, bson.M{field: bson.M{"$regex": pattern, "$options": "i"}})
+		}
+		and = append(and, bson.M{"$or": or})
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	return filter
+}
+
+func (r *MongoDBRepository) findScored(ctx context.Context, collection string, filter bson.M, results interface{}) error {
+	opts := options.Find().SetLimit(maxSearchCandidates)
+	if _, ok := filter["$text"]; ok {
+		score := bson.M{"score": bson.M{"$meta": "textScore"}}
+		opts.SetProjection(score).SetSort(score)
+	}
+
+	cursor, err := r.db.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+// prefixScore scores the prefix part of q, which textScore does not cover.
+func prefixScore(q search.Query, fields ...search.Field) float64 {
+	if len(q.Prefixes) == 0 {
+		return 0
+	}
+	score, _ := search.Query{Prefixes: q.Prefixes}.Match(fields...)
+	return score
+}
+
+// Trash methods
+func (r *MongoDBRepository) ListTrash(ctx context.Context, userID string) ([]*domain.Book, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := r.db.Collection("books").Find(ctx, bson.M{
+		"user_id":    oids[0],
+		"deleted_at": bson.M{"$ne": nil},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*bookDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	books := make([]*domain.Book, 0, len(docs))
+	for _, doc := range docs {
+		books = append(books, doc.toDomain())
+	}
+	return books, nil
+}
+
+func (r *MongoDBRepository) Restore(ctx context.Context, id, userID string) (*domain.Book, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bookDocument
+	err = r.db.Collection("books").FindOneAndUpdate(ctx, bson.M{
+		"_id":        oids[0],
+		"user_id":    oids[1],
+		"deleted_at": bson.M{"$ne": nil},
+	}, bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$inc":   bson.M{"version": 1},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) Purge(ctx context.Context, id, userID string) error {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return err
+	}
+
+	purged, err := r.purgeBooks(ctx, bson.M{
+		"_id":        oids[0],
+		"user_id":    oids[1],
+		"deleted_at": bson.M{"$ne": nil},
+	})
+	if err != nil {
+		return err
+	}
+	if len(purged) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) EmptyTrash(ctx context.Context, userID string) ([]string, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	return r.purgeBooks(ctx, bson.M{"user_id": oids[0], "deleted_at": bson.M{"$ne": nil}})
+}
+
+func (r *MongoDBRepository) PurgeTrashedBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	return r.purgeBooks(ctx, bson.M{"deleted_at": bson.M{"$ne": nil, "$lt": cutoff}})
+}
+
+// purgeBooks permanently deletes the books matching filter with their
+// notes, history, attachments and reading sessions, and returns their IDs.
+func (r *MongoDBRepository) purgeBooks(ctx context.Context, filter bson.M) ([]string, error) {
+	books := r.db.Collection("books")
+	cursor, err := books.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return []string{}, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(docs))
+	purged := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+		purged[i] = doc.ID.Hex()
+	}
+
+	// Notes, history, attachments and sessions go first so an interrupted purge
+	// never leaves orphans behind
+	_, err = r.db.Collection("shelves").UpdateMany(ctx, bson.M{"books": bson.M{"$in": ids}},
+		bson.M{"$pull": bson.M{"books": bson.M{"$in": ids}}})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"notes", "revisions", "attachments", "uploads", "reading_sessions"} {
+		if _, err := r.db.Collection(name).DeleteMany(ctx, bson.M{"book_id": bson.M{"$in": ids}}); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := books.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": bson.M{"$ne": nil}}); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// Note methods
+func (r *MongoDBRepository) CreateNote(ctx context.Context, note *domain.Note) error {
+	collection := r.db.Collection("notes")
+	note.ID = newID()
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now()
+	}
+	note.UpdatedAt = note.CreatedAt
+	if note.Kind == "" {
+		note.Kind = domain.NoteKindNote
+	}
+	_, err := collection.InsertOne(ctx, newNoteDocument(note))
+	return err
+}
+
+func (r *MongoDBRepository) UpdateNote(ctx context.Context, id, bookID, userID string, update *domain.UpdateNoteRequest) (*domain.Note, error) {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := r.db.Collection("notes")
+
+	set := bson.M{"updated_at": now()}
+	if update.Body != nil {
+		set["body"] = *update.Body
+	}
+	if update.Page != nil {
+		set["page"] = *update.Page
+	}
+	if update.Location != nil {
+		set["location"] = *update.Location
+	}
+
+	var doc noteDocument
+	err = collection.FindOneAndUpdate(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	}, bson.M{"$set": set}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) DeleteNote(ctx context.Context, id, bookID, userID string) error {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return err
+	}
+
+	collection := r.db.Collection("notes")
+	res, err := collection.DeleteOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) GetNoteByID(ctx context.Context, id, bookID, userID string) (*domain.Note, error) {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := r.db.Collection("notes")
+	var doc noteDocument
+	err = collection.FindOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) ListNotes(ctx context.Context, bookID, userID string) ([]*domain.Note, error) {
+	oids, err := objectIDs(bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := r.db.Collection("notes")
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{
+		"book_id": oids[0],
+		"user_id": oids[1],
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*noteDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	notes := []*domain.Note{}
+	for _, doc := range docs {
+		notes = append(notes, doc.toDomain())
+	}
+	return notes, nil
+}
+
+// Revision methods
+func (r *MongoDBRepository) AddRevision(ctx context.Context, rev *domain.Revision, keep int) error {
+	rev.ID = newID()
+	rev.CreatedAt = now()
+
+	collection := r.db.Collection("revisions")
+	doc := newRevisionDocument(rev)
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+
+	// Everything past the newest keep revisions of the book goes
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(keep)).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, bson.M{"book_id": doc.BookID}, opts)
+	if err != nil {
+		return err
+	}
+	var old []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &old); err != nil || len(old) == 0 {
+		return err
+	}
+	ids := make([]primitive.ObjectID, len(old))
+	for i, o := range old {
+		ids[i] = o.ID
+	}
+	_, err = collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (r *MongoDBRepository) ListRevisions(ctx context.Context, bookID, userID string) ([]*domain.Revision, error) {
+	oids, err := objectIDs(bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.db.Collection("revisions").Find(ctx, bson.M{
+		"book_id": oids[0],
+		"user_id": oids[1],
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*revisionDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*domain.Revision, 0, len(docs))
+	for _, doc := range docs {
+		revisions = append(revisions, doc.toDomain())
+	}
+	return revisions, nil
+}
+
+func (r *MongoDBRepository) GetRevision(ctx context.Context, id, bookID, userID string) (*domain.Revision, error) {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc revisionDocument
+	err = r.db.Collection("revisions").FindOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+// Metadata methods
+func (r *MongoDBRepository) GetMetadata(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	// The TTL monitor only runs once a minute, so expiry is checked here too
+	var doc metadataDocument
+	err := r.db.Collection("isbn_metadata").FindOne(ctx, bson.M{
+		"_id":        isbn,
+		"expires_at": bson.M{"$gt": now()},
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) PutMetadata(ctx context.Context, meta *domain.BookMetadata, expiresAt time.Time) error {
+	doc := &metadataDocument{ISBN: meta.ISBN, ExpiresAt: expiresAt, BookMetadata: *meta}
+	_, err := r.db.Collection("isbn_metadata").ReplaceOne(ctx, bson.M{"_id": meta.ISBN}, doc,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+// Attachment methods
+func (r *MongoDBRepository) CreateAttachment(ctx context.Context, attachment *domain.Attachment, quota int64) error {
+	attachment.ID = newID()
+	attachment.CreatedAt = now()
+	return r.reserveStorage(ctx, attachment.UserID, attachment.Size, quota, func() error {
+		_, err := r.db.Collection("attachments").InsertOne(ctx, newAttachmentDocument(attachment))
+		return err
+	})
+}
+
+func (r *MongoDBRepository) ListAttachments(ctx context.Context, bookID, userID string) ([]*domain.Attachment, error) {
+	oids, err := objectIDs(bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.db.Collection("attachments").Find(ctx, bson.M{
+		"book_id": oids[0],
+		"user_id": oids[1],
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*attachmentDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	attachments := []*domain.Attachment{}
+	for _, doc := range docs {
+		attachments = append(attachments, doc.toDomain())
+	}
+	return attachments, nil
+}
+
+func (r *MongoDBRepository) GetAttachment(ctx context.Context, id, bookID, userID string) (*domain.Attachment, error) {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc attachmentDocument
+	err = r.db.Collection("attachments").FindOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) DeleteAttachment(ctx context.Context, id, bookID, userID string) error {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.Collection("attachments").DeleteOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) CreateUpload(ctx context.Context, upload *domain.Upload, quota int64) error {
+	upload.ID = newID()
+	upload.CreatedAt = now()
+	upload.Offset = 0
+	upload.Parts = []string{}
+	return r.reserveStorage(ctx, upload.UserID, upload.Size, quota, func() error {
+		_, err := r.db.Collection("uploads").InsertOne(ctx, newUploadDocument(upload))
+		return err
+	})
+}
+
+// storageLockTTL is how long a storage lock is held at most; a lock left
+// behind by a crashed server is taken over after it.
+const storageLockTTL = 30 * time.Second
+
+// reserveStorage runs insert if size more bytes fit in the user's quota,
+// and fails with ErrQuotaExceeded otherwise. Without transactions the
+// check and the insert are made atomic with a lock document per user in
+// storage_locks, which concurrent reservations of the user wait for.
+func (r *MongoDBRepository) reserveStorage(ctx context.Context, userID string, size, quota int64, insert func() error) error {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return err
+	}
+
+	locks := r.db.Collection("storage_locks")
+	token := newID()
+	for {
+		ts := now()
+		_, err := locks.InsertOne(ctx, bson.M{"_id": oids[0], "token": token, "expires_at": ts.Add(storageLockTTL)})
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if _, err := locks.DeleteOne(ctx, bson.M{"_id": oids[0], "expires_at": bson.M{"$lt": ts}}); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	// The token keeps a reservation that overran its lock from releasing
+	// the next one's
+	defer locks.DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": oids[0], "token": token})
+
+	used, err := r.StorageUsed(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if used > quota-size {
+		return ErrQuotaExceeded
+	}
+	return insert()
+}
+
+func (r *MongoDBRepository) GetUpload(ctx context.Context, id, bookID, userID string) (*domain.Upload, error) {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc uploadDocument
+	err = r.db.Collection("uploads").FindOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) AdvanceUpload(ctx context.Context, id, userID string, from, to int64, part string) (*domain.Upload, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := r.db.Collection("uploads")
+	var doc uploadDocument
+	err = collection.FindOneAndUpdate(ctx, bson.M{
+		"_id":     oids[0],
+		"user_id": oids[1],
+		"offset":  from,
+	}, bson.M{
+		"$set":  bson.M{"offset": to},
+		"$push": bson.M{"parts": part},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		// Tell a missing upload from one at another offset
+		n, err := collection.CountDocuments(ctx, bson.M{"_id": oids[0], "user_id": oids[1]})
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, ErrVersionMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) DeleteUpload(ctx context.Context, id, userID string) error {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.Collection("uploads").DeleteOne(ctx, bson.M{"_id": oids[0], "user_id": oids[1]})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) ExpiredUploads(ctx context.Context, cutoff time.Time) ([]*domain.Upload, error) {
+	cursor, err := r.db.Collection("uploads").Find(ctx, bson.M{"expires_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*uploadDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	uploads := []*domain.Upload{}
+	for _, doc := range docs {
+		uploads = append(uploads, doc.toDomain())
+	}
+	return uploads, nil
+}
+
+func (r *MongoDBRepository) StorageUsed(ctx context.Context, userID string) (int64, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for _, name := range []string{"attachments", "uploads"} {
+		cursor, err := r.db.Collection(name).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"user_id": oids[0]}}},
+			{{Key: "$group", Value: bson.M{"_id": nil, "size": bson.M{"$sum": "$size"}}}},
+		})
+		if err != nil {
+			return 0, err
+		}
+		var sums []struct {
+			Size int64 `bson:"size"`
+		}
+		if err := cursor.All(ctx, &sums); err != nil {
+			return 0, err
+		}
+		if len(sums) > 0 {
+			used += sums[0].Size
+		}
+	}
+	return used, nil
+}
+
+// Reading session methods
+func (r *MongoDBRepository) CreateReadingSession(ctx context.Context, session *domain.ReadingSession) error {
+	session.ID = newID()
+	session.CreatedAt = now()
+	_, err := r.db.Collection("reading_sessions").InsertOne(ctx, newReadingSessionDocument(session))
+	return err
+}
+
+func (r *MongoDBRepository) ListReadingSessions(ctx context.Context, bookID, userID string) ([]*domain.ReadingSession, error) {
+	oids, err := objectIDs(bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.db.Collection("reading_sessions").Find(ctx, bson.M{
+		"book_id": oids[0],
+		"user_id": oids[1],
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*readingSessionDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	sessions := []*domain.ReadingSession{}
+	for _, doc := range docs {
+		sessions = append(sessions, doc.toDomain())
+	}
+	return sessions, nil
+}
+
+func (r *MongoDBRepository) DeleteReadingSession(ctx context.Context, id, bookID, userID string) error {
+	oids, err := objectIDs(id, bookID, userID)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.Collection("reading_sessions").DeleteOne(ctx, bson.M{
+		"_id":     oids[0],
+		"book_id": oids[1],
+		"user_id": oids[2],
+	})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoDBRepository) TimeSpent(ctx context.Context, userID string, bookIDs []string) (map[string]int64, error) {
+	spent := map[string]int64{}
+	oids, err := objectIDs(append([]string{userID}, bookIDs...)...)
+	if err != nil || len(bookIDs) == 0 {
+		return spent, err
+	}
+
+	// Subtracting dates gives milliseconds; each session counts in whole
+	// seconds, as in the other backends
+	seconds := bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$end", "$start"}}, 1000}}}
+	cursor, err := r.db.Collection("reading_sessions").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": oids[0], "book_id": bson.M{"$in": oids[1:]}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$book_id", "seconds": bson.M{"$sum": seconds}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sums []struct {
+		BookID  primitive.ObjectID `bson:"_id"`
+		Seconds float64            `bson:"seconds"`
+	}
+	if err := cursor.All(ctx, &sums); err != nil {
+		return nil, err
+	}
+	for _, sum := range sums {
+		spent[sum.BookID.Hex()] = int64(sum.Seconds)
+	}
+	return spent, nil
+}
+
+// Shelf methods
+func (r *MongoDBRepository) CreateShelf(ctx context.Context, shelf *domain.Shelf) error {
+	shelf.ID = newID()
+	shelf.CreatedAt = now()
+	shelf.UpdatedAt = shelf.CreatedAt
+	shelf.BookIDs = []string{}
+	_, err := r.db.Collection("shelves").InsertOne(ctx, newShelfDocument(shelf))
+	return err
+}
+
+func (r *MongoDBRepository) GetShelf(ctx context.Context, id, userID string) (*domain.Shelf, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc shelfDocument
+	err = r.db.Collection("shelves").FindOne(ctx, bson.M{"_id": oids[0], "user_id": oids[1]}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+func (r *MongoDBRepository) ListShelves(ctx context.Context, userID string) ([]*domain.Shelf, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.db.Collection("shelves").Find(ctx, bson.M{"user_id": oids[0]}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []*shelfDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	shelves := []*domain.Shelf{}
+	for _, doc := range docs {
+		shelves = append(shelves, doc.toDomain())
+	}
+	return shelves, nil
+}
+
+func (r *MongoDBRepository) UpdateShelf(ctx context.Context, id, userID string, update *domain.UpdateShelfRequest) (*domain.Shelf, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": now()}
+	unset := bson.M{}
+	if update.Name != nil {
+		set["name"] = *update.Name
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.ParentID != nil {
+		if *update.ParentID == "" {
+			unset["parent_id"] = ""
+		} else {
+			parent, err := objectIDs(*update.ParentID)
+			if err != nil {
+				return nil, err
+			}
+			set["parent_id"] = parent[0]
+		}
+	}
+	changes := bson.M{"$set": set}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
+	return r.updateShelf(ctx, bson.M{"_id": oids[0], "user_id": oids[1]}, changes)
+}
+
+func (r *MongoDBRepository) DeleteShelf(ctx context.Context, id, userID string) error {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return err
+	}
+
+	shelves := r.db.Collection("shelves")
+	res, err := shelves.DeleteOne(ctx, bson.M{"_id": oids[0], "user_id": oids[1]})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	_, err = shelves.UpdateMany(ctx, bson.M{"parent_id": oids[0], "user_id": oids[1]}, bson.M{"$unset": bson.M{"parent_id": ""}})
+	return err
+}
+
+func (r *MongoDBRepository) PlaceShelfBook(ctx context.Context, id, userID, bookID string, position int) (*domain.Shelf, error) {
+	oids, err := objectIDs(id, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	position = min(position, math.MaxInt32)
+
+	// One pipeline update takes the book out and puts it back at its new
+	// position, so concurrent moves cannot lose it
+	without := bson.M{"$filter": bson.M{"input": "$books", "cond": bson.M{"$ne": bson.A{"$$this", oids[2]}}}}
+	return r.updateShelf(ctx, bson.M{"_id": oids[0], "user_id": oids[1]}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"books": without}}},
+		{{Key: "$set", Value: bson.M{
+			"books": bson.M{"$concatArrays": bson.A{
+				bson.M{"$slice": bson.A{"$books", position}},
+				bson.A{oids[2]},
+				bson.M{"$slice": bson.A{"$books", position, math.MaxInt32}},
+			}},
+			"updated_at": now(),
+		}}},
+	})
+}
+
+func (r *MongoDBRepository) RemoveShelfBook(ctx context.Context, id, userID, bookID string) (*domain.Shelf, error) {
+	oids, err := objectIDs(id, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	return r.updateShelf(ctx, bson.M{"_id": oids[0], "user_id": oids[1], "books": oids[2]}, bson.M{
+		"$pull": bson.M{"books": oids[2]},
+		"$set":  bson.M{"updated_at": now()},
+	})
+}
+
+func (r *MongoDBRepository) ReorderShelf(ctx context.Context, id, userID string, bookIDs []string) (*domain.Shelf, error) {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	books, err := objectIDs(bookIDs...)
+	if err != nil {
+		return nil, domain.ErrShelfOrder
+	}
+
+	// The shelf must hold exactly these books; duplicates are caught by
+	// checking the new order against the shelf first
+	shelf, err := r.GetShelf(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !shelf.SameBooks(bookIDs) {
+		return nil, domain.ErrShelfOrder
+	}
+	shelf, err = r.updateShelf(ctx, bson.M{
+		"_id":     oids[0],
+		"user_id": oids[1],
+		"books":   bson.M{"$size": len(books), "$all": books},
+	}, bson.M{"$set": bson.M{"books": books, "updated_at": now()}})
+	if errors.Is(err, ErrNotFound) {
+		// The shelf changed in the meantime
+		return nil, domain.ErrShelfOrder
+	}
+	return shelf, err
+}
+
+// updateShelf applies update to the shelf matching filter and returns it
+// afterwards, or ErrNotFound when none matches.
+func (r *MongoDBRepository) updateShelf(ctx context.Context, filter bson.M, update any) (*domain.Shelf, error) {
+	var doc shelfDocument
+	err := r.db.Collection("shelves").FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toDomain(), nil
+}
+
+// shelfBookIDs returns the books of a shelf as ObjectIDs, never nil so an
+// empty shelf matches no books.
+func shelfBookIDs(shelf *domain.Shelf) []primitive.ObjectID {
+	oids := make([]primitive.ObjectID, 0, len(shelf.BookIDs))
+	for _, id := range shelf.BookIDs {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			oids = append(oids, oid)
+		}
+	}
+	return oids
+}
+
+// Tag methods
+func (r *MongoDBRepository) ListTags(ctx context.Context, userID, prefix string, limit int) ([]domain.FacetCount, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": oids[0], "deleted_at": nil}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$match", Value: bson.M{"tags": bson.M{
+			"$ne":    "",
+			"$regex": "^" + regexp.QuoteMeta(prefix), "$options": "i",
+		}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "value": "$_id", "count": 1}}},
+	}
+
+	cursor, err := r.db.Collection("books").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tags := []domain.FacetCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Statistics methods
+func (r *MongoDBRepository) ReadingStats(ctx context.Context, userID string, query *domain.StatsQuery) (*domain.ReadingStats, error) {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dates outside the range are never null, so matching the range also
+	// skips books without the date
+	inRange := bson.M{"$ne": nil}
+	if query.From != nil {
+		inRange["$gte"] = query.From.Time
+	}
+	if query.To != nil {
+		inRange["$lt"] = query.To.Time
+	}
+	countBy := func(format, field string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{
+				"_id":   bson.M{"$dateToString": bson.M{"format": format, "date": field}},
+				"count": bson.M{"$sum": 1},
+			}},
+			bson.M{"$sort": bson.M{"_id": 1}},
+		}
+	}
+	top := func(field string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$match": bson.M{"_id": bson.M{"$nin": bson.A{nil, ""}}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": maxTopValues},
+			bson.M{"$project": bson.M{"_id": 0, "value": "$_id", "count": 1}},
+		}
+	}
+	added := bson.M{"$match": bson.M{"created_at": inRange}}
+	finished := bson.M{"$match": bson.M{"finished_at": inRange}}
+
+	cursor, err := r.db.Collection("books").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": oids[0], "deleted_at": nil}}},
+		{{Key: "$facet", Value: bson.M{
+			"added":    append(bson.A{added}, countBy("%Y-%m", "$created_at")...),
+			"finished": append(bson.A{finished}, countBy("%Y", "$finished_at")...),
+			"authors":  append(bson.A{added}, top("$author")...),
+			"tags":     append(bson.A{added, bson.M{"$unwind": "$tags"}}, top("$tags")...),
+			"to_finish": bson.A{
+				finished,
+				bson.M{"$match": bson.M{"started_at": bson.M{"$ne": nil}}},
+				bson.M{"$group": bson.M{
+					"_id":    nil,
+					"millis": bson.M{"$avg": bson.M{"$subtract": bson.A{"$finished_at", "$started_at"}}},
+				}},
+			},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var books []struct {
+		Added    []domain.PeriodCount `bson:"added"`
+		Finished []domain.PeriodCount `bson:"finished"`
+		Authors  []domain.FacetCount  `bson:"authors"`
+		Tags     []domain.FacetCount  `bson:"tags"`
+		ToFinish []struct {
+			Millis float64 `bson:"millis"`
+		} `bson:"to_finish"`
+	}
+	if err := cursor.All(ctx, &books); err != nil {
+		return nil, err
+	}
+
+	// Sessions of books in the trash do not count, like the books
+	cursor, err = r.db.Collection("reading_sessions").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": oids[0], "start": inRange}}},
+		{{Key: "$lookup", Value: bson.M{"from": "books", "localField": "book_id", "foreignField": "_id", "as": "book"}}},
+		{{Key: "$match", Value: bson.M{"book": bson.M{"$elemMatch": bson.M{"deleted_at": nil}}}}},
+		{{Key: "$facet", Value: bson.M{
+			"weeks": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"$dateToString": bson.M{"format": "%G-W%V", "date": "$start"}},
+					"count": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$pages", 0}}},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"days": countBy("%Y-%m-%d", "$start"),
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sessions []struct {
+		Weeks []domain.PeriodCount `bson:"weeks"`
+		Days  []domain.PeriodCount `bson:"days"`
+	}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	stats := &domain.ReadingStats{
+		AddedPerMonth:   []domain.PeriodCount{},
+		FinishedPerYear: []domain.PeriodCount{},
+		TopAuthors:      []domain.FacetCount{},
+		TopTags:         []domain.FacetCount{},
+		PagesPerWeek:    []domain.PeriodCount{},
+	}
+	if len(books) > 0 {
+		b := books[0]
+		stats.AddedPerMonth = append(stats.AddedPerMonth, b.Added...)
+		stats.FinishedPerYear = append(stats.FinishedPerYear, b.Finished...)
+		stats.TopAuthors = append(stats.TopAuthors, b.Authors...)
+		stats.TopTags = append(stats.TopTags, b.Tags...)
+		if len(b.ToFinish) > 0 {
+			average := domain.AverageDays(time.Duration(b.ToFinish[0].Millis) * time.Millisecond)
+			stats.AverageDaysToFinish = &average
+		}
+	}
+	var days []string
+	if len(sessions) > 0 {
+		stats.PagesPerWeek = append(stats.PagesPerWeek, sessions[0].Weeks...)
+		for _, day := range sessions[0].Days {
+			days = append(days, day.Period)
+		}
+	}
+	stats.Streaks = domain.NewReadingStreaks(days, query.LastDay(now()))
+	return stats, nil
+}
+
+// Session methods
+func (r *MongoDBRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	collection := r.db.Collection("sessions")
+	session.ID = newID()
+	session.CreatedAt = now()
+	session.LastUsedAt = session.CreatedAt
+	if session.PreviousHashes == nil {
+		session.PreviousHashes = []string{}
+	}
+	_, err := collection.InsertOne(ctx, newSessionDocument(session))
+	return err
+}
+
+func (r *MongoDBRepository) FindSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	oids, err := objectIDs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := r.db.Collection("sessions")
+	var doc sessionDocument
+	err = collection.FindOne(ctx, bson.M{"_id": oids[0]}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toModel(), nil
+}
+
+func (r *MongoDBRepository) RotateSession(ctx context.Context, oldHash, newHash string, expiresAt time.Time, maxAge time.Duration) (*models.Session, error) {
+	collection := r.db.Collection("sessions")
+	ts := now()
+	active := bson.M{
+		"token_hash": oldHash,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": ts},
+	}
+
+	var current sessionDocument
+	err := collection.FindOne(ctx, active).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Filtering on the token hash again makes a concurrent rotation of the
+	// same token lose the race instead of forking the session.
+	var doc sessionDocument
+	err = collection.FindOneAndUpdate(ctx, active, bson.M{
+		"$set": bson.M{
+			"token_hash":   newHash,
+			"last_used_at": ts,
+			"expires_at":   current.CapExpiry(expiresAt, maxAge),
+		},
+		"$push": bson.M{
+			"previous_hashes": bson.M{"$each": []string{oldHash}, "$slice": -maxPreviousHashes},
+		},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toModel(), nil
+}
+
+func (r *MongoDBRepository) FindSessionByPreviousHash(ctx context.Context, hash string) (*models.Session, error) {
+	collection := r.db.Collection("sessions")
+	var doc sessionDocument
+	err := collection.FindOne(ctx, bson.M{"previous_hashes": hash}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.toModel(), nil
+}
+
+func (r *MongoDBRepository) RevokeSession(ctx context.Context, id, userID string) error {
+	oids, err := objectIDs(id, userID)
+	if err != nil {
+		return nil
+	}
+
+	collection := r.db.Collection("sessions")
+	_, err = collection.UpdateOne(ctx, bson.M{
+		"_id":        oids[0],
+		"user_id":    oids[1],
+		"revoked_at": nil,
+	}, bson.M{"$set": bson.M{"revoked_at": now()}})
+	return err
+}
+
+func (r *MongoDBRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	oids, err := objectIDs(userID)
+	if err != nil {
+		return nil
+	}
+
+	collection := r.db.Collection("sessions")
+	_, err = collection.UpdateMany(ctx, bson.M{
+		"user_id":    oids[0],
+		"revoked_at": nil,
+	}, bson.M{"$set": bson.M{"revoked_at": now()}})
+	return err
 }
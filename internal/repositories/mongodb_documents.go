@@ -0,0 +1,251 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/smartnotes/user-service/internal/models"
+	"github.com/smartnotes/user-service/pkg/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The domain types carry backend-neutral string IDs. In Mongo the IDs are
+// stored as ObjectIDs, so every collection is read and written through a
+// document type that inlines the domain type and adds the ObjectID fields.
+
+type userDocument struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	models.User `bson:",inline"`
+}
+
+func (d *userDocument) toModel() *models.User {
+	user := d.User
+	user.ID = d.ID.Hex()
+	return &user
+}
+
+type bookDocument struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `bson:"user_id"`
+	domain.Book `bson:",inline"`
+}
+
+func newBookDocument(book *domain.Book) *bookDocument {
+	doc := &bookDocument{Book: *book}
+	doc.ID, _ = primitive.ObjectIDFromHex(book.ID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(book.UserID)
+	return doc
+}
+
+func (d *bookDocument) toDomain() *domain.Book {
+	book := d.Book
+	book.ID = d.ID.Hex()
+	book.UserID = d.UserID.Hex()
+	return &book
+}
+
+type noteDocument struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `bson:"user_id"`
+	BookID      primitive.ObjectID `bson:"book_id"`
+	domain.Note `bson:",inline"`
+}
+
+func newNoteDocument(note *domain.Note) *noteDocument {
+	doc := &noteDocument{Note: *note}
+	doc.ID, _ = primitive.ObjectIDFromHex(note.ID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(note.UserID)
+	doc.BookID, _ = primitive.ObjectIDFromHex(note.BookID)
+	return doc
+}
+
+func (d *noteDocument) toDomain() *domain.Note {
+	note := d.Note
+	note.ID = d.ID.Hex()
+	note.UserID = d.UserID.Hex()
+	note.BookID = d.BookID.Hex()
+	// Notes stored before there were highlights have no kind
+	if note.Kind == "" {
+		note.Kind = domain.NoteKindNote
+	}
+	return &note
+}
+
+type sessionDocument struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `bson:"user_id"`
+	models.Session `bson:",inline"`
+}
+
+func newSessionDocument(session *models.Session) *sessionDocument {
+	doc := &sessionDocument{Session: *session}
+	doc.ID, _ = primitive.ObjectIDFromHex(session.ID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(session.UserID)
+	return doc
+}
+
+func (d *sessionDocument) toModel() *models.Session {
+	session := d.Session
+	session.ID = d.ID.Hex()
+	session.UserID = d.UserID.Hex()
+	return &session
+}
+
+// objectIDs parses IDs handed to the repository. An ID that is not a valid
+// ObjectID cannot match any document, so it is reported as ErrNotFound.
+func objectIDs(ids ...string) ([]primitive.ObjectID, error) {
+	oids := make([]primitive.ObjectID, len(ids))
+	for i, id := range ids {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		oids[i] = oid
+	}
+	return oids, nil
+}
+
+type revisionDocument struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	BookID          primitive.ObjectID `bson:"book_id"`
+	UserID          primitive.ObjectID `bson:"user_id"`
+	NoteID          primitive.ObjectID `bson:"note_id,omitempty"`
+	domain.Revision `bson:",inline"`
+}
+
+func newRevisionDocument(rev *domain.Revision) *revisionDocument {
+	doc := &revisionDocument{Revision: *rev}
+	doc.ID, _ = primitive.ObjectIDFromHex(rev.ID)
+	doc.BookID, _ = primitive.ObjectIDFromHex(rev.BookID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(rev.UserID)
+	doc.NoteID, _ = primitive.ObjectIDFromHex(rev.NoteID)
+	return doc
+}
+
+func (d *revisionDocument) toDomain() *domain.Revision {
+	rev := d.Revision
+	rev.ID = d.ID.Hex()
+	rev.BookID = d.BookID.Hex()
+	rev.UserID = d.UserID.Hex()
+	if !d.NoteID.IsZero() {
+		rev.NoteID = d.NoteID.Hex()
+	}
+	return &rev
+}
+
+// metadataDocument is keyed by ISBN so lookups and upserts hit _id; Mongo
+// drops it once expires_at has passed.
+type metadataDocument struct {
+	ISBN                string    `bson:"_id"`
+	ExpiresAt           time.Time `bson:"expires_at"`
+	domain.BookMetadata `bson:",inline"`
+}
+
+func (d *metadataDocument) toDomain() *domain.BookMetadata {
+	meta := d.BookMetadata
+	meta.ISBN = d.ISBN
+	return &meta
+}
+
+type attachmentDocument struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty"`
+	BookID            primitive.ObjectID `bson:"book_id"`
+	UserID            primitive.ObjectID `bson:"user_id"`
+	domain.Attachment `bson:",inline"`
+}
+
+func newAttachmentDocument(attachment *domain.Attachment) *attachmentDocument {
+	doc := &attachmentDocument{Attachment: *attachment}
+	doc.ID, _ = primitive.ObjectIDFromHex(attachment.ID)
+	doc.BookID, _ = primitive.ObjectIDFromHex(attachment.BookID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(attachment.UserID)
+	return doc
+}
+
+func (d *attachmentDocument) toDomain() *domain.Attachment {
+	attachment := d.Attachment
+	attachment.ID = d.ID.Hex()
+	attachment.BookID = d.BookID.Hex()
+	attachment.UserID = d.UserID.Hex()
+	return &attachment
+}
+
+type uploadDocument struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	BookID        primitive.ObjectID `bson:"book_id"`
+	UserID        primitive.ObjectID `bson:"user_id"`
+	domain.Upload `bson:",inline"`
+}
+
+func newUploadDocument(upload *domain.Upload) *uploadDocument {
+	doc := &uploadDocument{Upload: *upload}
+	doc.ID, _ = primitive.ObjectIDFromHex(upload.ID)
+	doc.BookID, _ = primitive.ObjectIDFromHex(upload.BookID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(upload.UserID)
+	return doc
+}
+
+func (d *uploadDocument) toDomain() *domain.Upload {
+	upload := d.Upload
+	upload.ID = d.ID.Hex()
+	upload.BookID = d.BookID.Hex()
+	upload.UserID = d.UserID.Hex()
+	return &upload
+}
+
+type shelfDocument struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty"`
+	UserID       primitive.ObjectID   `bson:"user_id"`
+	ParentID     primitive.ObjectID   `bson:"parent_id,omitempty"`
+	Books        []primitive.ObjectID `bson:"books"`
+	domain.Shelf `bson:",inline"`
+}
+
+func newShelfDocument(shelf *domain.Shelf) *shelfDocument {
+	doc := &shelfDocument{Shelf: *shelf, Books: []primitive.ObjectID{}}
+	doc.ID, _ = primitive.ObjectIDFromHex(shelf.ID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(shelf.UserID)
+	doc.ParentID, _ = primitive.ObjectIDFromHex(shelf.ParentID)
+	for _, id := range shelf.BookIDs {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			doc.Books = append(doc.Books, oid)
+		}
+	}
+	return doc
+}
+
+func (d *shelfDocument) toDomain() *domain.Shelf {
+	shelf := d.Shelf
+	shelf.ID = d.ID.Hex()
+	shelf.UserID = d.UserID.Hex()
+	if !d.ParentID.IsZero() {
+		shelf.ParentID = d.ParentID.Hex()
+	}
+	shelf.BookIDs = make([]string, len(d.Books))
+	for i, oid := range d.Books {
+		shelf.BookIDs[i] = oid.Hex()
+	}
+	return &shelf
+}
+
+type readingSessionDocument struct {
+	ID                    primitive.ObjectID `bson:"_id,omitempty"`
+	BookID                primitive.ObjectID `bson:"book_id"`
+	UserID                primitive.ObjectID `bson:"user_id"`
+	domain.ReadingSession `bson:",inline"`
+}
+
+func newReadingSessionDocument(session *domain.ReadingSession) *readingSessionDocument {
+	doc := &readingSessionDocument{ReadingSession: *session}
+	doc.ID, _ = primitive.ObjectIDFromHex(session.ID)
+	doc.BookID, _ = primitive.ObjectIDFromHex(session.BookID)
+	doc.UserID, _ = primitive.ObjectIDFromHex(session.UserID)
+	return doc
+}
+
+func (d *readingSessionDocument) toDomain() *domain.ReadingSession {
+	session := d.ReadingSession
+	session.ID = d.ID.Hex()
+	session.BookID = d.BookID.Hex()
+	session.UserID = d.UserID.Hex()
+	return &session
+}
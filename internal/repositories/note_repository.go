@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type NoteRepository interface {
+	// CreateNote keeps a CreatedAt that is already set, for notes imported
+	// with their original timestamps.
+	CreateNote(ctx context.Context, note *domain.Note) error
+	UpdateNote(ctx context.Context, id, bookID, userID string, update *domain.UpdateNoteRequest) (*domain.Note, error)
+	DeleteNote(ctx context.Context, id, bookID, userID string) error
+	GetNoteByID(ctx context.Context, id, bookID, userID string) (*domain.Note, error)
+	ListNotes(ctx context.Context, bookID, userID string) ([]*domain.Note, error)
+}
@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// ReadingRepository keeps the log of reading sessions of books.
+type ReadingRepository interface {
+	CreateReadingSession(ctx context.Context, session *domain.ReadingSession) error
+	// ListReadingSessions returns the sessions of a book, earliest first.
+	ListReadingSessions(ctx context.Context, bookID, userID string) ([]*domain.ReadingSession, error)
+	DeleteReadingSession(ctx context.Context, id, bookID, userID string) error
+	// TimeSpent sums the length of the sessions of each of the books, in
+	// seconds. Books without sessions are left out.
+	TimeSpent(ctx context.Context, userID string, bookIDs []string) (map[string]int64, error)
+}
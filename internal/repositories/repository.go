@@ -3,8 +3,6 @@ package repositories
 import (
 	"context"
 
-	"go.mongodb.org/mongo-driver/bson/primitive"
-
 	"github.com/smartnotes/user-service/internal/models"
 	"github.com/smartnotes/user-service/pkg/domain"
 )
@@ -13,13 +11,55 @@ type Repository interface {
 	// User methods
 	CreateUser(user *models.User) error
 	FindUserByEmail(email string) (*models.User, error)
-	FindUserByID(id primitive.ObjectID) (*models.User, error)
+	FindUserByID(id string) (*models.User, error)
 
 	// Book methods
 	Create(ctx context.Context, book *domain.Book) error
-	Update(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, update *domain.Book) error
-	Delete(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) error
-	GetByID(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) (*domain.Book, error)
-	List(ctx context.Context, userID primitive.ObjectID, search, sortBy, order string, limit, offset int) ([]*domain.Book, error)
-	Count(ctx context.Context, userID primitive.ObjectID, search string) (int64, error)
+	// Update sets the fields present in update, bumps the version and
+	// returns the updated book, or ErrNotFound when the user has no such
+	// book. With a non-nil version it only applies to that version and
+	// fails with ErrVersionMismatch otherwise; Delete, which moves the book
+	// to the trash, works the same way.
+	Update(ctx context.Context, id string, userID string, version *int64, update *domain.UpdateBookRequest) (*domain.Book, error)
+	Delete(ctx context.Context, id string, userID string, version *int64) error
+	SetCover(ctx context.Context, id string, userID string, version *int64, cover *domain.Cover) (*domain.Book, error)
+	// WithTransaction runs fn against a repository whose book writes all
+	// take effect if fn returns nil and none of them otherwise.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context, books BookRepository) error) error
+	GetByID(ctx context.Context, id string, userID string) (*domain.Book, error)
+	List(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.Book, error)
+	Count(ctx context.Context, userID string, query *domain.ListBooksQuery) (int64, error)
+	Facets(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.BookFacets, error)
+	RatingStats(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.RatingStats, error)
+	Search(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.BookSearchResult, int64, error)
+
+	// Note methods
+	NoteRepository
+
+	// Session methods
+	SessionRepository
+
+	// Trash methods
+	TrashRepository
+
+	// Revision methods
+	RevisionRepository
+
+	// Metadata cache methods
+	MetadataRepository
+
+	// Attachment methods
+	AttachmentRepository
+
+	// Reading session methods
+	ReadingRepository
+
+	// Shelf methods
+	ShelfRepository
+
+	// Tag methods
+	TagRepository
+
+	// Statistics methods
+	StatsRepository
 }
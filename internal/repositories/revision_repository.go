@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+type RevisionRepository interface {
+	// AddRevision appends rev to its book's history and then drops the
+	// oldest revisions of that book beyond the newest keep.
+	AddRevision(ctx context.Context, rev *domain.Revision, keep int) error
+	// ListRevisions returns a book's history, newest first.
+	ListRevisions(ctx context.Context, bookID, userID string) ([]*domain.Revision, error)
+	GetRevision(ctx context.Context, id, bookID, userID string) (*domain.Revision, error)
+}
@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/smartnotes/user-service/internal/search"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// Search field weights. The Mongo text index is created with the same
+// weights so rankings are comparable across backends.
+const (
+	titleSearchWeight       = 10
+	authorSearchWeight      = 5
+	tagsSearchWeight        = 5
+	descriptionSearchWeight = 2
+	noteSearchWeight        = 1
+)
+
+// maxSearchCandidates caps how many matches a search ranks and pages through.
+const maxSearchCandidates = 1000
+
+func bookSearchFields(book *domain.Book) []search.Field {
+	return []search.Field{
+		{Name: "title", Text: book.Title, Weight: titleSearchWeight},
+		{Name: "author", Text: book.Author, Weight: authorSearchWeight},
+		{Name: "tags", Text: strings.Join(book.Tags, ", "), Weight: tagsSearchWeight},
+		{Name: "description", Text: book.Description, Weight: descriptionSearchWeight},
+	}
+}
+
+// scoreBook matches a book and its notes against q in process. A book is a
+// hit when its own fields or any one of its notes satisfy the query.
+func scoreBook(q search.Query, book *domain.Book, notes []*domain.Note) (*domain.BookSearchResult, bool) {
+	score, hit := q.Match(bookSearchFields(book)...)
+
+	var matched []*domain.Note
+	for _, note := range notes {
+		if s, ok := q.Match(search.Field{Name: "notes", Text: note.Body, Weight: noteSearchWeight}); ok {
+			score += s
+			hit = true
+			matched = append(matched, note)
+		}
+	}
+	if !hit {
+		return nil, false
+	}
+	return newBookSearchResult(q, book, score, matched), true
+}
+
+func newBookSearchResult(q search.Query, book *domain.Book, score float64, notes []*domain.Note) *domain.BookSearchResult {
+	result := &domain.BookSearchResult{Book: *book, Score: score, Highlights: map[string]string{}}
+	for _, f := range bookSearchFields(book) {
+		if snippet, ok := q.Highlight(f.Text); ok {
+			result.Highlights[f.Name] = snippet
+		}
+	}
+	for _, note := range notes {
+		if snippet, ok := q.Highlight(note.Body); ok {
+			result.Highlights["notes"] = snippet
+			break
+		}
+	}
+	return result
+}
+
+// rankResults orders results by descending score, newest first on ties,
+// and returns the requested page along with the total number of results.
+func rankResults(results []*domain.BookSearchResult, limit, offset int) ([]*domain.BookSearchResult, int64) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+	if len(results) > maxSearchCandidates {
+		results = results[:maxSearchCandidates]
+	}
+
+	total := int64(len(results))
+	if offset >= len(results) {
+		return []*domain.BookSearchResult{}, total
+	}
+	results = results[offset:]
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results, total
+}
@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/models"
+)
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	FindSessionByID(ctx context.Context, id string) (*models.Session, error)
+	// RotateSession atomically swaps the refresh token of the active session
+	// holding oldHash for newHash. The new expiry is capped at maxAge after
+	// the session was created, so refreshing cannot keep a session alive
+	// forever. It returns ErrNotFound when no active session holds oldHash.
+	RotateSession(ctx context.Context, oldHash, newHash string, expiresAt time.Time, maxAge time.Duration) (*models.Session, error)
+	// FindSessionByPreviousHash looks up the session a refresh token was
+	// rotated away from; a hit means the token is being reused.
+	FindSessionByPreviousHash(ctx context.Context, hash string) (*models.Session, error)
+	RevokeSession(ctx context.Context, id, userID string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+}
+
+// maxPreviousHashes bounds how many rotated-away refresh tokens a session
+// remembers for reuse detection.
+const maxPreviousHashes = 50
@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/models"
+)
+
+func TestRotateSessionStopsAtMaxAge(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	defer func(saved func() time.Time) { now = saved }(now)
+	now = func() time.Time { return clock }
+
+	sqlite, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, repo := range map[string]Repository{
+		"memory": NewMemoryRepository(),
+		"sqlite": sqlite,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := &models.User{Username: "alice", Email: "alice@example.com", Role: "user"}
+			if err := repo.CreateUser(user); err != nil {
+				t.Fatal(err)
+			}
+
+			const maxAge = 24 * time.Hour
+			session := &models.Session{UserID: user.ID, TokenHash: "first", ExpiresAt: clock.Add(time.Hour)}
+			if err := repo.CreateSession(ctx, session); err != nil {
+				t.Fatal(err)
+			}
+
+			clock = clock.Add(30 * time.Minute)
+			rotated, err := repo.RotateSession(ctx, "first", "second", clock.Add(maxAge), maxAge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := session.CreatedAt.Add(maxAge); !rotated.ExpiresAt.Equal(want) {
+				t.Errorf("rotated session expires at %v, want %v", rotated.ExpiresAt, want)
+			}
+
+			clock = session.CreatedAt.Add(maxAge)
+			if _, err := repo.RotateSession(ctx, "second", "third", clock.Add(maxAge), maxAge); !errors.Is(err, ErrNotFound) {
+				t.Errorf("rotating at the maximum age: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
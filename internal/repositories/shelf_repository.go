@@ -0,0 +1,30 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// ShelfRepository keeps the user's shelves and the order of the books on
+// them. It does not check that books exist; handlers do.
+type ShelfRepository interface {
+	CreateShelf(ctx context.Context, shelf *domain.Shelf) error
+	GetShelf(ctx context.Context, id, userID string) (*domain.Shelf, error)
+	// ListShelves returns the user's shelves in the order they were created.
+	ListShelves(ctx context.Context, userID string) ([]*domain.Shelf, error)
+	UpdateShelf(ctx context.Context, id, userID string, update *domain.UpdateShelfRequest) (*domain.Shelf, error)
+	// DeleteShelf deletes a shelf and moves its shelves to the top level.
+	// The books on it are left alone.
+	DeleteShelf(ctx context.Context, id, userID string) error
+	// PlaceShelfBook puts a book on a shelf at position, or moves it there
+	// when it is on the shelf already. A position past the end puts it last.
+	PlaceShelfBook(ctx context.Context, id, userID, bookID string, position int) (*domain.Shelf, error)
+	// RemoveShelfBook takes a book off a shelf; ErrNotFound means either
+	// is missing.
+	RemoveShelfBook(ctx context.Context, id, userID, bookID string) (*domain.Shelf, error)
+	// ReorderShelf puts the books of a shelf in the order of bookIDs, which
+	// must list each of them once; it fails with domain.ErrShelfOrder
+	// otherwise.
+	ReorderShelf(ctx context.Context, id, userID string, bookIDs []string) (*domain.Shelf, error)
+}
@@ -0,0 +1,1777 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smartnotes/user-service/internal/models"
+	"github.com/smartnotes/user-service/internal/search"
+	"github.com/smartnotes/user-service/pkg/domain"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+var _ Repository = (*SQLRepository)(nil)
+
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+)
+
+// SQLRepository is a Repository backed by PostgreSQL or SQLite. Queries are
+// written once with ? placeholders and rebound for the active dialect.
+type SQLRepository struct {
+	db      *sql.DB
+	dialect string
+	// tx is set on the copies WithTransaction hands out
+	tx *sql.Tx
+}
+
+// NewPostgresRepository connects to a PostgreSQL database given as a
+// postgres:// URL and brings its schema up to date.
+func NewPostgresRepository(url string) (*SQLRepository, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLRepository(db, dialectPostgres)
+}
+
+// NewSQLiteRepository opens (creating if needed) the SQLite database at path
+// and brings its schema up to date. ":memory:" gives a throwaway database.
+func NewSQLiteRepository(path string) (*SQLRepository, error) {
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&"
+	} else {
+		dsn += "?"
+	}
+	dsn += "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection also keeps an in-memory
+	// database alive and shared.
+	db.SetMaxOpenConns(1)
+	return newSQLRepository(db, dialectSQLite)
+}
+
+func newSQLRepository(db *sql.DB, dialect string) (*SQLRepository, error) {
+	repo := &SQLRepository{db: db, dialect: dialect}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := repo.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := repo.normalizeStoredTags(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (r *SQLRepository) exec(ctx context.Context, ex sqlExecutor, query string, args ...any) (sql.Result, error) {
+	return ex.ExecContext(ctx, r.rebind(query), args...)
+}
+
+func (r *SQLRepository) query(ctx context.Context, ex sqlExecutor, query string, args ...any) (*sql.Rows, error) {
+	return ex.QueryContext(ctx, r.rebind(query), args...)
+}
+
+func (r *SQLRepository) queryRow(ctx context.Context, ex sqlExecutor, query string, args ...any) *sql.Row {
+	return ex.QueryRowContext(ctx, r.rebind(query), args...)
+}
+
+// rebind rewrites ? placeholders into PostgreSQL's $n form.
+func (r *SQLRepository) rebind(query string) string {
+	if r.dialect != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// conn returns the transaction the repository is bound to, if any, and the
+// connection pool otherwise.
+func (r *SQLRepository) conn() sqlExecutor {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// inTx runs fn in a transaction, committing when it returns nil. Inside a
+// bound transaction fn simply joins it.
+func (r *SQLRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// limitClause renders LIMIT/OFFSET with the same meaning as Mongo's
+// SetLimit/SetSkip: a limit of 0 means no limit.
+func (r *SQLRepository) limitClause(limit, offset int) (string, []any) {
+	var clause string
+	var args []any
+	if limit > 0 {
+		clause += " LIMIT ?"
+		args = append(args, limit)
+	} else if offset > 0 && r.dialect == dialectSQLite {
+		// SQLite only accepts OFFSET after a LIMIT
+		clause += " LIMIT -1"
+	}
+	if offset > 0 {
+		clause += " OFFSET ?"
+		args = append(args, offset)
+	}
+	return clause, args
+}
+
+// placeholders returns "?, ?, ..." with n placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// likePattern turns free text into a case-insensitive substring LIKE pattern.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
+
+// prefixPattern is likePattern for values starting with s.
+func prefixPattern(s string) string {
+	return strings.TrimPrefix(likePattern(s), "%")
+}
+
+func nullableInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
+
+func isNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+// User methods
+func (r *SQLRepository) CreateUser(user *models.User) error {
+	ctx := context.Background()
+
+	var exists int
+	err := r.queryRow(ctx, r.conn(), `SELECT 1 FROM users WHERE email = ? OR username = ?`, user.Email, user.Username).Scan(&exists)
+	if err == nil {
+		return errors.New("user already exists")
+	}
+	if !isNoRows(err) {
+		return err
+	}
+
+	user.ID = newID()
+	user.CreatedAt = now()
+	user.Role = "user"
+
+	_, err = r.exec(ctx, r.conn(), `INSERT INTO users (id, username, email, password, role, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Username, user.Email, user.Password, user.Role, user.CreatedAt)
+	return err
+}
+
+func (r *SQLRepository) FindUserByEmail(email string) (*models.User, error) {
+	return r.findUser(context.Background(), `email = ?`, email)
+}
+
+func (r *SQLRepository) FindUserByID(id string) (*models.User, error) {
+	return r.findUser(context.Background(), `id = ?`, id)
+}
+
+func (r *SQLRepository) findUser(ctx context.Context, where string, arg any) (*models.User, error) {
+	var user models.User
+	err := r.queryRow(ctx, r.conn(), `SELECT id, username, email, password, role, created_at FROM users WHERE `+where, arg).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role, &user.CreatedAt)
+	if isNoRows(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Book methods
+const bookColumns = `id, user_id, title, author, description, created_at, updated_at, version, deleted_at, isbn, publisher, year, edition, pages, cover_url, cover, status, current_page, progress, started_at, finished_at, rating, review`
+
+// bookSortColumns maps the sort fields accepted by the API to columns.
+var bookSortColumns = map[string]string{
+	"title":      "books.title",
+	"author":     "books.author",
+	"created_at": "books.created_at",
+	"updated_at": "books.updated_at",
+	// Unrated books sort as the lowest rating
+	"rating": "COALESCE(books.rating, 0)",
+	// Only for shelf listings, which join shelf_books
+	"position": "shelf_books.position",
+}
+
+func sqlDirection(key domain.SortKey) string {
+	if key.Desc {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+func (r *SQLRepository) Create(ctx context.Context, book *domain.Book) error {
+	book.ID = newID()
+	// Imports keep the date a book was added
+	book.UpdatedAt = now()
+	if book.CreatedAt.IsZero() {
+		book.CreatedAt = book.UpdatedAt
+	}
+	book.Version = 1
+	cover, err := coverColumn(book.Cover)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := r.exec(ctx, tx, `INSERT INTO books (`+bookColumns+`) VALUES (`+placeholders(23)+`)`,
+			book.ID, book.UserID, book.Title, book.Author, book.Description, book.CreatedAt, book.UpdatedAt, book.Version, book.DeletedAt,
+			book.ISBN, book.Publisher, book.Year, book.Edition, book.Pages, book.CoverURL, cover,
+			book.Status, book.CurrentPage, book.Progress, book.StartedAt, book.FinishedAt,
+			book.Rating, book.Review)
+		if err != nil {
+			return err
+		}
+		return r.replaceTags(ctx, tx, book.ID, book.Tags)
+	})
+}
+
+func (r *SQLRepository) Update(ctx context.Context, id string, userID string, version *int64, update *domain.UpdateBookRequest) (*domain.Book, error) {
+	set := []string{`updated_at = ?`, `version = version + 1`}
+	args := []any{now()}
+	if update.Title != nil {
+		set = append(set, `title = ?`)
+		args = append(args, *update.Title)
+	}
+	if update.Author != nil {
+		set = append(set, `author = ?`)
+		args = append(args, *update.Author)
+	}
+	if update.Description != nil {
+		set = append(set, `description = ?`)
+		args = append(args, *update.Description)
+	}
+	if update.ISBN != nil {
+		set = append(set, `isbn = ?`)
+		args = append(args, *update.ISBN)
+	}
+	if update.Publisher != nil {
+		set = append(set, `publisher = ?`)
+		args = append(args, *update.Publisher)
+	}
+	if update.Year != nil {
+		set = append(set, `year = ?`)
+		args = append(args, optionalInt(*update.Year))
+	}
+	if update.Edition != nil {
+		set = append(set, `edition = ?`)
+		args = append(args, *update.Edition)
+	}
+	if update.Pages != nil {
+		set = append(set, `pages = ?`)
+		args = append(args, optionalInt(*update.Pages))
+	}
+	if update.CoverURL != nil {
+		set = append(set, `cover_url = ?`)
+		args = append(args, *update.CoverURL)
+	}
+	if update.Status != nil {
+		set = append(set, `status = ?`)
+		args = append(args, *update.Status)
+	}
+	if update.CurrentPage != nil {
+		set = append(set, `current_page = ?`)
+		args = append(args, optionalInt(*update.CurrentPage))
+	}
+	if update.Progress != nil {
+		set = append(set, `progress = ?`)
+		args = append(args, optionalFloat(*update.Progress))
+	}
+	if update.StartedAt != nil {
+		set = append(set, `started_at = ?`)
+		args = append(args, optionalTime(*update.StartedAt))
+	}
+	if update.FinishedAt != nil {
+		set = append(set, `finished_at = ?`)
+		args = append(args, optionalTime(*update.FinishedAt))
+	}
+	if update.Rating != nil {
+		set = append(set, `rating = ?`)
+		args = append(args, optionalFloat(*update.Rating))
+	}
+	if update.Review != nil {
+		set = append(set, `review = ?`)
+		args = append(args, *update.Review)
+	}
+	where, whereArgs := bookVersionWhere(id, userID, version)
+	args = append(args, whereArgs...)
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := r.exec(ctx, tx, `UPDATE books SET `+strings.Join(set, ", ")+` WHERE `+where, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return r.bookMissOrConflict(ctx, tx, id, userID)
+		}
+		if update.Tags == nil {
+			return nil
+		}
+		return r.replaceTags(ctx, tx, id, *update.Tags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(ctx, id, userID)
+}
+
+func (r *SQLRepository) SetCover(ctx context.Context, id string, userID string, version *int64, cover *domain.Cover) (*domain.Book, error) {
+	value, err := coverColumn(cover)
+	if err != nil {
+		return nil, err
+	}
+	where, args := bookVersionWhere(id, userID, version)
+
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := r.exec(ctx, tx, `UPDATE books SET cover = ?, updated_at = ?, version = version + 1 WHERE `+where,
+			append([]any{value, now()}, args...)...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return r.bookMissOrConflict(ctx, tx, id, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(ctx, id, userID)
+}
+
+// coverColumn stores a cover as JSON, or NULL when there is none.
+func coverColumn(cover *domain.Cover) (any, error) {
+	if cover == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(cover)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (r *SQLRepository) Delete(ctx context.Context, id string, userID string, version *int64) error {
+	where, args := bookVersionWhere(id, userID, version)
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := r.exec(ctx, tx, `UPDATE books SET deleted_at = ?, version = version + 1 WHERE `+where,
+			append([]any{now()}, args...)...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return r.bookMissOrConflict(ctx, tx, id, userID)
+		}
+		return nil
+	})
+}
+
+// bookVersionWhere matches the user's book unless it is in the trash, and
+// with a non-nil version only that version of it.
+func bookVersionWhere(id, userID string, version *int64) (string, []any) {
+	if version == nil {
+		return `id = ? AND user_id = ? AND deleted_at IS NULL`, []any{id, userID}
+	}
+	return `id = ? AND user_id = ? AND deleted_at IS NULL AND version = ?`, []any{id, userID, *version}
+}
+
+// bookMissOrConflict explains why a conditional write matched nothing.
+func (r *SQLRepository) bookMissOrConflict(ctx context.Context, tx *sql.Tx, id, userID string) error {
+	var exists int
+	err := r.queryRow(ctx, tx, `SELECT 1 FROM books WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID).Scan(&exists)
+	if isNoRows(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+func (r *SQLRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context, books BookRepository) error) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		bound := *r
+		bound.tx = tx
+		return fn(ctx, &bound)
+	})
+}
+
+func (r *SQLRepository) GetByID(ctx context.Context, id string, userID string) (*domain.Book, error) {
+	books, err := r.queryBooks(ctx, `SELECT `+bookColumns+` FROM books WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, ErrNotFound
+	}
+	return books[0], nil
+}
+
+func (r *SQLRepository) List(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.Book, error) {
+	limit, offset := query.Limit, query.Offset
+	if offset < 0 {
+		return nil, errors.New("skip must be non-negative")
+	}
+	if limit < 0 {
+		limit = -limit
+	}
+
+	where, args := r.bookFilter(userID, query)
+	from := `books`
+	if query.Shelf != nil {
+		from += ` JOIN shelf_books ON shelf_books.book_id = books.id AND shelf_books.shelf_id = ?`
+		args = append([]any{query.Shelf.ID}, args...)
+	}
+
+	var order []string
+	for _, key := range query.SortKeys {
+		order = append(order, bookSortColumns[key.Field]+sqlDirection(key))
+	}
+	order = append(order, "books.id"+sqlDirection(query.SortKeys[len(query.SortKeys)-1]))
+
+	// Keyset pagination: continue past the cursor on the first sort key
+	// that differs, with the id as last key
+	if cursor := query.Cursor; cursor != nil {
+		past := func(key domain.SortKey) string {
+			if key.Desc {
+				return ` < ?`
+			}
+			return ` > ?`
+		}
+
+		var branches []string
+		var equal string
+		var equalArgs []any
+		for i, key := range query.SortKeys {
+			column := bookSortColumns[key.Field]
+			branches = append(branches, `(`+equal+column+past(key)+`)`)
+			args = append(args, append(equalArgs, cursor.Values[i])...)
+			equal += column + ` = ? AND `
+			equalArgs = append(equalArgs, cursor.Values[i])
+		}
+		branches = append(branches, `(`+equal+`books.id`+past(query.SortKeys[len(query.SortKeys)-1])+`)`)
+		args = append(args, append(equalArgs, cursor.ID)...)
+		where += ` AND (` + strings.Join(branches, ` OR `) + `)`
+	}
+
+	limitSQL, limitArgs := r.limitClause(limit, offset)
+	stmt := `SELECT ` + bookColumns + ` FROM ` + from + ` WHERE ` + where +
+		` ORDER BY ` + strings.Join(order, ", ") + limitSQL
+
+	return r.queryBooks(ctx, stmt, append(args, limitArgs...)...)
+}
+
+func (r *SQLRepository) Count(ctx context.Context, userID string, query *domain.ListBooksQuery) (int64, error) {
+	where, args := r.bookFilter(userID, query)
+
+	var total int64
+	err := r.queryRow(ctx, r.conn(), `SELECT COUNT(*) FROM books WHERE `+where, args...).Scan(&total)
+	return total, err
+}
+
+func (r *SQLRepository) Facets(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.BookFacets, error) {
+	where, args := r.bookFilter(userID, query)
+
+	tags, err := r.queryFacet(ctx, `SELECT bt.tag, COUNT(*) FROM book_tags bt JOIN books ON books.id = bt.book_id
+		WHERE `+where+` AND bt.tag <> '' GROUP BY bt.tag ORDER BY COUNT(*) DESC, bt.tag LIMIT ?`,
+		append(args, maxFacetValues)...)
+	if err != nil {
+		return nil, err
+	}
+	authors, err := r.queryFacet(ctx, `SELECT author, COUNT(*) FROM books
+		WHERE `+where+` AND author <> '' GROUP BY author ORDER BY COUNT(*) DESC, author LIMIT ?`,
+		append(args, maxFacetValues)...)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.BookFacets{Tags: tags, Authors: authors}, nil
+}
+
+func (r *SQLRepository) RatingStats(ctx context.Context, userID string, query *domain.ListBooksQuery) (*domain.RatingStats, error) {
+	where, args := r.bookFilter(userID, query)
+	rows, err := r.query(ctx, r.conn(), `SELECT COALESCE(books.rating, 0), COUNT(*) FROM books WHERE `+where+` GROUP BY COALESCE(books.rating, 0)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[float64]int64{}
+	for rows.Next() {
+		var rating float64
+		var count int64
+		if err := rows.Scan(&rating, &count); err != nil {
+			return nil, err
+		}
+		counts[rating] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return domain.NewRatingStats(counts), nil
+}
+
+func (r *SQLRepository) queryFacet(ctx context.Context, query string, args ...any) ([]domain.FacetCount, error) {
+	rows, err := r.query(ctx, r.conn(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := []domain.FacetCount{}
+	for rows.Next() {
+		var facet domain.FacetCount
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			return nil, err
+		}
+		facets = append(facets, facet)
+	}
+	return facets, rows.Err()
+}
+
+// Search pre-selects the user's books whose fields, tags or notes contain
+// any of the query's words and ranks them in process.
+func (r *SQLRepository) Search(ctx context.Context, userID string, query *domain.ListBooksQuery) ([]*domain.BookSearchResult, int64, error) {
+	q := search.Parse(query.Query)
+	if q.Empty() {
+		return []*domain.BookSearchResult{}, 0, nil
+	}
+
+	var bookConds, noteConds []string
+	var bookArgs, noteArgs []any
+	for _, needle := range q.Needles() {
+		pattern := likePattern(needle)
+		bookConds = append(bookConds, `LOWER(title) LIKE ? ESCAPE '\' OR LOWER(author) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM book_tags t WHERE t.book_id = books.id AND LOWER(t.tag) LIKE ? ESCAPE '\')
+			OR EXISTS (SELECT 1 FROM notes n WHERE n.book_id = books.id AND LOWER(n.body) LIKE ? ESCAPE '\')`)
+		bookArgs = append(bookArgs, pattern, pattern, pattern, pattern, pattern)
+		noteConds = append(noteConds, `LOWER(body) LIKE ? ESCAPE '\'`)
+		noteArgs = append(noteArgs, pattern)
+	}
+
+	where, args := r.bookFilter(userID, query)
+	books, err := r.queryBooks(ctx, `SELECT `+bookColumns+` FROM books WHERE `+where+` AND (`+strings.Join(bookConds, " OR ")+`)`,
+		append(args, bookArgs...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	notes, err := r.queryNotes(ctx, `SELECT `+noteColumns+` FROM notes WHERE user_id = ? AND (`+strings.Join(noteConds, " OR ")+`)`,
+		append([]any{userID}, noteArgs...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	notesByBook := map[string][]*domain.Note{}
+	for _, n := range notes {
+		notesByBook[n.BookID] = append(notesByBook[n.BookID], n)
+	}
+
+	var results []*domain.BookSearchResult
+	for _, book := range books {
+		if result, ok := scoreBook(q, book, notesByBook[book.ID]); ok {
+			results = append(results, result)
+		}
+	}
+
+	page, total := rankResults(results, query.Limit, query.Offset)
+	return page, total, nil
+}
+
+// bookFilter translates the structured filters of query, except the
+// full-text query, into a WHERE clause over the owner's books.
+func (r *SQLRepository) bookFilter(userID string, query *domain.ListBooksQuery) (string, []any) {
+	conds := []string{`books.user_id = ?`, `books.deleted_at IS NULL`}
+	args := []any{userID}
+	if query.Search != "" {
+		pattern := likePattern(query.Search)
+		conds = append(conds, `(LOWER(books.title) LIKE ? ESCAPE '\' OR LOWER(books.description) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	if len(query.Tags) > 0 {
+		const hasTag = `EXISTS (SELECT 1 FROM book_tags t WHERE t.book_id = books.id AND t.tag `
+		if query.TagMode == domain.TagModeAll {
+			for _, tag := range query.Tags {
+				conds = append(conds, hasTag+`= ?)`)
+				args = append(args, tag)
+			}
+		} else {
+			conds = append(conds, hasTag+`IN (`+placeholders(len(query.Tags))+`))`)
+			for _, tag := range query.Tags {
+				args = append(args, tag)
+			}
+		}
+	}
+	if query.Shelf != nil {
+		conds = append(conds, `books.id IN (SELECT book_id FROM shelf_books WHERE shelf_id = ?)`)
+		args = append(args, query.Shelf.ID)
+	}
+	if query.Author != "" {
+		conds = append(conds, `LOWER(books.author) = LOWER(?)`)
+		args = append(args, query.Author)
+	}
+	if len(query.Statuses) > 0 {
+		conds = append(conds, `books.status IN (`+placeholders(len(query.Statuses))+`)`)
+		for _, status := range query.Statuses {
+			if status == domain.StatusNone {
+				status = ""
+			}
+			args = append(args, status)
+		}
+	}
+	if query.RatingMin != nil {
+		conds = append(conds, `books.rating >= ?`)
+		args = append(args, *query.RatingMin)
+	}
+	if query.RatingMax != nil {
+		conds = append(conds, `books.rating <= ?`)
+		args = append(args, *query.RatingMax)
+	}
+	if query.CreatedAfter != nil {
+		conds = append(conds, `books.created_at >= ?`)
+		args = append(args, query.CreatedAfter.Time)
+	}
+	if query.CreatedBefore != nil {
+		conds = append(conds, `books.created_at < ?`)
+		args = append(args, query.CreatedBefore.Time)
+	}
+	if query.UpdatedSince != nil {
+		conds = append(conds, `books.updated_at >= ?`)
+		args = append(args, query.UpdatedSince.Time)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// queryBooks runs a SELECT of bookColumns and attaches each book's tags.
+func (r *SQLRepository) queryBooks(ctx context.Context, query string, args ...any) ([]*domain.Book, error) {
+	rows, err := r.query(ctx, r.conn(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var books []*domain.Book
+	for rows.Next() {
+		var book domain.Book
+		var year, pages, page sql.NullInt64
+		var progress, rating sql.NullFloat64
+		var cover sql.NullString
+		if err := rows.Scan(&book.ID, &book.UserID, &book.Title, &book.Author, &book.Description, &book.CreatedAt, &book.UpdatedAt, &book.Version, &book.DeletedAt,
+			&book.ISBN, &book.Publisher, &year, &book.Edition, &pages, &book.CoverURL, &cover,
+			&book.Status, &page, &progress, &book.StartedAt, &book.FinishedAt,
+			&rating, &book.Review); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		book.Year = nullableInt(year)
+		book.Pages = nullableInt(pages)
+		book.CurrentPage = nullableInt(page)
+		if progress.Valid {
+			book.Progress = &progress.Float64
+		}
+		if rating.Valid {
+			book.Rating = &rating.Float64
+		}
+		if cover.Valid {
+			if err := json.Unmarshal([]byte(cover.String), &book.Cover); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		books = append(books, &book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := r.loadTags(ctx, books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (r *SQLRepository) loadTags(ctx context.Context, books []*domain.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*domain.Book, len(books))
+	args := make([]any, len(books))
+	for i, book := range books {
+		byID[book.ID] = book
+		args[i] = book.ID
+	}
+
+	rows, err := r.query(ctx, r.conn(), `SELECT book_id, tag FROM book_tags WHERE book_id IN (`+placeholders(len(args))+`) ORDER BY book_id, position`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID, tag string
+		if err := rows.Scan(&bookID, &tag); err != nil {
+			return err
+		}
+		book := byID[bookID]
+		book.Tags = append(book.Tags, tag)
+	}
+	return rows.Err()
+}
+
+func (r *SQLRepository) replaceTags(ctx context.Context, tx *sql.Tx, bookID string, tags []string) error {
+	if _, err := r.exec(ctx, tx, `DELETE FROM book_tags WHERE book_id = ?`, bookID); err != nil {
+		return err
+	}
+	for i, tag := range tags {
+		if _, err := r.exec(ctx, tx, `INSERT INTO book_tags (book_id, position, tag) VALUES (?, ?, ?)`, bookID, i, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Trash methods
+func (r *SQLRepository) ListTrash(ctx context.Context, userID string) ([]*domain.Book, error) {
+	books, err := r.queryBooks(ctx, `SELECT `+bookColumns+` FROM books WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC`, userID)
+	if books == nil && err == nil {
+		books = []*domain.Book{}
+	}
+	return books, err
+}
+
+func (r *SQLRepository) Restore(ctx context.Context, id, userID string) (*domain.Book, error) {
+	res, err := r.exec(ctx, r.conn(), `UPDATE books SET deleted_at = NULL, version = version + 1
+		WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	return r.GetByID(ctx, id, userID)
+}
+
+func (r *SQLRepository) Purge(ctx context.Context, id, userID string) error {
+	purged, err := r.purgeBooks(ctx, `id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if len(purged) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepository) EmptyTrash(ctx context.Context, userID string) ([]string, error) {
+	return r.purgeBooks(ctx, `user_id = ?`, userID)
+}
+
+func (r *SQLRepository) PurgeTrashedBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	return r.purgeBooks(ctx, `deleted_at < ?`, cutoff.UTC())
+}
+
+// purgeBooks permanently deletes the trashed books matching where along
+// with their notes, tags, history and attachments, and returns their IDs.
+func (r *SQLRepository) purgeBooks(ctx context.Context, where string, args ...any) ([]string, error) {
+	purged := []string{}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := r.query(ctx, tx, `SELECT id FROM books WHERE deleted_at IS NOT NULL AND `+where, args...)
+		if err != nil {
+			return err
+		}
+		var ids []any
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			purged = append(purged, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+
+		in := `(` + placeholders(len(ids)) + `)`
+		if err := r.takeOffShelves(ctx, tx, in, ids); err != nil {
+			return err
+		}
+		for _, table := range []string{"notes", "book_tags", "book_revisions", "attachments", "uploads", "reading_sessions"} {
+			if _, err := r.exec(ctx, tx, `DELETE FROM `+table+` WHERE book_id IN `+in, ids...); err != nil {
+				return err
+			}
+		}
+		_, err = r.exec(ctx, tx, `DELETE FROM books WHERE id IN `+in, ids...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// Note methods
+const noteColumns = `id, user_id, book_id, body, page, location, created_at, updated_at, kind`
+
+func (r *SQLRepository) CreateNote(ctx context.Context, note *domain.Note) error {
+	note.ID = newID()
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now()
+	}
+	note.UpdatedAt = note.CreatedAt
+	if note.Kind == "" {
+		note.Kind = domain.NoteKindNote
+	}
+
+	_, err := r.exec(ctx, r.conn(), `INSERT INTO notes (`+noteColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		note.ID, note.UserID, note.BookID, note.Body, note.Page, note.Location, note.CreatedAt, note.UpdatedAt, note.Kind)
+	return err
+}
+
+func (r *SQLRepository) UpdateNote(ctx context.Context, id, bookID, userID string, update *domain.UpdateNoteRequest) (*domain.Note, error) {
+	set := []string{`updated_at = ?`}
+	args := []any{now()}
+	if update.Body != nil {
+		set = append(set, `body = ?`)
+		args = append(args, *update.Body)
+	}
+	if update.Page != nil {
+		set = append(set, `page = ?`)
+		args = append(args, *update.Page)
+	}
+	if update.Location != nil {
+		set = append(set, `location = ?`)
+		args = append(args, *update.Location)
+	}
+	args = append(args, id, bookID, userID)
+
+	res, err := r.exec(ctx, r.conn(), `UPDATE notes SET `+strings.Join(set, ", ")+` WHERE id = ? AND book_id = ? AND user_id = ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	return r.GetNoteByID(ctx, id, bookID, userID)
+}
+
+func (r *SQLRepository) DeleteNote(ctx context.Context, id, bookID, userID string) error {
+	res, err := r.exec(ctx, r.conn(), `DELETE FROM notes WHERE id = ? AND book_id = ? AND user_id = ?`, id, bookID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepository) GetNoteByID(ctx context.Context, id, bookID, userID string) (*domain.Note, error) {
+	notes, err := r.queryNotes(ctx, `SELECT `+noteColumns+` FROM notes WHERE id = ? AND book_id = ? AND user_id = ?`, id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, ErrNotFound
+	}
+	return notes[0], nil
+}
+
+func (r *SQLRepository) ListNotes(ctx context.Context, bookID, userID string) ([]*domain.Note, error) {
+	return r.queryNotes(ctx, `SELECT `+noteColumns+` FROM notes WHERE book_id = ? AND user_id = ? ORDER BY created_at, id`, bookID, userID)
+}
+
+func (r *SQLRepository) queryNotes(ctx context.Context, query string, args ...any) ([]*domain.Note, error) {
+	rows, err := r.query(ctx, r.conn(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*domain.Note{}
+	for rows.Next() {
+		var note domain.Note
+		var page sql.NullInt64
+		if err := rows.Scan(&note.ID, &note.UserID, &note.BookID, &note.Body, &page, &note.Location, &note.CreatedAt, &note.UpdatedAt, &note.Kind); err != nil {
+			return nil, err
+		}
+		note.Page = nullableInt(page)
+		notes = append(notes, &note)
+	}
+	return notes, rows.Err()
+}
+
+// Revision methods
+const revisionColumns = `id, book_id, user_id, note_id, action, changes, book, version, reverted_to, created_at`
+
+func (r *SQLRepository) AddRevision(ctx context.Context, rev *domain.Revision, keep int) error {
+	rev.ID = newID()
+	rev.CreatedAt = now()
+
+	changes, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return err
+	}
+	book, err := json.Marshal(rev.Book)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := r.exec(ctx, tx, `INSERT INTO book_revisions (`+revisionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rev.ID, rev.BookID, rev.UserID, rev.NoteID, rev.Action, string(changes), string(book), rev.Version, rev.RevertedTo, rev.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		// Everything past the newest keep revisions of the book goes
+		_, err = r.exec(ctx, tx, `DELETE FROM book_revisions WHERE book_id = ? AND id NOT IN (
+			SELECT id FROM book_revisions WHERE book_id = ? ORDER BY created_at DESC, id DESC LIMIT ?)`,
+			rev.BookID, rev.BookID, keep)
+		return err
+	})
+}
+
+func (r *SQLRepository) ListRevisions(ctx context.Context, bookID, userID string) ([]*domain.Revision, error) {
+	return r.queryRevisions(ctx, `SELECT `+revisionColumns+` FROM book_revisions WHERE book_id = ? AND user_id = ?
+		ORDER BY created_at DESC, id DESC`, bookID, userID)
+}
+
+func (r *SQLRepository) GetRevision(ctx context.Context, id, bookID, userID string) (*domain.Revision, error) {
+	revisions, err := r.queryRevisions(ctx, `SELECT `+revisionColumns+` FROM book_revisions WHERE id = ? AND book_id = ? AND user_id = ?`,
+		id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+	return revisions[0], nil
+}
+
+func (r *SQLRepository) queryRevisions(ctx context.Context, query string, args ...any) ([]*domain.Revision, error) {
+	rows, err := r.query(ctx, r.conn(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*domain.Revision{}
+	for rows.Next() {
+		var rev domain.Revision
+		var changes, book string
+		if err := rows.Scan(&rev.ID, &rev.BookID, &rev.UserID, &rev.NoteID, &rev.Action, &changes, &book,
+			&rev.Version, &rev.RevertedTo, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &rev.Changes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(book), &rev.Book); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &rev)
+	}
+	return revisions, rows.Err()
+}
+
+// Metadata methods
+func (r *SQLRepository) GetMetadata(ctx context.Context, isbn string) (*domain.BookMetadata, error) {
+	var data string
+	err := r.queryRow(ctx, r.conn(), `SELECT data FROM isbn_metadata WHERE isbn = ? AND expires_at > ?`, isbn, now()).Scan(&data)
+	if isNoRows(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta domain.BookMetadata
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (r *SQLRepository) PutMetadata(ctx context.Context, meta *domain.BookMetadata, expiresAt time.Time) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := r.exec(ctx, tx, `DELETE FROM isbn_metadata WHERE expires_at <= ?`, now()); err != nil {
+			return err
+		}
+		_, err := r.exec(ctx, tx, `INSERT INTO isbn_metadata (isbn, data, expires_at) VALUES (?, ?, ?)
+			ON CONFLICT (isbn) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`,
+			meta.ISBN, string(data), expiresAt)
+		return err
+	})
+}
+
+// Attachment methods
+const attachmentColumns = `id, book_id, user_id, file_name, content_type, size, sha256, blob_key, metadata, created_at`
+
+func (r *SQLRepository) CreateAttachment(ctx context.Context, attachment *domain.Attachment, quota int64) error {
+	var metadata any
+	if attachment.Metadata != nil {
+		data, err := json.Marshal(attachment.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(data)
+	}
+	attachment.ID = newID()
+	attachment.CreatedAt = now()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.reserveStorage(ctx, tx, attachment.UserID, attachment.Size, quota); err != nil {
+			return err
+		}
+		_, err := r.exec(ctx, tx, `INSERT INTO attachments (`+attachmentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			attachment.ID, attachment.BookID, attachment.UserID, attachment.FileName, attachment.ContentType,
+			attachment.Size, attachment.SHA256, attachment.Key, metadata, attachment.CreatedAt)
+		return err
+	})
+}
+
+func (r *SQLRepository) ListAttachments(ctx context.Context, bookID, userID string) ([]*domain.Attachment, error) {
+	return r.queryAttachments(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE book_id = ? AND user_id = ? ORDER BY created_at, id`, bookID, userID)
+}
+
+func (r *SQLRepository) GetAttachment(ctx context.Context, id, bookID, userID string) (*domain.Attachment, error) {
+	attachments, err := r.queryAttachments(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = ? AND book_id = ? AND user_id = ?`, id, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, ErrNotFound
+	}
+	return attachments[0], nil
+}
+
+func (r *SQLRepository) DeleteAttachment(ctx context.Context, id, bookID, userID string) error {
+	res, err := r.exec(ctx, r.conn(), `DELETE FROM attachments WHERE id = ? AND book_id = ? AND user_id = ?`, id, bookID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepository) queryAttachments(ctx context.Context, query string, args ...any) ([]*domain.Attachment, error) {
+	rows, err := r.query(ctx, r.conn(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*domain.Attachment{}
+	for rows.Next() {
+		var a domain.Attachment
+		var metadata sql.NullString
+		if err := rows.Scan(&a.ID, &a.BookID, &a.UserID, &a.FileName, &a.ContentType, &a.Size, &a.SHA256, &a.Key, &metadata, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if metadata.Valid {
+			a.Metadata = &domain.BookMetadata{}
+			if err := json.Unmarshal([]byte(metadata.String), a.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		attachments = append(attachments, &a)
+	}
+	return attachments, rows.Err()
+}
+
+const uploadColumns = `id, book_id, user_id, file_name, size, received, prefill, parts, created_at, expires_at`
+
+func (r *SQLRepository) CreateUpload(ctx context.Context, upload *domain.Upload, quota int64) error {
+	upload.ID = newID()
+	upload.CreatedAt = now()
+	upload.ExpiresAt = upload.ExpiresAt.UTC()
+	upload.Offset = 0
+	upload.Parts = []string{}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.reserveStorage(ctx, tx, upload.UserID, upload.Size, quota); err != nil {
+			return err
+		}
+		_, err := r.exec(ctx, tx, `INSERT INTO uploads (`+uploadColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			upload.ID, upload.BookID, upload.UserID, upload.FileName, upload.Size, upload.Offset, upload.Prefill, `[]`,
+			upload.CreatedAt, upload.ExpiresAt)
+		return err
+	})
+}
+
+func (r *SQLRepository) GetUpload(ctx context.Context, id, bookID, userID string) (*domain.Upload, error) {
+	return r.findUpload(ctx, r.conn(), `SELECT `+uploadColumns+` FROM uploads WHERE id = ? AND book_id = ? AND user_id = ?`, id, bookID, userID)
+}
+
+func (r *SQLRepository) AdvanceUpload(ctx context.Context, id, userID string, from, to int64, part string) (*domain.Upload, error) {
+	var upload *domain.Upload
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		upload, err = r.findUpload(ctx, tx, `SELECT `+uploadColumns+` FROM uploads WHERE id = ? AND user_id = ?`, id, userID)
+		if err != nil {
+			return err
+		}
+		upload.Parts = append(upload.Parts, part)
+		parts, err := json.Marshal(upload.Parts)
+		if err != nil {
+			return err
+		}
+
+		// The received condition makes a chunk racing for the same offset
+		// lose instead of interleaving
+		res, err := r.exec(ctx, tx, `UPDATE uploads SET received = ?, parts = ? WHERE id = ? AND received = ?`,
+			to, string(parts), id, from)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrVersionMismatch
+		}
+		upload.Offset = to
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (r *SQLRepository) DeleteUpload(ctx context.Context, id, userID string) error {
+	res, err := r.exec(ctx, r.conn(), `DELETE FROM uploads WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepository) ExpiredUploads(ctx context.Context, cutoff time.Time) ([]*domain.Upload, error) {
+	rows, err := r.query(ctx, r.conn(), `SELECT `+uploadColumns+` FROM uploads WHERE expires_at < ?`, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []*domain.Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (r *SQLRepository) StorageUsed(ctx context.Context, userID string) (int64, error) {
+	return r.storageUsed(ctx, r.conn(), userID)
+}
+
+// reserveStorage fails with ErrQuotaExceeded unless size more bytes fit in
+// the user's quota. On PostgreSQL it locks the user's row until tx ends, so
+// concurrent reservations of one user take turns; SQLite has a single
+// writer anyway.
+func (r *SQLRepository) reserveStorage(ctx context.Context, tx *sql.Tx, userID string, size, quota int64) error {
+	if r.dialect == dialectPostgres {
+		if _, err := r.exec(ctx, tx, `SELECT id FROM users WHERE id = ? FOR UPDATE`, userID); err != nil {
+			return err
+		}
+	}
+	used, err := r.storageUsed(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if used > quota-size {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (r *SQLRepository) storageUsed(ctx context.Context, ex sqlExecutor, userID string) (int64, error) {
+	var used int64
+	err := r.queryRow(ctx, ex, `SELECT
+		(SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?) +
+		(SELECT COALESCE(SUM(size), 0) FROM uploads WHERE user_id = ?)`, userID, userID).Scan(&used)
+	return used, err
+}
+
+func (r *SQLRepository) findUpload(ctx context.Context, ex sqlExecutor, query string, args ...any) (*domain.Upload, error) {
+	upload, err := scanUpload(r.queryRow(ctx, ex, query, args...))
+	if isNoRows(err) {
+		return nil, ErrNotFound
+	}
+	return upload, err
+}
+
+// scanUpload reads a row of uploadColumns from a *sql.Row or *sql.Rows.
+func scanUpload(row interface{ Scan(...any) error }) (*domain.Upload, error) {
+	var upload domain.Upload
+	var parts string
+	err := row.Scan(&upload.ID, &upload.BookID, &upload.UserID, &upload.FileName,
+		&upload.Size, &upload.Offset, &upload.Prefill, &parts, &upload.CreatedAt, &upload.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(parts), &upload.Parts); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Reading session methods
+const readingSessionColumns = `id, book_id, user_id, started_at, ended_at, pages, created_at`
+
+func (r *SQLRepository) CreateReadingSession(ctx context.Context, session *domain.ReadingSession) error {
+	session.ID = newID()
+	session.CreatedAt = now()
+	_, err := r.exec(ctx, r.conn(), `INSERT INTO reading_sessions (`+readingSessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.BookID, session.UserID, session.Start, session.End, session.Pages, session.CreatedAt)
+	return err
+}
+
+func (r *SQLRepository) ListReadingSessions(ctx context.Context, bookID, userID string) ([]*domain.ReadingSession, error) {
+	return r.queryReadingSessions(ctx, `SELECT `+readingSessionColumns+` FROM reading_sessions WHERE book_id = ? AND user_id = ? ORDER BY started_at, id`, bookID, userID)
+}
+
+func (r *SQLRepository) queryReadingSessions(ctx context.Context, query string, args ...any) ([]*domain.ReadingSession, error) {
+	rows, err := r.query(ctx, r.conn(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*domain.ReadingSession{}
+	for rows.Next() {
+		var s domain.ReadingSession
+		var pages sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.BookID, &s.UserID, &s.Start, &s.End, &pages, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.Pages = nullableInt(pages)
+		sessions = append(sessions, &s)
+	}
+	return sessions, rows.Err()
+}
+
+func (r *SQLRepository) DeleteReadingSession(ctx context.Context, id, bookID, userID string) error {
+	res, err := r.exec(ctx, r.conn(), `DELETE FROM reading_sessions WHERE id = ? AND book_id = ? AND user_id = ?`, id, bookID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepository) TimeSpent(ctx context.Context, userID string, bookIDs []string) (map[string]int64, error) {
+	spent := map[string]int64{}
+	if len(bookIDs) == 0 {
+		return spent, nil
+	}
+	args := []any{userID}
+	for _, id := range bookIDs {
+		args = append(args, id)
+	}
+
+	// Durations are summed here, as SQLite and Postgres subtract
+	// timestamps differently
+	rows, err := r.query(ctx, r.conn(), `SELECT book_id, started_at, ended_at FROM reading_sessions
+		WHERE user_id = ? AND book_id IN (`+placeholders(len(bookIDs))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s domain.ReadingSession
+		if err := rows.Scan(&s.BookID, &s.Start, &s.End); err != nil {
+			return nil, err
+		}
+		spent[s.BookID] += s.Duration()
+	}
+	return spent, rows.Err()
+}
+
+// Shelf methods
+const shelfColumns = `id, user_id, parent_id, name, description, created_at, updated_at`
+
+func (r *SQLRepository) CreateShelf(ctx context.Context, shelf *domain.Shelf) error {
+	shelf.ID = newID()
+	shelf.CreatedAt = now()
+	shelf.UpdatedAt = shelf.CreatedAt
+	shelf.BookIDs = []string{}
+	_, err := r.exec(ctx, r.conn(), `INSERT INTO shelves (`+shelfColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		shelf.ID, shelf.UserID, parentColumn(shelf.ParentID), shelf.Name, shelf.Description, shelf.CreatedAt, shelf.UpdatedAt)
+	return err
+}
+
+func (r *SQLRepository) GetShelf(ctx context.Context, id, userID string) (*domain.Shelf, error) {
+	return r.getShelf(ctx, r.conn(), id, userID)
+}
+
+func (r *SQLRepository) ListShelves(ctx context.Context, userID string) ([]*domain.Shelf, error) {
+	return r.queryShelves(ctx, r.conn(), `SELECT `+shelfColumns+` FROM shelves WHERE user_id = ? ORDER BY created_at, id`, userID)
+}
+
+func (r *SQLRepository) UpdateShelf(ctx context.Context, id, userID string, update *domain.UpdateShelfRequest) (*domain.Shelf, error) {
+	set := []string{`updated_at = ?`}
+	args := []any{now()}
+	if update.Name != nil {
+		set = append(set, `name = ?`)
+		args = append(args, *update.Name)
+	}
+	if update.Description != nil {
+		set = append(set, `description = ?`)
+		args = append(args, *update.Description)
+	}
+	if update.ParentID != nil {
+		set = append(set, `parent_id = ?`)
+		args = append(args, parentColumn(*update.ParentID))
+	}
+
+	var shelf *domain.Shelf
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := r.exec(ctx, tx, `UPDATE shelves SET `+strings.Join(set, ", ")+` WHERE id = ? AND user_id = ?`, append(args, id, userID)...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		shelf, err = r.getShelf(ctx, tx, id, userID)
+		return err
+	})
+	return shelf, err
+}
+
+func (r *SQLRepository) DeleteShelf(ctx context.Context, id, userID string) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := r.getShelf(ctx, tx, id, userID); err != nil {
+			return err
+		}
+		if _, err := r.exec(ctx, tx, `UPDATE shelves SET parent_id = NULL WHERE parent_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := r.exec(ctx, tx, `DELETE FROM shelf_books WHERE shelf_id = ?`, id); err != nil {
+			return err
+		}
+		_, err := r.exec(ctx, tx, `DELETE FROM shelves WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (r *SQLRepository) PlaceShelfBook(ctx context.Context, id, userID, bookID string, position int) (*domain.Shelf, error) {
+	return r.arrangeShelf(ctx, id, userID, func(books []string) ([]string, error) {
+		books = slices.DeleteFunc(books, func(id string) bool { return id == bookID })
+		return slices.Insert(books, min(position, len(books)), bookID), nil
+	})
+}
+
+func (r *SQLRepository) RemoveShelfBook(ctx context.Context, id, userID, bookID string) (*domain.Shelf, error) {
+	return r.arrangeShelf(ctx, id, userID, func(books []string) ([]string, error) {
+		if !slices.Contains(books, bookID) {
+			return nil, ErrNotFound
+		}
+		return slices.DeleteFunc(books, func(id string) bool { return id == bookID }), nil
+	})
+}
+
+func (r *SQLRepository) ReorderShelf(ctx context.Context, id, userID string, bookIDs []string) (*domain.Shelf, error) {
+	return r.arrangeShelf(ctx, id, userID, func(books []string) ([]string, error) {
+		shelf := &domain.Shelf{BookIDs: books}
+		if !shelf.SameBooks(bookIDs) {
+			return nil, domain.ErrShelfOrder
+		}
+		return bookIDs, nil
+	})
+}
+
+// arrangeShelf replaces the books of a shelf with what arrange makes of
+// them and returns the shelf afterwards.
+func (r *SQLRepository) arrangeShelf(ctx context.Context, id, userID string, arrange func([]string) ([]string, error)) (*domain.Shelf, error) {
+	var shelf *domain.Shelf
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := r.getShelf(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+		books, err := arrange(current.BookIDs)
+		if err != nil {
+			return err
+		}
+		if err := r.replaceShelfBooks(ctx, tx, id, books); err != nil {
+			return err
+		}
+		if _, err := r.exec(ctx, tx, `UPDATE shelves SET updated_at = ? WHERE id = ?`, now(), id); err != nil {
+			return err
+		}
+		shelf, err = r.getShelf(ctx, tx, id, userID)
+		return err
+	})
+	return shelf, err
+}
+
+func (r *SQLRepository) replaceShelfBooks(ctx context.Context, tx *sql.Tx, shelfID string, bookIDs []string) error {
+	if _, err := r.exec(ctx, tx, `DELETE FROM shelf_books WHERE shelf_id = ?`, shelfID); err != nil {
+		return err
+	}
+	for i, bookID := range bookIDs {
+		if _, err := r.exec(ctx, tx, `INSERT INTO shelf_books (shelf_id, book_id, position) VALUES (?, ?, ?)`, shelfID, bookID, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeOffShelves removes the books whose IDs are listed in the IN clause
+// in from every shelf, closing the gaps they leave.
+func (r *SQLRepository) takeOffShelves(ctx context.Context, tx *sql.Tx, in string, ids []any) error {
+	rows, err := r.query(ctx, tx, `SELECT DISTINCT shelf_id FROM shelf_books WHERE book_id IN `+in, ids...)
+	if err != nil {
+		return err
+	}
+	var shelves []string
+	for rows.Next() {
+		var shelfID string
+		if err := rows.Scan(&shelfID); err != nil {
+			rows.Close()
+			return err
+		}
+		shelves = append(shelves, shelfID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := r.exec(ctx, tx, `DELETE FROM shelf_books WHERE book_id IN `+in, ids...); err != nil {
+		return err
+	}
+	for _, shelfID := range shelves {
+		books, err := r.shelfBookIDs(ctx, tx, shelfID)
+		if err != nil {
+			return err
+		}
+		if err := r.replaceShelfBooks(ctx, tx, shelfID, books); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) getShelf(ctx context.Context, ex sqlExecutor, id, userID string) (*domain.Shelf, error) {
+	shelves, err := r.queryShelves(ctx, ex, `SELECT `+shelfColumns+` FROM shelves WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(shelves) == 0 {
+		return nil, ErrNotFound
+	}
+	return shelves[0], nil
+}
+
+// queryShelves runs a SELECT of shelfColumns and attaches the books of
+// each shelf.
+func (r *SQLRepository) queryShelves(ctx context.Context, ex sqlExecutor, query string, args ...any) ([]*domain.Shelf, error) {
+	rows, err := r.query(ctx, ex, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	shelves := []*domain.Shelf{}
+	for rows.Next() {
+		var shelf domain.Shelf
+		var parentID sql.NullString
+		if err := rows.Scan(&shelf.ID, &shelf.UserID, &parentID, &shelf.Name, &shelf.Description, &shelf.CreatedAt, &shelf.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		shelf.ParentID = parentID.String
+		shelves = append(shelves, &shelf)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(shelves) == 0 {
+		return shelves, nil
+	}
+	byID := make(map[string]*domain.Shelf, len(shelves))
+	ids := make([]any, len(shelves))
+	for i, shelf := range shelves {
+		shelf.BookIDs = []string{}
+		byID[shelf.ID] = shelf
+		ids[i] = shelf.ID
+	}
+	rows, err = r.query(ctx, ex, `SELECT shelf_id, book_id FROM shelf_books WHERE shelf_id IN (`+placeholders(len(ids))+`) ORDER BY shelf_id, position`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shelfID, bookID string
+		if err := rows.Scan(&shelfID, &bookID); err != nil {
+			return nil, err
+		}
+		shelf := byID[shelfID]
+		shelf.BookIDs = append(shelf.BookIDs, bookID)
+	}
+	return shelves, rows.Err()
+}
+
+func (r *SQLRepository) shelfBookIDs(ctx context.Context, ex sqlExecutor, shelfID string) ([]string, error) {
+	rows, err := r.query(ctx, ex, `SELECT book_id FROM shelf_books WHERE shelf_id = ? ORDER BY position`, shelfID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// parentColumn stores a missing parent shelf as NULL, as the foreign key
+// requires.
+func parentColumn(id string) any {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// Tag methods
+func (r *SQLRepository) ListTags(ctx context.Context, userID, prefix string, limit int) ([]domain.FacetCount, error) {
+	return r.queryFacet(ctx, `SELECT bt.tag, COUNT(*) FROM book_tags bt JOIN books ON books.id = bt.book_id
+		WHERE books.user_id = ? AND books.deleted_at IS NULL AND bt.tag <> '' AND LOWER(bt.tag) LIKE ? ESCAPE '\'
+		GROUP BY bt.tag ORDER BY COUNT(*) DESC, bt.tag LIMIT ?`,
+		userID, prefixPattern(prefix), limit)
+}
+
+// Statistics methods
+
+// ReadingStats tallies in process, as SQLite and Postgres format dates and
+// weeks differently.
+func (r *SQLRepository) ReadingStats(ctx context.Context, userID string, query *domain.StatsQuery) (*domain.ReadingStats, error) {
+	books, err := r.List(ctx, userID, &domain.ListBooksQuery{SortKeys: domain.DefaultSort})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions of books in the trash do not count, like the books
+	where := "user_id = ? AND book_id IN (SELECT id FROM books WHERE user_id = ? AND deleted_at IS NULL)"
+	args := []any{userID, userID}
+	if query.From != nil {
+		where += " AND started_at >= ?"
+		args = append(args, query.From.Time)
+	}
+	if query.To != nil {
+		where += " AND started_at < ?"
+		args = append(args, query.To.Time)
+	}
+	sessions, err := r.queryReadingSessions(ctx, `SELECT `+readingSessionColumns+` FROM reading_sessions WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	return tallyReadingStats(books, sessions, query), nil
+}
+
+// Session methods
+const sessionColumns = `id, user_id, token_hash, created_at, last_used_at, expires_at, revoked_at`
+
+func (r *SQLRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	session.ID = newID()
+	session.CreatedAt = now()
+	session.LastUsedAt = session.CreatedAt
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	if session.PreviousHashes == nil {
+		session.PreviousHashes = []string{}
+	}
+
+	_, err := r.exec(ctx, r.conn(), `INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.TokenHash, session.CreatedAt, session.LastUsedAt, session.ExpiresAt, session.RevokedAt)
+	return err
+}
+
+func (r *SQLRepository) FindSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	return r.findSession(ctx, r.conn(), `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id)
+}
+
+func (r *SQLRepository) RotateSession(ctx context.Context, oldHash, newHash string, expiresAt time.Time, maxAge time.Duration) (*models.Session, error) {
+	var session *models.Session
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		ts := now()
+
+		current, err := r.findSession(ctx, tx, `SELECT `+sessionColumns+` FROM sessions WHERE token_hash = ?`, oldHash)
+		if err != nil {
+			return err
+		}
+
+		// The token_hash condition makes a concurrent rotation of the same
+		// token lose the race instead of forking the session.
+		res, err := r.exec(ctx, tx, `UPDATE sessions SET token_hash = ?, last_used_at = ?, expires_at = ?
+			WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?`,
+			newHash, ts, current.CapExpiry(expiresAt, maxAge).UTC(), oldHash, ts)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+
+		session, err = r.findSession(ctx, tx, `SELECT `+sessionColumns+` FROM sessions WHERE token_hash = ?`, newHash)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.exec(ctx, tx, `INSERT INTO session_previous_tokens (token_hash, session_id, rotated_at) VALUES (?, ?, ?)`, oldHash, session.ID, ts)
+		if err != nil {
+			return err
+		}
+		_, err = r.exec(ctx, tx, `DELETE FROM session_previous_tokens WHERE session_id = ? AND token_hash NOT IN (
+			SELECT token_hash FROM session_previous_tokens WHERE session_id = ? ORDER BY rotated_at DESC LIMIT ?)`,
+			session.ID, session.ID, maxPreviousHashes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *SQLRepository) FindSessionByPreviousHash(ctx context.Context, hash string) (*models.Session, error) {
+	return r.findSession(ctx, r.conn(), `SELECT `+sessionColumns+` FROM sessions
+		WHERE id = (SELECT session_id FROM session_previous_tokens WHERE token_hash = ?)`, hash)
+}
+
+func (r *SQLRepository) RevokeSession(ctx context.Context, id, userID string) error {
+	_, err := r.exec(ctx, r.conn(), `UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, now(), id, userID)
+	return err
+}
+
+func (r *SQLRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	_, err := r.exec(ctx, r.conn(), `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now(), userID)
+	return err
+}
+
+func (r *SQLRepository) findSession(ctx context.Context, ex sqlExecutor, query string, args ...any) (*models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := r.queryRow(ctx, ex, query, args...).Scan(&session.ID, &session.UserID, &session.TokenHash,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &revokedAt)
+	if isNoRows(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	session.PreviousHashes = []string{}
+	return &session, nil
+}
@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrate applies every embedded migration that has not been recorded in
+// schema_migrations yet, each in its own transaction, in file name order.
+func (r *SQLRepository) migrate(ctx context.Context) error {
+	_, err := r.exec(ctx, r.db, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied := map[string]bool{}
+	rows, err := r.query(ctx, r.db, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+		if applied[version] {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return err
+		}
+
+		err = r.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+				return err
+			}
+			_, err := r.exec(ctx, tx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, now())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// normalizeStoredTags rewrites the tags of books written before tags were
+// normalized, as SQL cannot fold them the same way. Each book gets a new
+// version; once every tag is normalized it only reads the distinct tags.
+func (r *SQLRepository) normalizeStoredTags(ctx context.Context) error {
+	tags, err := r.queryStrings(ctx, r.db, `SELECT DISTINCT tag FROM book_tags`)
+	if err != nil {
+		return err
+	}
+	var stale []any
+	for _, tag := range tags {
+		if domain.NormalizeTag(tag) != tag {
+			stale = append(stale, tag)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	bookIDs, err := r.queryStrings(ctx, r.db, `SELECT DISTINCT book_id FROM book_tags WHERE tag IN (`+placeholders(len(stale))+`)`, stale...)
+	if err != nil {
+		return err
+	}
+	for _, id := range bookIDs {
+		err := r.inTx(ctx, func(tx *sql.Tx) error {
+			tags, err := r.queryStrings(ctx, tx, `SELECT tag FROM book_tags WHERE book_id = ? ORDER BY position`, id)
+			if err != nil {
+				return err
+			}
+			if err := r.replaceTags(ctx, tx, id, domain.NormalizeTags(tags)); err != nil {
+				return err
+			}
+			_, err = r.exec(ctx, tx, `UPDATE books SET version = version + 1 WHERE id = ?`, id)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("normalizing the tags of book %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// queryStrings returns the single text column of every row.
+func (r *SQLRepository) queryStrings(ctx context.Context, ex sqlExecutor, query string, args ...any) ([]string, error) {
+	rows, err := r.query(ctx, ex, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"sort"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// maxTopValues caps the top authors and tags of the reading statistics.
+const maxTopValues = 10
+
+// tallyReadingStats computes the reading statistics in process from the
+// user's books and reading sessions, the way the MongoDB backend does with
+// aggregation pipelines.
+func tallyReadingStats(books []*domain.Book, sessions []*domain.ReadingSession, query *domain.StatsQuery) *domain.ReadingStats {
+	added := map[string]int64{}
+	finished := map[string]int64{}
+	authors := map[string]int64{}
+	tags := map[string]int64{}
+	var toFinish time.Duration
+	var timed int
+	for _, b := range books {
+		if query.Contains(b.CreatedAt) {
+			added[b.CreatedAt.UTC().Format(domain.MonthPeriod)]++
+			if b.Author != "" {
+				authors[b.Author]++
+			}
+			for _, tag := range b.Tags {
+				if tag != "" {
+					tags[tag]++
+				}
+			}
+		}
+		if b.FinishedAt != nil && query.Contains(*b.FinishedAt) {
+			finished[b.FinishedAt.UTC().Format(domain.YearPeriod)]++
+			if b.StartedAt != nil {
+				toFinish += b.FinishedAt.Sub(*b.StartedAt)
+				timed++
+			}
+		}
+	}
+
+	pages := map[string]int64{}
+	days := map[string]int64{}
+	for _, s := range sessions {
+		if !query.Contains(s.Start) {
+			continue
+		}
+		// Weeks with sessions but no pages logged still show up, with 0
+		var read int64
+		if s.Pages != nil {
+			read = int64(*s.Pages)
+		}
+		pages[domain.WeekPeriod(s.Start)] += read
+		days[s.Start.UTC().Format(domain.DayPeriod)]++
+	}
+	readDays := periodCounts(days)
+	dayPeriods := make([]string, len(readDays))
+	for i, day := range readDays {
+		dayPeriods[i] = day.Period
+	}
+
+	stats := &domain.ReadingStats{
+		AddedPerMonth:   periodCounts(added),
+		FinishedPerYear: periodCounts(finished),
+		TopAuthors:      topFacets(authors, maxTopValues),
+		TopTags:         topFacets(tags, maxTopValues),
+		PagesPerWeek:    periodCounts(pages),
+		Streaks:         domain.NewReadingStreaks(dayPeriods, query.LastDay(now())),
+	}
+	if timed > 0 {
+		average := domain.AverageDays(toFinish / time.Duration(timed))
+		stats.AverageDaysToFinish = &average
+	}
+	return stats
+}
+
+// periodCounts orders counts by period.
+func periodCounts(counts map[string]int64) []domain.PeriodCount {
+	periods := make([]domain.PeriodCount, 0, len(counts))
+	for period, count := range counts {
+		periods = append(periods, domain.PeriodCount{Period: period, Count: count})
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Period < periods[j].Period })
+	return periods
+}
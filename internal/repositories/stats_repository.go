@@ -0,0 +1,13 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// StatsRepository computes the reading dashboard over the user's books,
+// leaving out those in the trash, and their reading sessions.
+type StatsRepository interface {
+	ReadingStats(ctx context.Context, userID string, query *domain.StatsQuery) (*domain.ReadingStats, error)
+}
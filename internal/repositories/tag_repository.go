@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// TagRepository reports the tags in use on the user's books. Tags are
+// stored on the books themselves, so renaming and deleting them are book
+// updates.
+type TagRepository interface {
+	// ListTags counts the books carrying each tag that starts with prefix,
+	// ignoring case, most used first and then by tag. Books in the trash
+	// are not counted.
+	ListTags(ctx context.Context, userID, prefix string, limit int) ([]domain.FacetCount, error)
+}
@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/smartnotes/user-service/internal/models"
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+func TestSQLiteNormalizesStoredTags(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tags.db")
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice", Email: "alice@example.com", Role: "user"}
+	if err := repo.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	book := &domain.Book{UserID: user.ID, Title: "Dune", Tags: []string{"sci-fi"}}
+	if err := repo.Create(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	// Tags as written before they were normalized
+	if err := repo.inTx(ctx, func(tx *sql.Tx) error {
+		return repo.replaceTags(ctx, tx, book.ID, []string{"Sci-Fi ", "Classics", "sci-fi"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo.db.Close()
+
+	repo, err = NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.db.Close()
+	got, err := repo.GetByID(ctx, book.ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sci-fi", "classics"}; !slices.Equal(got.Tags, want) {
+		t.Errorf("tags = %q, want %q", got.Tags, want)
+	}
+	if got.Version != book.Version+1 {
+		t.Errorf("version = %d, want %d", got.Version, book.Version+1)
+	}
+
+	tagged, err := repo.List(ctx, user.ID, &domain.ListBooksQuery{Tags: []string{"classics"}, SortKeys: domain.DefaultSort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tagged) != 1 {
+		t.Errorf("listing by the normalized tag found %d books, want 1", len(tagged))
+	}
+}
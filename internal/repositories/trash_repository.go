@@ -0,0 +1,25 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/smartnotes/user-service/pkg/domain"
+)
+
+// TrashRepository manages books moved to the trash by BookRepository.Delete.
+// Trashed books keep their notes until they are purged.
+type TrashRepository interface {
+	// ListTrash returns the user's trashed books, most recently deleted first.
+	ListTrash(ctx context.Context, userID string) ([]*domain.Book, error)
+	Restore(ctx context.Context, id, userID string) (*domain.Book, error)
+	// Purge permanently deletes a trashed book with its notes, revisions
+	// and attachment records. The caller deletes the book's files.
+	Purge(ctx context.Context, id, userID string) error
+	// EmptyTrash purges every trashed book of the user and returns their
+	// IDs.
+	EmptyTrash(ctx context.Context, userID string) ([]string, error)
+	// PurgeTrashedBefore permanently deletes the books of every user that
+	// were trashed before cutoff and returns their IDs.
+	PurgeTrashedBefore(ctx context.Context, cutoff time.Time) ([]string, error)
+}
@@ -0,0 +1,324 @@
+// Package search parses full-text queries and scores and highlights text
+// against them. Storage backends without a native text index use it to rank
+// results; all backends use it to build highlighted snippets.
+package search
+
+import (
+	"html"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Query is a parsed full-text query.
+//
+// Plain words are alternatives: a document needs at least one of them.
+// "Quoted phrases" and prefix* words are required. When a query contains a
+// phrase the plain words only contribute to the score, mirroring MongoDB's
+// $text semantics.
+type Query struct {
+	Terms    []string
+	Phrases  [][]string
+	Prefixes []string
+}
+
+// Parse splits raw into terms, "quoted phrases" and prefix* words. Matching
+// is case-insensitive; punctuation separates words.
+func Parse(raw string) Query {
+	var q Query
+	for len(raw) > 0 {
+		start := strings.IndexByte(raw, '"')
+		if start < 0 {
+			q.addWords(raw)
+			break
+		}
+		q.addWords(raw[:start])
+		raw = raw[start+1:]
+
+		end := strings.IndexByte(raw, '"')
+		if end < 0 {
+			end = len(raw)
+		}
+		if ws := words(raw[:end]); len(ws) == 1 {
+			q.Terms = append(q.Terms, ws[0])
+		} else if len(ws) > 1 {
+			q.Phrases = append(q.Phrases, ws)
+		}
+		if end == len(raw) {
+			break
+		}
+		raw = raw[end+1:]
+	}
+	return q
+}
+
+func (q *Query) addWords(s string) {
+	for _, field := range strings.Fields(s) {
+		if strings.HasSuffix(field, "*") {
+			ws := words(strings.TrimRight(field, "*"))
+			if len(ws) == 0 {
+				continue
+			}
+			// "sci-fi*" means the phrase "sci" followed by a word starting with "fi"
+			if len(ws) > 1 {
+				q.Phrases = append(q.Phrases, ws[:len(ws)-1])
+			}
+			q.Prefixes = append(q.Prefixes, ws[len(ws)-1])
+			continue
+		}
+		q.Terms = append(q.Terms, words(field)...)
+	}
+}
+
+// Empty reports whether the query has nothing to search for.
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.Prefixes) == 0
+}
+
+// Needles returns every word the query looks for, including the words of
+// phrases and the prefixes. A matching text contains at least one of them
+// as a substring, so backends use them to pre-filter candidates.
+func (q Query) Needles() []string {
+	needles := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		needles = append(needles, phrase...)
+	}
+	return append(needles, q.Prefixes...)
+}
+
+// TextSearch renders the terms and phrases as a MongoDB $text $search
+// string. Prefixes have no $text equivalent and are left out.
+func (q Query) TextSearch() string {
+	parts := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+strings.Join(phrase, " ")+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Field is a piece of text with the weight its matches carry.
+type Field struct {
+	Name   string
+	Text   string
+	Weight float64
+}
+
+// Match reports whether the fields together satisfy the query and returns
+// the relevance score. A field scores its weight for every matched term,
+// phrase or prefix, damped by how often it repeats.
+func (q Query) Match(fields ...Field) (float64, bool) {
+	var termHit, phraseHits, prefixHits int
+	phraseSeen := make([]bool, len(q.Phrases))
+	prefixSeen := make([]bool, len(q.Prefixes))
+	score := 0.0
+
+	for _, f := range fields {
+		toks := tokenize(f.Text)
+		if len(toks) == 0 {
+			continue
+		}
+
+		for _, term := range q.Terms {
+			if n := countTerm(toks, term); n > 0 {
+				termHit++
+				score += f.Weight * damp(n)
+			}
+		}
+		for i, phrase := range q.Phrases {
+			if n := countPhrase(toks, phrase); n > 0 {
+				if !phraseSeen[i] {
+					phraseSeen[i] = true
+					phraseHits++
+				}
+				score += f.Weight * damp(n) * float64(len(phrase))
+			}
+		}
+		for i, prefix := range q.Prefixes {
+			if n := countPrefix(toks, prefix); n > 0 {
+				if !prefixSeen[i] {
+					prefixSeen[i] = true
+					prefixHits++
+				}
+				score += f.Weight * damp(n)
+			}
+		}
+	}
+
+	if phraseHits < len(q.Phrases) || prefixHits < len(q.Prefixes) {
+		return 0, false
+	}
+	if len(q.Phrases) == 0 && len(q.Terms) > 0 && termHit == 0 {
+		return 0, false
+	}
+	return math.Round(score*1000) / 1000, true
+}
+
+func damp(n int) float64 {
+	return 1 + math.Log(float64(n))
+}
+
+// snippetRunes is the approximate length of a highlighted snippet.
+const snippetRunes = 160
+
+// Highlight returns an HTML-safe excerpt of text around the first match of
+// the query with every match wrapped in <mark> tags. ok is false when
+// nothing in text matches.
+func (q Query) Highlight(text string) (snippet string, ok bool) {
+	toks := tokenize(text)
+	var spans [][2]int
+	for i := 0; i < len(toks); i++ {
+		if n := q.matchAt(toks, i); n > 0 {
+			spans = append(spans, [2]int{toks[i].start, toks[i+n-1].end})
+			i += n - 1
+		}
+	}
+	if len(spans) == 0 {
+		return "", false
+	}
+
+	from, to := window(text, spans[0][0], snippetRunes)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, span := range spans {
+		if span[0] < pos || span[1] > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:span[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[span[0]:span[1]]))
+		b.WriteString("</mark>")
+		pos = span[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// matchAt returns how many tokens starting at i match the query, or 0.
+func (q Query) matchAt(toks []token, i int) int {
+	for _, phrase := range q.Phrases {
+		if phraseAt(toks, i, phrase) {
+			return len(phrase)
+		}
+	}
+	for _, term := range q.Terms {
+		if toks[i].text == term {
+			return 1
+		}
+	}
+	for _, prefix := range q.Prefixes {
+		if strings.HasPrefix(toks[i].text, prefix) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// window picks byte offsets of a slice of text about size runes long that
+// starts a little before at, snapped to whitespace where possible.
+func window(text string, at, size int) (int, int) {
+	from := at
+	for lead := 0; from > 0 && lead < size/4; lead++ {
+		_, n := utf8.DecodeLastRuneInString(text[:from])
+		from -= n
+	}
+	if from > 0 {
+		if i := strings.IndexFunc(text[from:at], unicode.IsSpace); i >= 0 {
+			from += i + 1
+		}
+	}
+
+	to := from
+	for count := 0; to < len(text) && count < size; count++ {
+		_, n := utf8.DecodeRuneInString(text[to:])
+		to += n
+	}
+	if to < len(text) {
+		if i := strings.LastIndexFunc(text[at:to], unicode.IsSpace); i > 0 {
+			to = at + i
+		}
+	}
+	return from, to
+}
+
+type token struct {
+	text       string
+	start, end int
+}
+
+func tokenize(s string) []token {
+	var toks []token
+	start := -1
+	for i, r := range s {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			toks = append(toks, token{text: strings.ToLower(s[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{text: strings.ToLower(s[start:]), start: start, end: len(s)})
+	}
+	return toks
+}
+
+func words(s string) []string {
+	toks := tokenize(s)
+	ws := make([]string, len(toks))
+	for i, t := range toks {
+		ws[i] = t.text
+	}
+	return ws
+}
+
+func countTerm(toks []token, term string) int {
+	n := 0
+	for _, t := range toks {
+		if t.text == term {
+			n++
+		}
+	}
+	return n
+}
+
+func countPrefix(toks []token, prefix string) int {
+	n := 0
+	for _, t := range toks {
+		if strings.HasPrefix(t.text, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func countPhrase(toks []token, phrase []string) int {
+	n := 0
+	for i := range toks {
+		if phraseAt(toks, i, phrase) {
+			n++
+		}
+	}
+	return n
+}
+
+func phraseAt(toks []token, i int, phrase []string) bool {
+	if i+len(phrase) > len(toks) {
+		return false
+	}
+	for j, w := range phrase {
+		if toks[i+j].text != w {
+			return false
+		}
+	}
+	return true
+}
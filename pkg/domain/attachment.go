@@ -0,0 +1,72 @@
+package domain
+
+import "time"
+
+// Attachment content types
+const (
+	AttachmentPDF  = "application/pdf"
+	AttachmentEPUB = "application/epub+zip"
+)
+
+// Attachment is a PDF or EPUB file kept with a book. Its contents live in
+// the blob store under Key; Metadata is what an EPUB says about itself.
+type Attachment struct {
+	ID          string        `bson:"-" json:"id"`
+	BookID      string        `bson:"-" json:"book_id"`
+	UserID      string        `bson:"-" json:"user_id"`
+	FileName    string        `bson:"file_name" json:"file_name"`
+	ContentType string        `bson:"content_type" json:"content_type"`
+	Size        int64         `bson:"size" json:"size"`
+	SHA256      string        `bson:"sha256" json:"sha256"`
+	Key         string        `bson:"key" json:"-"`
+	Metadata    *BookMetadata `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	CreatedAt   time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// Upload is a resumable attachment upload. The client declares the size
+// up front and sends the file in chunks, each starting at Offset; Parts
+// are the blob keys of the chunks received so far, in order.
+type Upload struct {
+	ID        string    `bson:"-" json:"id"`
+	BookID    string    `bson:"-" json:"book_id"`
+	UserID    string    `bson:"-" json:"user_id"`
+	FileName  string    `bson:"file_name" json:"file_name"`
+	Size      int64     `bson:"size" json:"size"`
+	Offset    int64     `bson:"offset" json:"offset"`
+	Prefill   bool      `bson:"prefill" json:"prefill"`
+	Parts     []string  `bson:"parts" json:"-"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// CreateUploadRequest starts an Upload. With Prefill an EPUB's title and
+// author replace the book's; otherwise they only fill empty fields.
+type CreateUploadRequest struct {
+	FileName string `json:"file_name" binding:"required,max=255"`
+	Size     int64  `json:"size" binding:"required,min=1"`
+	Prefill  bool   `json:"prefill"`
+}
+
+// StorageUsage is the space a user's attachments take up against their
+// quota, in bytes. Unfinished uploads count at their declared size.
+type StorageUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
+
+// AttachmentKey returns the blob key of an attachment of a book; name is
+// a random token, so a replaced file never shares a key with the old one.
+func AttachmentKey(bookID, name string) string {
+	return "attachments/" + bookID + "/" + name
+}
+
+// UploadPrefix is the blob key prefix of the chunks of an upload.
+func UploadPrefix(bookID, uploadID string) string {
+	return "attachments/" + bookID + "/uploads/" + uploadID + "/"
+}
+
+// BookBlobPrefixes are the blob key prefixes holding the files of a book:
+// its cover, attachments and unfinished uploads.
+func BookBlobPrefixes(bookID string) []string {
+	return []string{"covers/" + bookID + "/", "attachments/" + bookID + "/"}
+}
@@ -0,0 +1,38 @@
+package domain
+
+import "encoding/json"
+
+// Batch operations
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchRequest is a list of book writes applied in order. An atomic batch
+// applies all of them or none; otherwise each one stands on its own.
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations" binding:"required"`
+}
+
+// BatchOperation is one write of a batch. Book holds the new book for a
+// create and a JSON merge patch for an update. Version, when set, must
+// match the current version of the book an update or delete applies to.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Version *int64          `json:"version,omitempty"`
+	Book    json.RawMessage `json:"book,omitempty"`
+}
+
+// BatchResult is the outcome of one operation, with the status code the
+// matching single-book request would have answered.
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Book   *Book  `json:"book,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
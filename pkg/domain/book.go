@@ -1,47 +1,292 @@
 package domain
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
 
+// Book IDs are opaque strings; each storage backend decides how they are
+// represented at rest. Publisher, Year, Edition and Pages are optional
+// bibliographic details used for citations; CoverURL points to a cover image
+// hosted elsewhere, usually found by an ISBN lookup, while Cover describes
+// an image uploaded to the service. Status, CurrentPage, Progress (a
+// percentage), StartedAt and FinishedAt track the reading of the book;
+// Rating, in half stars from 0.5 to 5, and Review, in Markdown, are what
+// the reader made of it.
 type Book struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Title       string             `bson:"title" json:"title"`
-	Author      string             `bson:"author" json:"author"`
-	Description string             `bson:"description" json:"description"`
-	Tags        []string           `bson:"tags" json:"tags"`
-	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
-	UpdatedAt   primitive.DateTime `bson:"updated_at" json:"updated_at"`
+	ID          string     `bson:"-" json:"id"`
+	UserID      string     `bson:"-" json:"user_id"`
+	Title       string     `bson:"title" json:"title"`
+	Author      string     `bson:"author" json:"author"`
+	Description string     `bson:"description" json:"description"`
+	ISBN        string     `bson:"isbn" json:"isbn"`
+	Publisher   string     `bson:"publisher,omitempty" json:"publisher,omitempty"`
+	Year        *int       `bson:"year,omitempty" json:"year,omitempty"`
+	Edition     string     `bson:"edition,omitempty" json:"edition,omitempty"`
+	Pages       *int       `bson:"pages,omitempty" json:"pages,omitempty"`
+	CoverURL    string     `bson:"cover_url,omitempty" json:"cover_url,omitempty"`
+	Cover       *Cover     `bson:"cover,omitempty" json:"cover,omitempty"`
+	Tags        []string   `bson:"tags" json:"tags"`
+	Status      string     `bson:"status,omitempty" json:"status,omitempty"`
+	CurrentPage *int       `bson:"current_page,omitempty" json:"current_page,omitempty"`
+	Progress    *float64   `bson:"progress,omitempty" json:"progress,omitempty"`
+	StartedAt   *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt  *time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	Rating      *float64   `bson:"rating,omitempty" json:"rating,omitempty"`
+	Review      string     `bson:"review,omitempty" json:"review,omitempty"`
+	// TimeSpent is the total length of the book's reading sessions in
+	// seconds. It is not stored but added when books are read.
+	TimeSpent int64 `bson:"-" json:"time_spent,omitempty"`
+	// Position is the place of the book on the shelf it was listed from
+	Position  *int      `bson:"-" json:"position,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	// Version starts at 1 and is incremented by every update
+	Version int64 `bson:"version" json:"version"`
+	// DeletedAt is set while the book is in the trash
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// ETag is the entity tag of the book's current version.
+func (b *Book) ETag() string {
+	return `"` + strconv.FormatInt(b.Version, 10) + `"`
 }
 
 type CreateBookRequest struct {
-	Title       string   `json:"title" binding:"required"`
-	Author      string   `json:"author"`
-	Description string   `json:"description"`
-	Tags        []string `json:"tags"`
+	Title       string     `json:"title" binding:"required"`
+	Author      string     `json:"author"`
+	Description string     `json:"description"`
+	ISBN        string     `json:"isbn"`
+	Publisher   string     `json:"publisher"`
+	Year        *int       `json:"year" binding:"omitempty,min=1,max=9999"`
+	Edition     string     `json:"edition"`
+	Pages       *int       `json:"pages" binding:"omitempty,min=1"`
+	CoverURL    string     `json:"cover_url" binding:"omitempty,url"`
+	Tags        []string   `json:"tags"`
+	Status      string     `json:"status" binding:"omitempty,oneof=want-to-read reading finished abandoned"`
+	CurrentPage *int       `json:"current_page" binding:"omitempty,min=0"`
+	Progress    *float64   `json:"progress" binding:"omitempty,min=0,max=100"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	Rating      *float64   `json:"rating" binding:"omitempty,min=0.5,max=5"`
+	Review      string     `json:"review"`
 }
 
+// Replace returns the update that turns a book into the one described by
+// r, clearing the fields r leaves out.
+func (r *CreateBookRequest) Replace() *UpdateBookRequest {
+	year, pages := intValue(r.Year), intValue(r.Pages)
+	page, progress := intValue(r.CurrentPage), floatValue(r.Progress)
+	startedAt, finishedAt := timeValue(r.StartedAt), timeValue(r.FinishedAt)
+	rating := floatValue(r.Rating)
+	return &UpdateBookRequest{
+		Title:       &r.Title,
+		Author:      &r.Author,
+		Description: &r.Description,
+		ISBN:        &r.ISBN,
+		Publisher:   &r.Publisher,
+		Year:        &year,
+		Edition:     &r.Edition,
+		Pages:       &pages,
+		CoverURL:    &r.CoverURL,
+		Tags:        &r.Tags,
+		Status:      &r.Status,
+		CurrentPage: &page,
+		Progress:    &progress,
+		StartedAt:   &startedAt,
+		FinishedAt:  &finishedAt,
+		Rating:      &rating,
+		Review:      &r.Review,
+	}
+}
+
+// UpdateBookRequest holds the fields an update sets. A Year, Pages,
+// CurrentPage, Progress or Rating of 0 clears the field, as does a zero
+// time.
 type UpdateBookRequest struct {
-	Title       *string   `json:"title,omitempty"`
-	Author      *string   `json:"author,omitempty"`
-	Description *string   `json:"description,omitempty"`
-	Tags        *[]string `json:"tags,omitempty"`
+	Title       *string    `json:"title,omitempty"`
+	Author      *string    `json:"author,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	ISBN        *string    `json:"isbn,omitempty"`
+	Publisher   *string    `json:"publisher,omitempty"`
+	Year        *int       `json:"year,omitempty"`
+	Edition     *string    `json:"edition,omitempty"`
+	Pages       *int       `json:"pages,omitempty"`
+	CoverURL    *string    `json:"cover_url,omitempty"`
+	Tags        *[]string  `json:"tags,omitempty"`
+	Status      *string    `json:"status,omitempty"`
+	CurrentPage *int       `json:"current_page,omitempty"`
+	Progress    *float64   `json:"progress,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Rating      *float64   `json:"rating,omitempty"`
+	Review      *string    `json:"review,omitempty"`
+}
+
+// DecodeBookMergePatch reads a JSON Merge Patch (RFC 7386) of a book into an
+// UpdateBookRequest: absent members are left alone, null clears a field and
+// arrays replace the whole list. Read-only members such as id are ignored.
+func DecodeBookMergePatch(data []byte) (*UpdateBookRequest, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil || members == nil {
+		return nil, errors.New("merge patch must be a JSON object")
+	}
+
+	var req UpdateBookRequest
+	for name, raw := range members {
+		isNull := string(raw) == "null"
+		var err error
+		switch name {
+		case "title":
+			req.Title = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Title)
+			}
+			if err == nil && *req.Title == "" {
+				return nil, errors.New("title cannot be empty")
+			}
+		case "author":
+			req.Author = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Author)
+			}
+		case "description":
+			req.Description = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Description)
+			}
+		case "isbn":
+			req.ISBN = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.ISBN)
+			}
+			if err == nil {
+				if *req.ISBN, err = ParseISBN(*req.ISBN); err != nil {
+					return nil, err
+				}
+			}
+		case "publisher":
+			req.Publisher = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Publisher)
+			}
+		case "edition":
+			req.Edition = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Edition)
+			}
+		case "cover_url":
+			req.CoverURL = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.CoverURL)
+			}
+			if err == nil && *req.CoverURL != "" && !absoluteURL(*req.CoverURL) {
+				return nil, errors.New("cover_url must be an absolute URL")
+			}
+		case "year":
+			req.Year = new(int)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Year)
+			}
+			if err == nil && !isNull && (*req.Year < 1 || *req.Year > 9999) {
+				return nil, errors.New("year must be between 1 and 9999")
+			}
+		case "pages":
+			req.Pages = new(int)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Pages)
+			}
+			if err == nil && !isNull && *req.Pages < 1 {
+				return nil, errors.New("pages must be at least 1")
+			}
+		case "tags":
+			req.Tags = &[]string{}
+			if !isNull {
+				err = json.Unmarshal(raw, req.Tags)
+			}
+		case "status":
+			req.Status = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Status)
+			}
+			if err == nil && !validStatus(*req.Status) {
+				return nil, errors.New("status must be one of want-to-read, reading, finished or abandoned")
+			}
+		case "current_page":
+			req.CurrentPage = new(int)
+			if !isNull {
+				err = json.Unmarshal(raw, req.CurrentPage)
+			}
+			if err == nil && *req.CurrentPage < 0 {
+				return nil, errors.New("current_page cannot be negative")
+			}
+		case "progress":
+			req.Progress = new(float64)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Progress)
+			}
+			if err == nil && (*req.Progress < 0 || *req.Progress > 100) {
+				return nil, errors.New("progress must be between 0 and 100")
+			}
+		case "started_at":
+			req.StartedAt = new(time.Time)
+			if !isNull {
+				err = json.Unmarshal(raw, req.StartedAt)
+			}
+		case "finished_at":
+			req.FinishedAt = new(time.Time)
+			if !isNull {
+				err = json.Unmarshal(raw, req.FinishedAt)
+			}
+		case "rating":
+			req.Rating = new(float64)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Rating)
+			}
+			if err == nil && !isNull && !ValidRating(*req.Rating) {
+				return nil, errors.New("rating " + ratingRule)
+			}
+		case "review":
+			req.Review = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, req.Review)
+			}
+		case "id", "user_id", "cover", "time_spent", "position", "created_at", "updated_at", "version", "etag",
+			"deleted_at":
+		default:
+			return nil, errors.New("unknown field " + name)
+		}
+		if err != nil {
+			return nil, errors.New("invalid value for " + name)
+		}
+	}
+	return &req, nil
+}
+
+func absoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
 }
 
 type BookResponse struct {
-	ID          primitive.ObjectID `json:"id"`
-	UserID      primitive.ObjectID `json:"user_id"`
-	Title       string             `json:"title"`
-	Author      string             `json:"author"`
-	Description string             `json:"description"`
-	Tags        []string           `json:"tags"`
-	CreatedAt   string             `json:"created_at"`
-	UpdatedAt   string             `json:"updated_at"`
-}
-
-type ListBooksQuery struct {
-	Search string `form:"search"`
-	SortBy string `form:"sort_by"`
-	Order  string `form:"order"`
-	Limit  int    `form:"limit"`
-	Offset int    `form:"offset"`
+	ID          string   `json:"id"`
+	UserID      string   `json:"user_id"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+// BookSearchResult is a book matched by a full-text query together with its
+// relevance score and highlighted snippets keyed by field ("title",
+// "author", "description", "tags" or "notes").
+type BookSearchResult struct {
+	Book
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
 }
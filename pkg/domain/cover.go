@@ -0,0 +1,34 @@
+package domain
+
+import "time"
+
+// Cover sizes. The original is kept as uploaded; the others are JPEG
+// thumbnails that fit in a square of CoverSizes[size] pixels.
+const (
+	CoverOriginal = "original"
+	CoverSmall    = "small"
+	CoverMedium   = "medium"
+	CoverLarge    = "large"
+)
+
+var CoverSizes = map[string]int{
+	CoverSmall:  160,
+	CoverMedium: 320,
+	CoverLarge:  640,
+}
+
+// Cover describes the image uploaded for a book. Hash is the SHA-256 of
+// the original; it names the stored files and makes up their ETags.
+type Cover struct {
+	ContentType string    `bson:"content_type" json:"content_type"`
+	Width       int       `bson:"width" json:"width"`
+	Height      int       `bson:"height" json:"height"`
+	Size        int64     `bson:"size" json:"size"`
+	Hash        string    `bson:"hash" json:"hash"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Key returns the blob key of one size of the cover of a book.
+func (c *Cover) Key(bookID, size string) string {
+	return "covers/" + bookID + "/" + c.Hash + "/" + size
+}
@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// BookSortFields lists the fields books can be sorted by.
+// position only applies to the books of a shelf.
+var BookSortFields = []string{"title", "author", "created_at", "updated_at", "rating", "position"}
+
+// BookCursor marks a position in a sorted book listing: the sort keys of the
+// last book of a page and its ID, which breaks ties between equal keys.
+// Clients only ever see it encoded.
+type BookCursor struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+	ID     string `json:"id"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// NewBookCursor returns the cursor that continues a listing sorted by keys
+// right after b.
+func NewBookCursor(b *Book, keys []SortKey) *BookCursor {
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i], _ = BookSortValue(b, key.Field)
+	}
+	return &BookCursor{Sort: FormatSort(keys), Values: values, ID: b.ID}
+}
+
+func (c *BookCursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeBookCursor parses a cursor produced by Encode. Sort keys come back
+// with the type BookSortValue gives them.
+func DecodeBookCursor(s string) (*BookCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var c struct {
+		Sort   string            `json:"s"`
+		Values []json.RawMessage `json:"v"`
+		ID     string            `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == "" {
+		return nil, errInvalidCursor
+	}
+	keys, err := ParseSort(c.Sort)
+	if err != nil || len(keys) != len(c.Values) {
+		return nil, errInvalidCursor
+	}
+
+	cursor := &BookCursor{Sort: c.Sort, Values: make([]any, len(keys)), ID: c.ID}
+	for i, key := range keys {
+		var err error
+		switch sample, _ := BookSortValue(&Book{}, key.Field); sample.(type) {
+		case time.Time:
+			var t time.Time
+			err = json.Unmarshal(c.Values[i], &t)
+			cursor.Values[i] = t
+		case float64:
+			var f float64
+			err = json.Unmarshal(c.Values[i], &f)
+			cursor.Values[i] = f
+		case int:
+			var n int
+			err = json.Unmarshal(c.Values[i], &n)
+			cursor.Values[i] = n
+		default:
+			var s string
+			err = json.Unmarshal(c.Values[i], &s)
+			cursor.Values[i] = s
+		}
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+	}
+	return cursor, nil
+}
+
+// BookSortValue returns the value of a sort field of b: a string, a
+// time.Time, for the rating a float64 that is 0 for unrated books, or for
+// the position an int. ok is false for fields not in BookSortFields.
+func BookSortValue(b *Book, field string) (value any, ok bool) {
+	switch field {
+	case "title":
+		return b.Title, true
+	case "author":
+		return b.Author, true
+	case "created_at":
+		return b.CreatedAt, true
+	case "updated_at":
+		return b.UpdatedAt, true
+	case "rating":
+		return floatValue(b.Rating), true
+	case "position":
+		return intValue(b.Position), true
+	}
+	return nil, false
+}
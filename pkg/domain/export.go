@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// ExportVersion is the version of the JSON export format. It changes
+// whenever a change to it could break reading older exports. Version 2
+// added reading sessions, attachments and shelves.
+const ExportVersion = 2
+
+// ExportFormat names the JSON export format in its header.
+const ExportFormat = "smartnotes"
+
+// ExportHeader opens a JSON export; the books follow it.
+type ExportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// ExportedBook is a book of an export together with its notes, reading
+// sessions and attachments. Covers and attachments are exported as their
+// metadata only, without the files.
+type ExportedBook struct {
+	Book
+	Notes       []*Note           `json:"notes"`
+	Sessions    []*ReadingSession `json:"sessions"`
+	Attachments []*Attachment     `json:"attachments"`
+}
+
+// Export is a whole JSON export as read back for importing. The shelves
+// follow the books and list them by their IDs in the export.
+type Export struct {
+	ExportHeader
+	Books   []*ExportedBook `json:"books"`
+	Shelves []*Shelf        `json:"shelves"`
+}
@@ -0,0 +1,37 @@
+package domain
+
+import "time"
+
+// Import job states
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ImportJob reports the progress of a background import. Total is the
+// number of rows in the upload and Processed how many of them have been
+// handled so far, either imported or skipped.
+type ImportJob struct {
+	ID         string       `json:"id"`
+	UserID     string       `json:"-"`
+	Source     string       `json:"source"`
+	Status     string       `json:"status"`
+	Total      int          `json:"total"`
+	Processed  int          `json:"processed"`
+	Imported   int          `json:"imported"`
+	Skipped    []SkippedRow `json:"skipped"`
+	Warnings   []SkippedRow `json:"warnings"`
+	Error      string       `json:"error,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// SkippedRow explains why a row of an import, or an entry of a clippings
+// file, was not imported, or among Warnings, what of an imported row was
+// left out. Rows are numbered from 1, not counting a header.
+type SkippedRow struct {
+	Row    int    `json:"row"`
+	Title  string `json:"title,omitempty"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidISBN = errors.New("invalid ISBN, expected an ISBN-10 or ISBN-13 with a valid check digit")
+
+// ParseISBN checks an ISBN-10 or ISBN-13, which may contain hyphens or
+// spaces, and returns it as a bare ISBN-13. An empty string stays empty.
+func ParseISBN(s string) (string, error) {
+	s = strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s))
+	switch {
+	case s == "":
+		return "", nil
+	case len(s) == 10:
+		s = strings.ToUpper(s)
+		if !digits(s[:9]) || !(digits(s[9:]) || s[9] == 'X') || isbn10Check(s[:9]) != s[9] {
+			return "", ErrInvalidISBN
+		}
+		return ISBN13(s), nil
+	case len(s) == 13:
+		if !digits(s) || isbn13Check(s[:12]) != s[12] {
+			return "", ErrInvalidISBN
+		}
+		return s, nil
+	}
+	return "", ErrInvalidISBN
+}
+
+// ISBN13 converts a valid bare ISBN-10 to ISBN-13 and returns an ISBN-13
+// unchanged.
+func ISBN13(isbn string) string {
+	if len(isbn) != 10 {
+		return isbn
+	}
+	body := "978" + isbn[:9]
+	return body + string(isbn13Check(body))
+}
+
+// ISBN10 converts a valid bare ISBN-13 to ISBN-10. Only 978 ISBNs have an
+// ISBN-10 form; for the others ok is false.
+func ISBN10(isbn string) (s string, ok bool) {
+	if len(isbn) == 10 {
+		return isbn, true
+	}
+	if len(isbn) != 13 || !strings.HasPrefix(isbn, "978") {
+		return "", false
+	}
+	body := isbn[3:12]
+	return body + string(isbn10Check(body)), true
+}
+
+func isbn10Check(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+func isbn13Check(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(body[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package domain
+
+import "strings"
+
+// BookMetadata describes an edition as a metadata provider knows it. ISBN
+// is a bare ISBN-13 and Source names the provider that answered.
+type BookMetadata struct {
+	ISBN      string   `bson:"isbn,omitempty" json:"isbn"`
+	Title     string   `bson:"title" json:"title"`
+	Authors   []string `bson:"authors" json:"authors"`
+	Publisher string   `bson:"publisher,omitempty" json:"publisher,omitempty"`
+	Year      *int     `bson:"year,omitempty" json:"year,omitempty"`
+	Pages     *int     `bson:"pages,omitempty" json:"pages,omitempty"`
+	CoverURL  string   `bson:"cover_url,omitempty" json:"cover_url,omitempty"`
+	Source    string   `bson:"source" json:"source"`
+}
+
+// Author joins the authors the way citations split them again.
+func (m *BookMetadata) Author() string {
+	return strings.Join(m.Authors, " and ")
+}
+
+// Fill copies the metadata into the fields of r the client left empty.
+func (m *BookMetadata) Fill(r *CreateBookRequest) {
+	if r.Title == "" {
+		r.Title = m.Title
+	}
+	if r.Author == "" {
+		r.Author = m.Author()
+	}
+	if r.Publisher == "" {
+		r.Publisher = m.Publisher
+	}
+	if r.Year == nil {
+		r.Year = m.Year
+	}
+	if r.Pages == nil {
+		r.Pages = m.Pages
+	}
+	if r.CoverURL == "" {
+		r.CoverURL = m.CoverURL
+	}
+}
+
+// NeedsMetadata reports whether r names an ISBN and leaves out a field an
+// ISBN lookup could fill in.
+func (r *CreateBookRequest) NeedsMetadata() bool {
+	return r.ISBN != "" &&
+		(r.Title == "" || r.Author == "" || r.Publisher == "" || r.Year == nil || r.Pages == nil || r.CoverURL == "")
+}
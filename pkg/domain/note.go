@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// Note kinds
+const (
+	NoteKindNote      = "note"
+	NoteKindHighlight = "highlight"
+)
+
+type Note struct {
+	ID     string `bson:"-" json:"id"`
+	UserID string `bson:"-" json:"user_id"`
+	BookID string `bson:"-" json:"book_id"`
+	// Kind tells the user's own notes apart from passages highlighted in
+	// the book
+	Kind      string    `bson:"kind" json:"kind"`
+	Body      string    `bson:"body" json:"body"`
+	Page      *int      `bson:"page,omitempty" json:"page,omitempty"`
+	Location  string    `bson:"location,omitempty" json:"location,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+type CreateNoteRequest struct {
+	Body     string `json:"body" binding:"required"`
+	Kind     string `json:"kind" binding:"omitempty,oneof=note highlight"`
+	Page     *int   `json:"page" binding:"omitempty,min=1"`
+	Location string `json:"location"`
+}
+
+type UpdateNoteRequest struct {
+	Body     *string `json:"body,omitempty" binding:"omitempty,min=1"`
+	Page     *int    `json:"page,omitempty" binding:"omitempty,min=1"`
+	Location *string `json:"location,omitempty"`
+}
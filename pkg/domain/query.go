@@ -0,0 +1,238 @@
+package domain
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	TagModeAny = "any"
+	TagModeAll = "all"
+)
+
+// ListBooksQuery is the contract of the book list endpoint. Bind it from the
+// query string and call Validate before handing it to a repository.
+type ListBooksQuery struct {
+	// Query switches to relevance-ranked full-text search
+	Query string `form:"q"`
+	// Search is a plain case-insensitive substring match on title and description
+	Search string `form:"search"`
+
+	Tags    []string `form:"tag"`
+	TagMode string   `form:"tag_mode,default=any"`
+	Author  string   `form:"author"`
+	// Statuses keeps books with any of these reading statuses; StatusNone
+	// matches books without one
+	Statuses []string `form:"status"`
+	// RatingMin and RatingMax leave out unrated books
+	RatingMin     *float64   `form:"rating_min"`
+	RatingMax     *float64   `form:"rating_max"`
+	CreatedAfter  *Timestamp `form:"created_after"`
+	CreatedBefore *Timestamp `form:"created_before"`
+	UpdatedSince  *Timestamp `form:"updated_since"`
+	// Shelf restricts the listing to the books on a shelf and lets them be
+	// sorted by position; handlers set it, it is not a query parameter
+	Shelf *Shelf `form:"-"`
+
+	// Sort lists sort fields separated by commas, each optionally prefixed
+	// with - for descending order, e.g. -updated_at,title. SortBy and Order
+	// are the legacy single-field form.
+	Sort   string `form:"sort"`
+	SortBy string `form:"sort_by"`
+	Order  string `form:"order"`
+	Limit  int    `form:"limit,default=10"`
+	// After continues a listing from a next_cursor; Offset is the legacy
+	// alternative and cannot be combined with it
+	After  string `form:"after"`
+	Offset int    `form:"offset,default=0"`
+
+	// SortKeys and Cursor are Sort and After parsed by Validate. Books with
+	// equal sort keys are ordered by ID in the direction of the last key.
+	SortKeys []SortKey   `form:"-"`
+	Cursor   *BookCursor `form:"-"`
+}
+
+// DefaultSort lists the newest books first.
+var DefaultSort = []SortKey{{Field: "created_at", Desc: true}}
+
+// Validate checks every parameter, reporting all problems at once as
+// ValidationErrors, and clamps Limit to maxLimit.
+func (q *ListBooksQuery) Validate(maxLimit int) error {
+	var errs ValidationErrors
+
+	// Tags are stored normalized, so filter by the normalized form
+	q.Tags = NormalizeTags(q.Tags)
+	if q.TagMode == "" {
+		q.TagMode = TagModeAny
+	}
+	if q.TagMode != TagModeAny && q.TagMode != TagModeAll {
+		errs.Add("tag_mode", "must be any or all")
+	}
+	for _, status := range q.Statuses {
+		if status == StatusNone || slices.Contains(ReadingStatuses, status) {
+			continue
+		}
+		errs.Add("status", "unknown status "+status+", expected one of "+strings.Join(ReadingStatuses, ", ")+" or none")
+	}
+	if q.RatingMin != nil && (*q.RatingMin < MinRating || *q.RatingMin > MaxRating) {
+		errs.Add("rating_min", "must be between 0.5 and 5")
+	}
+	if q.RatingMax != nil && (*q.RatingMax < MinRating || *q.RatingMax > MaxRating) {
+		errs.Add("rating_max", "must be between 0.5 and 5")
+	}
+	if q.RatingMin != nil && q.RatingMax != nil && *q.RatingMin > *q.RatingMax {
+		errs.Add("rating_min", "must not be above rating_max")
+	}
+	if q.CreatedAfter != nil && q.CreatedBefore != nil && !q.CreatedAfter.Before(q.CreatedBefore.Time) {
+		errs.Add("created_after", "must be before created_before")
+	}
+
+	switch {
+	case q.Sort != "" && q.SortBy != "":
+		errs.Add("sort", "cannot be combined with sort_by")
+	case q.Sort != "":
+		keys, err := ParseSort(q.Sort)
+		if err != nil {
+			errs.Add("sort", err.Error())
+		}
+		q.SortKeys = keys
+	case q.SortBy != "":
+		if _, ok := BookSortValue(&Book{}, q.SortBy); !ok {
+			errs.Add("sort_by", "unknown field "+q.SortBy+", expected one of "+strings.Join(BookSortFields, ", "))
+		}
+		if q.Order != "" && q.Order != "asc" && q.Order != "desc" {
+			errs.Add("order", "must be asc or desc")
+		}
+		q.SortKeys = []SortKey{{Field: q.SortBy, Desc: q.Order != "asc"}}
+	case q.Shelf != nil:
+		q.SortKeys = ShelfSort
+	default:
+		q.SortKeys = DefaultSort
+	}
+	if q.Shelf == nil && slices.ContainsFunc(q.SortKeys, func(key SortKey) bool { return key.Field == "position" }) {
+		errs.Add("sort", "position only applies to the books of a shelf")
+	}
+
+	if q.Limit < 1 {
+		errs.Add("limit", "must be at least 1")
+	} else if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	if q.Offset < 0 {
+		errs.Add("offset", "must not be negative")
+	}
+
+	if q.After != "" {
+		switch cursor, err := DecodeBookCursor(q.After); {
+		case q.Offset != 0:
+			errs.Add("after", "cannot be combined with offset")
+		case q.Query != "":
+			errs.Add("after", "is not supported with q")
+		case err != nil:
+			errs.Add("after", err.Error())
+		case cursor.Sort != FormatSort(q.SortKeys):
+			errs.Add("after", "cursor was issued for a different sort order")
+		default:
+			q.Cursor = cursor
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SortKey is one field of a multi-key sort.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses a comma separated list of sort fields, each optionally
+// prefixed with - for descending order. Fields must be in BookSortFields
+// and may appear once.
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		key := SortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if key.Field == "" {
+			return nil, errors.New("empty sort field")
+		}
+		if _, ok := BookSortValue(&Book{}, key.Field); !ok {
+			return nil, errors.New("unknown field " + key.Field + ", expected one of " + strings.Join(BookSortFields, ", "))
+		}
+		if seen[key.Field] {
+			return nil, errors.New("duplicate field " + key.Field)
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// FormatSort renders keys in the syntax ParseSort accepts.
+func FormatSort(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// FieldError describes one invalid query parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is returned by Validate when the query has invalid
+// parameters.
+type ValidationErrors []FieldError
+
+func (e *ValidationErrors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// BookFacets counts, per tag and per author, the books matching a
+// ListBooksQuery, most frequent first.
+type BookFacets struct {
+	Tags    []FacetCount `json:"tags"`
+	Authors []FacetCount `json:"authors"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Timestamp is a query parameter given either as an RFC 3339 timestamp or
+// as a date (2006-01-02), which means midnight UTC.
+type Timestamp struct {
+	time.Time
+}
+
+func (t *Timestamp) UnmarshalParam(param string) error {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if parsed, err := time.Parse(layout, param); err == nil {
+			t.Time = parsed.UTC()
+			return nil
+		}
+	}
+	return errors.New("invalid timestamp " + param + ", expected RFC 3339 or YYYY-MM-DD")
+}
@@ -0,0 +1,50 @@
+package domain
+
+import "math"
+
+// Ratings are given in half stars
+const (
+	MinRating  = 0.5
+	MaxRating  = 5.0
+	RatingStep = 0.5
+)
+
+const ratingRule = "must be between 0.5 and 5 in steps of 0.5"
+
+// ValidRating reports whether r is a whole number of half stars between
+// MinRating and MaxRating.
+func ValidRating(r float64) bool {
+	return r >= MinRating && r <= MaxRating && math.Mod(r, RatingStep) == 0
+}
+
+// RatingStats summarizes the ratings of a user's books. Average is nil
+// when no book is rated; Histogram has a bucket for every possible rating,
+// from MinRating up.
+type RatingStats struct {
+	Rated     int64          `json:"rated"`
+	Unrated   int64          `json:"unrated"`
+	Average   *float64       `json:"average"`
+	Histogram []RatingBucket `json:"histogram"`
+}
+
+type RatingBucket struct {
+	Rating float64 `json:"rating"`
+	Count  int64   `json:"count"`
+}
+
+// NewRatingStats builds the statistics from the number of books with each
+// rating, where a rating of 0 counts the unrated books.
+func NewRatingStats(counts map[float64]int64) *RatingStats {
+	stats := &RatingStats{Unrated: counts[0], Histogram: []RatingBucket{}}
+	var sum float64
+	for r := MinRating; r <= MaxRating; r += RatingStep {
+		stats.Histogram = append(stats.Histogram, RatingBucket{Rating: r, Count: counts[r]})
+		stats.Rated += counts[r]
+		sum += r * float64(counts[r])
+	}
+	if stats.Rated > 0 {
+		average := math.Round(sum/float64(stats.Rated)*100) / 100
+		stats.Average = &average
+	}
+	return stats
+}
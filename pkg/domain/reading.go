@@ -0,0 +1,208 @@
+package domain
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Reading statuses
+const (
+	StatusWantToRead = "want-to-read"
+	StatusReading    = "reading"
+	StatusFinished   = "finished"
+	StatusAbandoned  = "abandoned"
+	// StatusNone filters for books without a reading status
+	StatusNone = "none"
+)
+
+var ReadingStatuses = []string{StatusWantToRead, StatusReading, StatusFinished, StatusAbandoned}
+
+func validStatus(status string) bool {
+	return status == "" || slices.Contains(ReadingStatuses, status)
+}
+
+// ReadingSession is a stretch of time spent reading a book. Pages is how
+// many pages were read in it, if known.
+type ReadingSession struct {
+	ID        string    `bson:"-" json:"id"`
+	BookID    string    `bson:"-" json:"book_id"`
+	UserID    string    `bson:"-" json:"user_id"`
+	Start     time.Time `bson:"start" json:"start"`
+	End       time.Time `bson:"end" json:"end"`
+	Pages     *int      `bson:"pages,omitempty" json:"pages,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Duration is the length of the session in seconds.
+func (s *ReadingSession) Duration() int64 {
+	return int64(s.End.Sub(s.Start) / time.Second)
+}
+
+type CreateReadingSessionRequest struct {
+	Start time.Time `json:"start" binding:"required"`
+	End   time.Time `json:"end" binding:"required"`
+	Pages *int      `json:"pages" binding:"omitempty,min=0"`
+}
+
+// MaxSessionLength is the longest reading session that can be logged.
+const MaxSessionLength = 24 * time.Hour
+
+// Validate checks that the session ends after it starts, lasts at most
+// MaxSessionLength and is not in the future.
+func (r *CreateReadingSessionRequest) Validate(now time.Time) error {
+	var errs ValidationErrors
+	switch {
+	case !r.End.After(r.Start):
+		errs.Add("end", "must be after start")
+	case r.End.Sub(r.Start) > MaxSessionLength:
+		errs.Add("end", "session cannot be longer than 24 hours")
+	}
+	// Allow for clocks running a little ahead of ours
+	if r.End.After(now.Add(5 * time.Minute)) {
+		errs.Add("end", "must not be in the future")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// DeriveReading fills in the reading fields implied by those the update
+// sets, given the book as it is now:
+//   - a page or progress on a book not yet being read starts reading it
+//   - starting to read sets StartedAt and, for a book read before, clears
+//     FinishedAt
+//   - finishing sets FinishedAt and, unless the update says otherwise,
+//     moves to the last page
+//   - a new page sets Progress when the number of pages is known
+//
+// It fails with ValidationErrors when the page is past the end of the book,
+// the book was finished before it was started or the rating is not a whole
+// number of half stars.
+func (u *UpdateBookRequest) DeriveReading(book *Book, now time.Time) error {
+	status := book.Status
+	if u.Status != nil {
+		status = *u.Status
+	}
+	pages := intValue(book.Pages)
+	if u.Pages != nil {
+		pages = *u.Pages
+	}
+	page := intValue(book.CurrentPage)
+	if u.CurrentPage != nil {
+		page = *u.CurrentPage
+	}
+	pageChanged := page != intValue(book.CurrentPage)
+	progressChanged := u.Progress != nil && *u.Progress != floatValue(book.Progress)
+
+	var errs ValidationErrors
+	if u.Rating != nil && *u.Rating != 0 && !ValidRating(*u.Rating) {
+		errs.Add("rating", ratingRule)
+	}
+	if pages > 0 && page > pages {
+		errs.Add("current_page", "is past the last page of the book")
+	}
+
+	if status == book.Status && (status == "" || status == StatusWantToRead) &&
+		(pageChanged && page > 0 || progressChanged && *u.Progress > 0) {
+		status = StatusReading
+		u.Status = &status
+	}
+	if pageChanged && !progressChanged && pages > 0 {
+		progress := math.Round(float64(page)*1000/float64(pages)) / 10
+		u.Progress = &progress
+	}
+
+	startedAt, finishedAt := timeValue(book.StartedAt), timeValue(book.FinishedAt)
+	if u.StartedAt != nil {
+		startedAt = *u.StartedAt
+	}
+	if u.FinishedAt != nil {
+		finishedAt = *u.FinishedAt
+	}
+	if status != book.Status {
+		switch status {
+		case StatusReading:
+			if startedAt.IsZero() {
+				startedAt = now
+				u.StartedAt = &startedAt
+			}
+			if book.Status == StatusFinished && finishedAt.Equal(timeValue(book.FinishedAt)) {
+				finishedAt = time.Time{}
+				u.FinishedAt = &finishedAt
+			}
+		case StatusFinished:
+			if finishedAt.IsZero() {
+				finishedAt = now
+				u.FinishedAt = &finishedAt
+			}
+			if !pageChanged && !progressChanged {
+				full := 100.0
+				u.Progress = &full
+				if pages > 0 {
+					u.CurrentPage = &pages
+				}
+			}
+		}
+	}
+	if !startedAt.IsZero() && !finishedAt.IsZero() && finishedAt.Before(startedAt) {
+		errs.Add("finished_at", "is before started_at")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// DeriveReading fills in the reading fields of a new book the way
+// UpdateBookRequest.DeriveReading does for an existing one.
+func (r *CreateBookRequest) DeriveReading(now time.Time) error {
+	u := r.Replace()
+	if err := u.DeriveReading(&Book{}, now); err != nil {
+		return err
+	}
+	r.Status = *u.Status
+	r.CurrentPage = optionalInt(*u.CurrentPage)
+	r.Progress = optionalFloat(*u.Progress)
+	r.StartedAt = optionalTime(*u.StartedAt)
+	r.FinishedAt = optionalTime(*u.FinishedAt)
+	return nil
+}
+
+func floatValue(p *float64) float64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+func timeValue(p *time.Time) time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return *p
+}
+
+func optionalInt(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
+
+func optionalFloat(f float64) *float64 {
+	if f == 0 {
+		return nil
+	}
+	return &f
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
@@ -0,0 +1,192 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// Revision actions
+const (
+	RevisionCreated     = "created"
+	RevisionUpdated     = "updated"
+	RevisionDeleted     = "deleted"
+	RevisionRestored    = "restored"
+	RevisionReverted    = "reverted"
+	RevisionNoteCreated = "note_created"
+	RevisionNoteUpdated = "note_updated"
+	RevisionNoteDeleted = "note_deleted"
+)
+
+// Revision is one entry of a book's append-only history: who changed what,
+// and when. Changes to the book's notes are logged with the book too.
+type Revision struct {
+	ID     string `bson:"-" json:"id"`
+	BookID string `bson:"-" json:"book_id"`
+	// UserID is the user who made the change
+	UserID  string        `bson:"-" json:"user_id"`
+	NoteID  string        `bson:"-" json:"note_id,omitempty"`
+	Action  string        `bson:"action" json:"action"`
+	Changes []FieldChange `bson:"changes" json:"changes"`
+	// Book holds the book's fields as they were right after the change,
+	// which is what reverting to this revision restores
+	Book BookSnapshot `bson:"book" json:"book"`
+	// Version is the book's version right after the change
+	Version int64 `bson:"version" json:"version"`
+	// RevertedTo is the revision a revert went back to
+	RevertedTo string    `bson:"reverted_to,omitempty" json:"reverted_to,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// FieldChange records the old and new value of a single field. Old is nil
+// for created documents and New for deleted ones.
+type FieldChange struct {
+	Field string `bson:"field" json:"field"`
+	Old   any    `bson:"old" json:"old"`
+	New   any    `bson:"new" json:"new"`
+}
+
+// BookSnapshot holds the editable fields of a book.
+type BookSnapshot struct {
+	Title       string     `bson:"title" json:"title"`
+	Author      string     `bson:"author" json:"author"`
+	Description string     `bson:"description" json:"description"`
+	ISBN        string     `bson:"isbn" json:"isbn"`
+	Publisher   string     `bson:"publisher,omitempty" json:"publisher,omitempty"`
+	Year        *int       `bson:"year,omitempty" json:"year,omitempty"`
+	Edition     string     `bson:"edition,omitempty" json:"edition,omitempty"`
+	Pages       *int       `bson:"pages,omitempty" json:"pages,omitempty"`
+	CoverURL    string     `bson:"cover_url,omitempty" json:"cover_url,omitempty"`
+	Tags        []string   `bson:"tags" json:"tags"`
+	Status      string     `bson:"status,omitempty" json:"status,omitempty"`
+	CurrentPage *int       `bson:"current_page,omitempty" json:"current_page,omitempty"`
+	Progress    *float64   `bson:"progress,omitempty" json:"progress,omitempty"`
+	StartedAt   *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt  *time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	Rating      *float64   `bson:"rating,omitempty" json:"rating,omitempty"`
+	Review      string     `bson:"review,omitempty" json:"review,omitempty"`
+}
+
+func NewBookSnapshot(b *Book) BookSnapshot {
+	return BookSnapshot{
+		Title:       b.Title,
+		Author:      b.Author,
+		Description: b.Description,
+		ISBN:        b.ISBN,
+		Publisher:   b.Publisher,
+		Year:        b.Year,
+		Edition:     b.Edition,
+		Pages:       b.Pages,
+		CoverURL:    b.CoverURL,
+		Tags:        b.Tags,
+		Status:      b.Status,
+		CurrentPage: b.CurrentPage,
+		Progress:    b.Progress,
+		StartedAt:   b.StartedAt,
+		FinishedAt:  b.FinishedAt,
+		Rating:      b.Rating,
+		Review:      b.Review,
+	}
+}
+
+// Update returns the request that turns a book back into the snapshot.
+func (s BookSnapshot) Update() *UpdateBookRequest {
+	tags := s.Tags
+	year, pages := intValue(s.Year), intValue(s.Pages)
+	page, progress := intValue(s.CurrentPage), floatValue(s.Progress)
+	startedAt, finishedAt := timeValue(s.StartedAt), timeValue(s.FinishedAt)
+	rating := floatValue(s.Rating)
+	return &UpdateBookRequest{
+		Title:       &s.Title,
+		Author:      &s.Author,
+		Description: &s.Description,
+		ISBN:        &s.ISBN,
+		Publisher:   &s.Publisher,
+		Year:        &year,
+		Edition:     &s.Edition,
+		Pages:       &pages,
+		CoverURL:    &s.CoverURL,
+		Tags:        &tags,
+		Status:      &s.Status,
+		CurrentPage: &page,
+		Progress:    &progress,
+		StartedAt:   &startedAt,
+		FinishedAt:  &finishedAt,
+		Rating:      &rating,
+		Review:      &s.Review,
+	}
+}
+
+// DiffBooks lists the editable fields that differ between two versions of
+// a book. Either side may be nil for a created or deleted book.
+func DiffBooks(before, after *Book) []FieldChange {
+	var old, cur BookSnapshot
+	if before != nil {
+		old = NewBookSnapshot(before)
+	}
+	if after != nil {
+		cur = NewBookSnapshot(after)
+	}
+
+	d := diff{created: before == nil, deleted: after == nil, changes: []FieldChange{}}
+	d.add("title", old.Title != cur.Title, old.Title, cur.Title)
+	d.add("author", old.Author != cur.Author, old.Author, cur.Author)
+	d.add("description", old.Description != cur.Description, old.Description, cur.Description)
+	d.add("isbn", old.ISBN != cur.ISBN, old.ISBN, cur.ISBN)
+	d.add("publisher", old.Publisher != cur.Publisher, old.Publisher, cur.Publisher)
+	d.add("year", intValue(old.Year) != intValue(cur.Year), old.Year, cur.Year)
+	d.add("edition", old.Edition != cur.Edition, old.Edition, cur.Edition)
+	d.add("pages", intValue(old.Pages) != intValue(cur.Pages), old.Pages, cur.Pages)
+	d.add("cover_url", old.CoverURL != cur.CoverURL, old.CoverURL, cur.CoverURL)
+	d.add("tags", !slices.Equal(old.Tags, cur.Tags), old.Tags, cur.Tags)
+	d.add("status", old.Status != cur.Status, old.Status, cur.Status)
+	d.add("current_page", intValue(old.CurrentPage) != intValue(cur.CurrentPage), old.CurrentPage, cur.CurrentPage)
+	d.add("progress", floatValue(old.Progress) != floatValue(cur.Progress), old.Progress, cur.Progress)
+	d.add("started_at", !timeValue(old.StartedAt).Equal(timeValue(cur.StartedAt)), old.StartedAt, cur.StartedAt)
+	d.add("finished_at", !timeValue(old.FinishedAt).Equal(timeValue(cur.FinishedAt)), old.FinishedAt, cur.FinishedAt)
+	d.add("rating", floatValue(old.Rating) != floatValue(cur.Rating), old.Rating, cur.Rating)
+	d.add("review", old.Review != cur.Review, old.Review, cur.Review)
+	return d.changes
+}
+
+// DiffNotes lists the fields that differ between two versions of a note.
+// Either side may be nil for a created or deleted note.
+func DiffNotes(before, after *Note) []FieldChange {
+	var old, cur Note
+	if before != nil {
+		old = *before
+	}
+	if after != nil {
+		cur = *after
+	}
+
+	d := diff{created: before == nil, deleted: after == nil, changes: []FieldChange{}}
+	d.add("body", old.Body != cur.Body, old.Body, cur.Body)
+	d.add("page", intValue(old.Page) != intValue(cur.Page), old.Page, cur.Page)
+	d.add("location", old.Location != cur.Location, old.Location, cur.Location)
+	return d.changes
+}
+
+type diff struct {
+	created, deleted bool
+	changes          []FieldChange
+}
+
+func (d *diff) add(field string, changed bool, oldValue, newValue any) {
+	if !changed {
+		return
+	}
+	if d.created {
+		oldValue = nil
+	}
+	if d.deleted {
+		newValue = nil
+	}
+	d.changes = append(d.changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+}
+
+func intValue(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
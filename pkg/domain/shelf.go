@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// Shelf is a named collection of a user's books in an order of the user's
+// choosing, given by BookIDs. A book may be on any number of shelves.
+// Shelves nest one level deep: a shelf with a ParentID has no shelves of
+// its own.
+type Shelf struct {
+	ID          string    `bson:"-" json:"id"`
+	UserID      string    `bson:"-" json:"user_id"`
+	ParentID    string    `bson:"-" json:"parent_id,omitempty"`
+	Name        string    `bson:"name" json:"name"`
+	Description string    `bson:"description" json:"description"`
+	BookIDs     []string  `bson:"-" json:"book_ids"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+type CreateShelfRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	ParentID    string `json:"parent_id"`
+}
+
+// UpdateShelfRequest changes the given fields of a shelf. An empty
+// ParentID moves the shelf to the top level.
+type UpdateShelfRequest struct {
+	Name        *string `json:"name,omitempty" binding:"omitempty,min=1"`
+	Description *string `json:"description,omitempty"`
+	ParentID    *string `json:"parent_id,omitempty"`
+}
+
+// PlaceShelfBookRequest puts a book on a shelf, or moves it when it is on
+// the shelf already. Position counts from 0; without one the book goes
+// last.
+type PlaceShelfBookRequest struct {
+	BookID   string `json:"book_id" binding:"required"`
+	Position *int   `json:"position" binding:"omitempty,min=0"`
+}
+
+// ReorderShelfRequest lists every book on a shelf in its new order.
+type ReorderShelfRequest struct {
+	BookIDs []string `json:"book_ids" binding:"required"`
+}
+
+var ErrShelfOrder = errors.New("book_ids must list every book on the shelf exactly once")
+
+// ShelfSort lists the books of a shelf in the order they were put in.
+var ShelfSort = []SortKey{{Field: "position"}}
+
+// SameBooks reports whether ids holds the books of the shelf, each once,
+// in any order.
+func (s *Shelf) SameBooks(ids []string) bool {
+	if len(ids) != len(s.BookIDs) {
+		return false
+	}
+	on := make(map[string]bool, len(s.BookIDs))
+	for _, id := range s.BookIDs {
+		on[id] = true
+	}
+	for _, id := range ids {
+		if !on[id] {
+			return false
+		}
+		delete(on, id)
+	}
+	return true
+}
+
+// Positions maps the IDs of the books on the shelf to their place on it.
+func (s *Shelf) Positions() map[string]int {
+	positions := make(map[string]int, len(s.BookIDs))
+	for i, id := range s.BookIDs {
+		positions[id] = i
+	}
+	return positions
+}
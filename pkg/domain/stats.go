@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Period formats of the reading statistics
+const (
+	MonthPeriod = "2006-01"
+	YearPeriod  = "2006"
+	DayPeriod   = "2006-01-02"
+)
+
+// StatsQuery restricts the reading statistics to a date range: books
+// added or finished, and sessions started, at or after From and before To.
+type StatsQuery struct {
+	From *Timestamp `form:"from"`
+	To   *Timestamp `form:"to"`
+}
+
+func (q *StatsQuery) Validate() error {
+	var errs ValidationErrors
+	if q.From != nil && q.To != nil && !q.From.Before(q.To.Time) {
+		errs.Add("from", "must be before to")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Contains reports whether t falls in the range.
+func (q *StatsQuery) Contains(t time.Time) bool {
+	return (q.From == nil || !t.Before(q.From.Time)) && (q.To == nil || t.Before(q.To.Time))
+}
+
+// LastDay is the day streaks run up to: today, or the last day of the
+// range when it ends earlier.
+func (q *StatsQuery) LastDay(now time.Time) time.Time {
+	if q.To != nil && q.To.Before(now) {
+		now = q.To.Add(-time.Nanosecond)
+	}
+	return now.UTC().Truncate(24 * time.Hour)
+}
+
+// ReadingStats is the reading dashboard of a user. Periods are UTC: months
+// as 2006-01, years as 2006 and ISO weeks as 2006-W01, in ascending order.
+// AverageDaysToFinish is nil when no finished book has a start date.
+type ReadingStats struct {
+	AddedPerMonth       []PeriodCount  `json:"added_per_month"`
+	FinishedPerYear     []PeriodCount  `json:"finished_per_year"`
+	TopAuthors          []FacetCount   `json:"top_authors"`
+	TopTags             []FacetCount   `json:"top_tags"`
+	AverageDaysToFinish *float64       `json:"average_days_to_finish"`
+	PagesPerWeek        []PeriodCount  `json:"pages_per_week"`
+	Streaks             ReadingStreaks `json:"streaks"`
+}
+
+type PeriodCount struct {
+	Period string `bson:"_id" json:"period"`
+	Count  int64  `bson:"count" json:"count"`
+}
+
+// ReadingStreaks count runs of consecutive days with reading sessions.
+// Current is the run reaching the last day, or the day before as the
+// streak is alive until the day is over.
+type ReadingStreaks struct {
+	Current  int    `json:"current"`
+	Longest  int    `json:"longest"`
+	LastRead string `json:"last_read,omitempty"`
+}
+
+// WeekPeriod formats the ISO week of t.
+func WeekPeriod(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// NewReadingStreaks finds the streaks in days, the distinct days with
+// reading sessions formatted as DayPeriod in ascending order.
+func NewReadingStreaks(days []string, lastDay time.Time) ReadingStreaks {
+	var streaks ReadingStreaks
+	var run int
+	var previous time.Time
+	for _, period := range days {
+		day, err := time.Parse(DayPeriod, period)
+		if err != nil {
+			continue
+		}
+		if !previous.IsZero() && day.Sub(previous) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		previous = day
+		streaks.Longest = max(streaks.Longest, run)
+	}
+	if !previous.IsZero() {
+		streaks.LastRead = previous.Format(DayPeriod)
+		if lastDay.Sub(previous) <= 24*time.Hour {
+			streaks.Current = run
+		}
+	}
+	return streaks
+}
+
+// AverageDays converts an average duration to days, to one decimal.
+func AverageDays(d time.Duration) float64 {
+	return math.Round(d.Hours()/24*10) / 10
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeTag returns the canonical form tags are stored in: Unicode NFC,
+// case folded, trimmed and with inner runs of white space made a single
+// space, so "Sci-Fi " and "sci-fi" are the same tag.
+func NormalizeTag(tag string) string {
+	tag = norm.NFC.String(cases.Fold().String(norm.NFC.String(tag)))
+	return strings.Join(strings.Fields(tag), " ")
+}
+
+// NormalizeTags normalizes each tag, dropping those left empty and the
+// duplicates, and keeps the order of the rest.
+func NormalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// ListTagsQuery selects the tags GET /api/tags reports. Prefix narrows them
+// down for autocompletion.
+type ListTagsQuery struct {
+	Prefix string `form:"prefix"`
+	Limit  int    `form:"limit,default=100" binding:"min=1,max=1000"`
+}
+
+type RenameTagRequest struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+}
+
+// MergeTagsRequest replaces each of Tags with Into on every book.
+type MergeTagsRequest struct {
+	Tags []string `json:"tags" binding:"required,min=1"`
+	Into string   `json:"into" binding:"required"`
+}
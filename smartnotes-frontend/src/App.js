@@ -2,32 +2,119 @@ import React, { useState, useEffect } from "react";
 
 const API = "http://localhost:3001/api";
 
+// Обновление токенов, которое сейчас выполняется. Refresh-токен одноразовый:
+// повторное предъявление сервер считает кражей и завершает сессию, поэтому
+// все запросы, получившие 401 одновременно, ждут одного обновления.
+let refreshing = null;
+
+const emptyForm = { title: "", author: "", description: "", tags: "" };
+
+// Поля формы редактирования для книги
+const bookForm = (book) => ({
+  title: book.title || "",
+  author: book.author || "",
+  description: book.description || "",
+  tags: (book.tags || []).join(", "),
+});
+
 function App() {
   const [token, setToken] = useState(localStorage.getItem("token") || "");
   const [books, setBooks] = useState([]);
-  const [form, setForm] = useState({ title: "", author: "", description: "", tags: "" });
+  const [form, setForm] = useState(emptyForm);
   const [auth, setAuth] = useState({ email: "", password: "", username: "" });
   const [isLogin, setIsLogin] = useState(true);
   const [viewBook, setViewBook] = useState(null);
   const [editingBook, setEditingBook] = useState(null);
 
-  // Получить книги
-  useEffect(() => {
-    if (token) {
-      fetch(`${API}/books`, {
-        headers: { Authorization: `Bearer ${token}` }
-      })
-        .then(async res => {
-          if (!res.ok) {
-            const err = await res.json().catch(() => ({}));
-            console.error("Ошибка при получении книг:", err);
-            alert(err.error || "Ошибка при получении книг");
-            return { books: [] };
+  const saveTokens = (data) => {
+    setToken(data.token);
+    localStorage.setItem("token", data.token);
+    localStorage.setItem("refreshToken", data.refresh_token);
+  };
+
+  const logout = () => {
+    setToken("");
+    localStorage.removeItem("token");
+    localStorage.removeItem("refreshToken");
+  };
+
+  // Обновить токены по refresh-токену. Возвращает false, если сессия
+  // закончилась и нужно войти заново.
+  const refreshTokens = () => {
+    if (!refreshing) {
+      const refreshToken = localStorage.getItem("refreshToken");
+      refreshing = (refreshToken
+        ? fetch(`${API}/auth/refresh`, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ refresh_token: refreshToken }),
+          }).then(res => (res.ok ? res.json() : null))
+        : Promise.resolve(null))
+        .catch(() => null)
+        .then(data => {
+          if (!data) {
+            logout();
+            return false;
           }
-          return res.json();
+          saveTokens(data);
+          return true;
         })
-        .then(data => setBooks(data.books || data || []));
+        .finally(() => {
+          refreshing = null;
+        });
+    }
+    return refreshing;
+  };
+
+  // Запрос к API с токеном. Токен доступа живёт 15 минут: на 401 он
+  // обновляется по refresh-токену и запрос повторяется один раз.
+  const apiFetch = async (path, options = {}, retry = true) => {
+    const sent = localStorage.getItem("token");
+    const res = await fetch(`${API}${path}`, {
+      ...options,
+      headers: { ...options.headers, Authorization: `Bearer ${sent}` },
+    });
+    if (res.status !== 401 || !retry) {
+      return res;
     }
+    // Если токен уже обновил другой запрос, достаточно повторить свой
+    if (localStorage.getItem("token") === sent && !(await refreshTokens())) {
+      return res;
+    }
+    return apiFetch(path, options, false);
+  };
+
+  // Получить книгу вместе с её ETag
+  const fetchBook = async (id) => {
+    const res = await apiFetch(`/books/${id}`);
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      console.error("Ошибка при получении книги:", data);
+      alert(data.error || "Ошибка при получении книги");
+      return null;
+    }
+    return { ...data, etag: res.headers.get("ETag") };
+  };
+
+  // Получить книги. У каждой книги в списке есть etag для If-Match.
+  const loadBooks = () =>
+    apiFetch("/books")
+      .then(async res => {
+        if (!res.ok) {
+          const err = await res.json().catch(() => ({}));
+          console.error("Ошибка при получении книг:", err);
+          alert(err.error || "Ошибка при получении книг");
+          return { books: [] };
+        }
+        return res.json();
+      })
+      .then(data => setBooks(data.books || data || []));
+
+  useEffect(() => {
+    if (token) {
+      loadBooks();
+    }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
   }, [token]);
 
   // Аутентификация
@@ -46,8 +133,7 @@ function App() {
       const data = await res.json().catch(() => ({}));
       console.log("Ответ сервера:", data);
       if (data.token) {
-        setToken(data.token);
-        localStorage.setItem("token", data.token);
+        saveTokens(data);
       } else {
         alert(data.error || "Auth error");
       }
@@ -61,12 +147,9 @@ function App() {
   const handleCreate = async (e) => {
     e.preventDefault();
     try {
-      const res = await fetch(`${API}/books`, {
+      const res = await apiFetch("/books", {
         method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-          Authorization: `Bearer ${token}`,
-        },
+        headers: { "Content-Type": "application/json" },
         body: JSON.stringify({
           ...form,
           tags: form.tags.split(",").map((t) => t.trim()),
@@ -74,21 +157,9 @@ function App() {
       });
       const data = await res.json().catch(() => ({}));
       if (res.ok) {
-        setForm({ title: "", author: "", description: "", tags: "" });
+        setForm(emptyForm);
         // обновить список книг
-        fetch(`${API}/books`, {
-          headers: { Authorization: `Bearer ${token}` },
-        })
-          .then(async res => {
-            if (!res.ok) {
-              const err = await res.json().catch(() => ({}));
-              console.error("Ошибка при получении книг:", err);
-              alert(err.error || "Ошибка при получении книг");
-              return { books: [] };
-            }
-            return res.json();
-          })
-          .then(data => setBooks(data.books || data || []));
+        loadBooks();
       } else {
         console.error("Ошибка при создании книги:", data);
         alert(data.error || "Ошибка при создании книги");
@@ -99,13 +170,24 @@ function App() {
     }
   };
 
-  // Удалить книгу
-  const handleDelete = async (id) => {
+  // Удалить книгу. If-Match с etag из списка не даёт удалить книгу,
+  // изменённую с тех пор в другом месте.
+  const handleDelete = async (book) => {
+    const id = book.id || book._id;
     try {
-      const res = await fetch(`${API}/books/${id}`, {
+      const etag = book.etag || (await fetchBook(id))?.etag;
+      if (!etag) {
+        return;
+      }
+      const res = await apiFetch(`/books/${id}`, {
         method: "DELETE",
-        headers: { Authorization: `Bearer ${token}` },
+        headers: { "If-Match": etag },
       });
+      if (res.status === 412) {
+        alert("Книга была изменена, список обновлён");
+        loadBooks();
+        return;
+      }
       if (!res.ok) {
         const data = await res.json().catch(() => ({}));
         console.error("Ошибка при удалении книги:", data);
@@ -121,64 +203,76 @@ function App() {
 
   const handleView = async (id) => {
     try {
-      const res = await fetch(`${API}/books/${id}`, {
-        headers: { Authorization: `Bearer ${token}` },
-      });
-      const data = await res.json().catch(() => ({}));
-      if (!res.ok) {
-        console.error("Ошибка при получении книги:", data);
-        alert(data.error || "Ошибка при получении книги");
-        return;
+      const book = await fetchBook(id);
+      if (book) {
+        setViewBook(book);
       }
-      setViewBook(data);
     } catch (err) {
       console.error("Ошибка сети при получении книги:", err);
       alert("Ошибка сети при получении книги");
     }
   };
 
-  const startEdit = (book) => {
+  // Начать редактирование. Без etag книга запрашивается заново, чтобы
+  // изменения ушли с If-Match той версии, которую видит пользователь.
+  const startEdit = async (book) => {
+    if (!book.etag) {
+      try {
+        book = await fetchBook(book.id || book._id);
+      } catch (err) {
+        console.error("Ошибка сети при получении книги:", err);
+        alert("Ошибка сети при получении книги");
+        return;
+      }
+      if (!book) {
+        return;
+      }
+    }
     setEditingBook(book);
-    setForm({
-      title: book.title,
-      author: book.author,
-      description: book.description,
-      tags: (book.tags || []).join(", "),
-    });
+    setForm(bookForm(book));
   };
 
+  // Сохранить книгу. PUT заменил бы книгу целиком и стёр поля, которых нет
+  // в форме, поэтому отправляется merge patch только с изменёнными полями.
   const handleUpdate = async (e) => {
     e.preventDefault();
+    const initial = bookForm(editingBook);
+    const patch = {};
+    for (const field of ["title", "author", "description"]) {
+      if (form[field] !== initial[field]) {
+        patch[field] = form[field];
+      }
+    }
+    if (form.tags !== initial.tags) {
+      patch.tags = form.tags.split(",").map((t) => t.trim()).filter(Boolean);
+    }
+    if (Object.keys(patch).length === 0) {
+      setEditingBook(null);
+      setForm(emptyForm);
+      return;
+    }
     try {
-      const res = await fetch(`${API}/books/${editingBook.id || editingBook._id}`, {
-        method: "PUT",
+      const res = await apiFetch(`/books/${editingBook.id || editingBook._id}`, {
+        method: "PATCH",
         headers: {
-          "Content-Type": "application/json",
-          Authorization: `Bearer ${token}`,
+          "Content-Type": "application/merge-patch+json",
+          "If-Match": editingBook.etag,
         },
-        body: JSON.stringify({
-          ...form,
-          tags: form.tags.split(",").map((t) => t.trim()),
-        }),
+        body: JSON.stringify(patch),
       });
       const data = await res.json().catch(() => ({}));
+      if (res.status === 412) {
+        alert("Книга была изменена, откройте её для редактирования заново");
+        setEditingBook(null);
+        setForm(emptyForm);
+        loadBooks();
+        return;
+      }
       if (res.ok) {
         setEditingBook(null);
-        setForm({ title: "", author: "", description: "", tags: "" });
+        setForm(emptyForm);
         // обновить список книг
-        fetch(`${API}/books`, {
-          headers: { Authorization: `Bearer ${token}` },
-        })
-          .then(async res => {
-            if (!res.ok) {
-              const err = await res.json().catch(() => ({}));
-              console.error("Ошибка при получении книг:", err);
-              alert(err.error || "Ошибка при получении книг");
-              return { books: [] };
-            }
-            return res.json();
-          })
-          .then(data => setBooks(data.books || data || []));
+        loadBooks();
       } else {
         console.error("Ошибка при обновлении книги:", data);
         alert(data.error || "Ошибка при обновлении книги");
@@ -252,7 +346,7 @@ function App() {
         />
         <button type="submit">{editingBook ? "Сохранить" : "Добавить книгу"}</button>
         {editingBook && (
-          <button type="button" onClick={() => { setEditingBook(null); setForm({ title: "", author: "", description: "", tags: "" }); }}>
+          <button type="button" onClick={() => { setEditingBook(null); setForm(emptyForm); }}>
             Отмена
           </button>
         )}
@@ -263,7 +357,7 @@ function App() {
             <b>{b.title}</b> — {b.author} <br />
             <i>{b.description}</i> <br />
             Теги: {b.tags && b.tags.join(", ")}
-            <button onClick={() => handleDelete(b.id)} style={{ marginLeft: 10 }}>
+            <button onClick={() => handleDelete(b)} style={{ marginLeft: 10 }}>
               Удалить
             </button>
             <button onClick={() => startEdit(b)} style={{ marginLeft: 10 }}>
@@ -275,7 +369,7 @@ function App() {
           </li>
         ))}
       </ul>
-      <button onClick={() => { setToken(""); localStorage.removeItem("token"); }}>
+      <button onClick={logout}>
         Выйти
       </button>
       {viewBook && (